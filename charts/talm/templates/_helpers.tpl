@@ -3,7 +3,7 @@
 {{- end }}
 
 {{- define "talm.discovered.machinetype" }}
-{{- (lookup "machinetype" "" "machine-type").spec }}
+{{- lookup "machinetype" "" "machine-type" | dig "spec" "" }}
 {{- end }}
 
 {{- define "talm.discovered.hostname" }}
@@ -17,7 +17,7 @@
 
 {{- define "talm.discovered.disks_info" }}
 # -- Discovered disks:
-{{- range (lookup "disks" "" "").items }}
+{{- range (lookup "disks" "" "" | dig "items" dict) }}
 {{- if .spec.wwid }}
 # {{ .spec.dev_path }}:
 #    model: {{ .spec.model }}
@@ -37,14 +37,14 @@
 {{- define "talm.discovered.default_addresses_by_gateway" }}
 {{- $linkName := "" }}
 {{- $family := "" }}
-{{- range (lookup "routes" "" "").items }}
+{{- range (lookup "routes" "" "" | dig "items" dict) }}
 {{- if and (eq .spec.dst "") (not (eq .spec.gateway "")) (eq .spec.table "main") }}
 {{- $linkName = .spec.outLinkName }}
 {{- $family = .spec.family }}
 {{- end }}
 {{- end }}
 {{- $addresses := list }}
-{{- range (lookup "addresses" "" "").items }}
+{{- range (lookup "addresses" "" "" | dig "items" dict) }}
 {{- if and (eq .spec.linkName $linkName) (eq .spec.family $family) (not (eq .spec.scope "host")) }}
 {{- if not (hasPrefix (printf "%s/" $.Values.floatingIP) .spec.address) }}
 {{- $addresses = append $addresses .spec.address }}
@@ -56,7 +56,7 @@
 
 {{- define "talm.discovered.physical_links_info" }}
 # -- Discovered interfaces:
-{{- range (lookup "links" "" "").items }}
+{{- range (lookup "links" "" "" | dig "items" dict) }}
 {{- if and .spec.busPath (regexMatch "^(eno|eth|enp|enx|ens)" .metadata.id) }}
 # enx{{ .spec.hardwareAddr | replace ":" "" }}:
 #   id: {{ .metadata.id }}
@@ -70,7 +70,7 @@
 {{- end }}
 
 {{- define "talm.discovered.default_link_name" }}
-{{- range (lookup "addresses" "" "").items }}
+{{- range (lookup "addresses" "" "" | dig "items" dict) }}
 {{- if has .spec.address (fromJsonArray (include "talm.discovered.default_addresses" .)) }}
 {{- .spec.linkName }}
 {{- end }}
@@ -78,7 +78,7 @@
 {{- end }}
 
 {{- define "talm.discovered.default_link_name_by_gateway" }}
-{{- range (lookup "routes" "" "").items }}
+{{- range (lookup "routes" "" "" | dig "items" dict) }}
 {{- if and (eq .spec.dst "") (not (eq .spec.gateway "")) }}
 {{- .spec.outLinkName }}
 {{- end }}
@@ -86,7 +86,7 @@
 {{- end }}
 
 {{- define "talm.discovered.default_link_address_by_gateway" }}
-{{- range (lookup "routes" "" "").items }}
+{{- range (lookup "routes" "" "" | dig "items" dict) }}
 {{- if and (eq .spec.dst "") (not (eq .spec.gateway "")) }}
 {{- (lookup "links" "" .spec.outLinkName).spec.hardwareAddr }}
 {{- end }}
@@ -94,7 +94,7 @@
 {{- end }}
 
 {{- define "talm.discovered.default_link_bus_by_gateway" }}
-{{- range (lookup "routes" "" "").items }}
+{{- range (lookup "routes" "" "" | dig "items" dict) }}
 {{- if and (eq .spec.dst "") (not (eq .spec.gateway "")) }}
 {{- (lookup "links" "" .spec.outLinkName).spec.hardwareAddr }}
 {{- end }}
@@ -102,7 +102,7 @@
 {{- end }}
 
 {{- define "talm.discovered.default_link_selector_by_gateway" }}
-{{- range (lookup "routes" "" "").items }}
+{{- range (lookup "routes" "" "" | dig "items" dict) }}
 {{- if and (eq .spec.dst "") (not (eq .spec.gateway "")) }}
 {{- with (lookup "links" "" .spec.outLinkName) }}
 busPath: {{ .spec.busPath }}
@@ -117,7 +117,7 @@ busPath: {{ .spec.busPath }}
 {{- end }}
 
 {{- define "talm.discovered.default_gateway" }}
-{{- range (lookup "routes" "" "").items }}
+{{- range (lookup "routes" "" "" | dig "items" dict) }}
 {{- if and (eq .spec.dst "") (not (eq .spec.gateway "")) (eq .spec.table "main") }}
 {{- .spec.gateway }}
 {{- break }}
@@ -4,6 +4,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -35,6 +36,10 @@ var rootCmd = &cobra.Command{
 
 func main() {
 	if err := Execute(); err != nil {
+		var exitErr *commands.ExitCodeError
+		if errors.As(err, &exitErr) {
+			os.Exit(exitErr.Code)
+		}
 		os.Exit(1)
 	}
 }
@@ -90,19 +95,18 @@ func initConfig() {
 	if cmd.HasParent() && cmd.Parent() != rootCmd {
 		cmd = cmd.Parent()
 	}
-	if strings.HasPrefix(cmd.Use, "init") {
-		if strings.HasPrefix(Version, "v") {
-			commands.Config.InitOptions.Version = strings.TrimPrefix(Version, `v`)
-		} else {
-			commands.Config.InitOptions.Version = "0.1.0"
-		}
+	// Version of the library chart shipped in the binary, used by init and chart commands
+	if strings.HasPrefix(Version, "v") {
+		commands.Config.InitOptions.Version = strings.TrimPrefix(Version, `v`)
 	} else {
-		if !strings.HasPrefix(cmd.Use, "completion") {
-			configFile := filepath.Join(commands.Config.RootDir, "Chart.yaml")
-			if err := loadConfig(configFile); err != nil {
-				fmt.Fprintf(os.Stderr, "Error loading configuration: %v\n", err)
-				os.Exit(1)
-			}
+		commands.Config.InitOptions.Version = "0.1.0"
+	}
+	// Commands which work outside of a project
+	if !strings.HasPrefix(cmd.Use, "init") && !strings.HasPrefix(cmd.Use, "completion") && !strings.HasPrefix(cmd.Use, "presets") {
+		configFile := filepath.Join(commands.Config.RootDir, "Chart.yaml")
+		if err := loadConfig(configFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading configuration: %v\n", err)
+			os.Exit(1)
 		}
 	}
 }
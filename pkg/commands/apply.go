@@ -6,16 +6,25 @@ package commands
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
 	"time"
 
 	"github.com/aenix-io/talm/pkg/engine"
+	"github.com/aenix-io/talm/pkg/modeline"
+	"github.com/aenix-io/talm/pkg/yamltools"
 	"github.com/spf13/cobra"
+	"golang.org/x/sync/errgroup"
 	"google.golang.org/protobuf/types/known/durationpb"
 
+	"github.com/siderolabs/talos/cmd/talosctl/pkg/talos/global"
 	"github.com/siderolabs/talos/cmd/talosctl/pkg/talos/helpers"
 	machineapi "github.com/siderolabs/talos/pkg/machinery/api/machine"
 	"github.com/siderolabs/talos/pkg/machinery/client"
@@ -24,20 +33,30 @@ import (
 
 var applyCmdFlags struct {
 	helpers.Mode
-	certFingerprints  []string
-	insecure          bool
-	configFiles       []string // -f/--files
-	talosVersion      string
-	withSecrets       string
-	debug             bool
-	kubernetesVersion string
-	dryRun            bool
-	preserve          bool
-	stage             bool
-	force             bool
-	configTryTimeout  time.Duration
-	nodesFromArgs     bool
-	endpointsFromArgs bool
+	certFingerprints     []string
+	insecure             bool
+	configFiles          []string // -f/--files
+	valueFiles           []string // --values
+	stringValues         []string // --set-string
+	values               []string // --set
+	fileValues           []string // --set-file
+	jsonValues           []string // --set-json
+	literalValues        []string // --set-literal
+	talosVersion         string
+	withSecrets          string
+	debug                bool
+	strict               bool
+	kubernetesVersion    string
+	dryRun               bool
+	preserve             bool
+	stage                bool
+	force                bool
+	configTryTimeout     time.Duration
+	nodesFromArgs        bool
+	endpointsFromArgs    bool
+	talosVersionFromArgs bool
+	parallel             int
+	failFast             bool
 }
 
 var applyCmd = &cobra.Command{
@@ -46,7 +65,14 @@ var applyCmd = &cobra.Command{
 	Long:  ``,
 	Args:  cobra.NoArgs,
 	PreRunE: func(cmd *cobra.Command, args []string) error {
-		if !cmd.Flags().Changed("talos-version") {
+		applyCmdFlags.valueFiles = append(Config.TemplateOptions.ValueFiles, applyCmdFlags.valueFiles...)
+		applyCmdFlags.values = append(Config.TemplateOptions.Values, applyCmdFlags.values...)
+		applyCmdFlags.stringValues = append(Config.TemplateOptions.StringValues, applyCmdFlags.stringValues...)
+		applyCmdFlags.fileValues = append(Config.TemplateOptions.FileValues, applyCmdFlags.fileValues...)
+		applyCmdFlags.jsonValues = append(Config.TemplateOptions.JsonValues, applyCmdFlags.jsonValues...)
+		applyCmdFlags.literalValues = append(Config.TemplateOptions.LiteralValues, applyCmdFlags.literalValues...)
+		applyCmdFlags.talosVersionFromArgs = cmd.Flags().Changed("talos-version")
+		if !applyCmdFlags.talosVersionFromArgs {
 			applyCmdFlags.talosVersion = Config.TemplateOptions.TalosVersion
 		}
 		if !cmd.Flags().Changed("with-secrets") {
@@ -58,6 +84,9 @@ var applyCmd = &cobra.Command{
 		if !cmd.Flags().Changed("debug") {
 			applyCmdFlags.debug = Config.TemplateOptions.Debug
 		}
+		if !cmd.Flags().Changed("strict") {
+			applyCmdFlags.strict = Config.TemplateOptions.Strict
+		}
 		if !cmd.Flags().Changed("preserve") {
 			applyCmdFlags.preserve = Config.UpgradeOptions.Preserve
 		}
@@ -67,6 +96,12 @@ var applyCmd = &cobra.Command{
 		if !cmd.Flags().Changed("force") {
 			applyCmdFlags.force = Config.UpgradeOptions.Force
 		}
+		if applyCmdFlags.parallel < 0 {
+			return fmt.Errorf("--parallel must not be negative")
+		}
+		if applyCmdFlags.parallel > 0 && applyCmdFlags.debug {
+			return fmt.Errorf("cannot use --debug and --parallel together")
+		}
 		applyCmdFlags.nodesFromArgs = len(GlobalArgs.Nodes) > 0
 		applyCmdFlags.endpointsFromArgs = len(GlobalArgs.Endpoints) > 0
 		// Set dummy endpoint to avoid errors on building clinet
@@ -77,6 +112,10 @@ var applyCmd = &cobra.Command{
 		return nil
 	},
 	RunE: func(cmd *cobra.Command, args []string) error {
+		if applyCmdFlags.parallel > 0 {
+			return applyParallel()
+		}
+
 		return WithClientNoNodes(apply(args))
 	},
 }
@@ -84,81 +123,289 @@ var applyCmd = &cobra.Command{
 func apply(args []string) func(ctx context.Context, c *client.Client) error {
 	return func(ctx context.Context, c *client.Client) error {
 		for _, configFile := range applyCmdFlags.configFiles {
-			if err := processModelineAndUpdateGlobals(configFile, applyCmdFlags.nodesFromArgs, applyCmdFlags.endpointsFromArgs, true); err != nil {
+			fileArgs, err := modelineArgs(configFile, applyCmdFlags.nodesFromArgs, applyCmdFlags.endpointsFromArgs)
+			if err != nil {
 				return err
 			}
 
-			opts := engine.Options{
-				TalosVersion:      applyCmdFlags.talosVersion,
-				WithSecrets:       applyCmdFlags.withSecrets,
-				KubernetesVersion: applyCmdFlags.kubernetesVersion,
-				Debug:             applyCmdFlags.debug,
+			if err := applyFile(ctx, fileArgs, configFile, os.Stdout); err != nil {
+				return err
 			}
+		}
+		return nil
+	}
+}
 
-			patches := []string{"@" + configFile}
-			configBundle, err := engine.FullConfigProcess(ctx, opts, patches)
-			if err != nil {
-				return fmt.Errorf("full config processing error: %s", err)
-			}
+// applyResult is the outcome of applying a single config file in parallel mode.
+type applyResult struct {
+	file    string
+	nodes   []string
+	err     error
+	skipped bool
+}
 
-			machineType := configBundle.ControlPlaneCfg.Machine().Type()
-			result, err := engine.SerializeConfiguration(configBundle, machineType)
+// applyParallel applies config files concurrently, each with its own client, and prints a summary.
+func applyParallel() error {
+	results := runParallel(applyCmdFlags.configFiles, applyCmdFlags.parallel, applyCmdFlags.failFast, os.Stdout,
+		func(ctx context.Context, configFile string, out io.Writer) ([]string, error) {
+			fileArgs, err := modelineArgs(configFile, applyCmdFlags.nodesFromArgs, applyCmdFlags.endpointsFromArgs)
 			if err != nil {
-				return fmt.Errorf("error serializing configuration: %s", err)
+				return nil, err
 			}
 
-			withClient := func(f func(ctx context.Context, c *client.Client) error) error {
-				if applyCmdFlags.insecure {
-					return WithClientMaintenance(applyCmdFlags.certFingerprints, f)
-				}
+			return fileArgs.Nodes, applyFile(ctx, fileArgs, configFile, out)
+		})
+
+	return printApplySummary(results)
+}
+
+// runParallel calls apply for every file, at most parallel of them at once.
+//
+// Output of every file is buffered and written to out as a whole once the file is done, so outputs
+// of concurrent files don't interleave. With failFast the first failure cancels the context of
+// in-flight files and files which haven't started yet are skipped.
+func runParallel(files []string, parallel int, failFast bool, out io.Writer, apply func(ctx context.Context, file string, out io.Writer) ([]string, error)) []applyResult {
+	results := make([]applyResult, len(files))
 
-				return WithClientNoNodes(func(ctx context.Context, cli *client.Client) error {
-					if len(GlobalArgs.Nodes) < 1 {
-						configContext := cli.GetConfigContext()
-						if configContext == nil {
-							return errors.New("failed to resolve config context")
-						}
+	eg, ctx := errgroup.WithContext(context.Background())
+	eg.SetLimit(parallel)
 
-						GlobalArgs.Nodes = configContext.Nodes
-					}
+	var outMu sync.Mutex
 
-					ctx = client.WithNodes(ctx, GlobalArgs.Nodes...)
+	for i, file := range files {
+		results[i].file = file
 
-					return f(ctx, cli)
-				})
+		eg.Go(func() error {
+			if ctx.Err() != nil {
+				results[i].skipped = true
+				return nil
 			}
 
-			err = withClient(func(ctx context.Context, c *client.Client) error {
-				fmt.Printf("- talm: file=%s, nodes=%s, endpoints=%s\n", configFile, GlobalArgs.Nodes, GlobalArgs.Endpoints)
-
-				resp, err := c.ApplyConfiguration(ctx, &machineapi.ApplyConfigurationRequest{
-					Data:           result,
-					Mode:           applyCmdFlags.Mode.Mode,
-					DryRun:         applyCmdFlags.dryRun,
-					TryModeTimeout: durationpb.New(applyCmdFlags.configTryTimeout),
-				})
-				if err != nil {
-					return fmt.Errorf("error applying new configuration: %s", err)
-				}
+			var buf bytes.Buffer
+			results[i].nodes, results[i].err = apply(ctx, file, &buf)
 
-				helpers.PrintApplyResults(resp)
+			outMu.Lock()
+			out.Write(buf.Bytes()) //nolint:errcheck
+			outMu.Unlock()
 
-				return nil
-			})
-			if err != nil {
-				return err
+			if failFast {
+				return results[i].err
 			}
 
-			// Reset args
-			if !applyCmdFlags.nodesFromArgs {
-				GlobalArgs.Nodes = []string{}
+			return nil
+		})
+	}
+
+	eg.Wait() //nolint:errcheck
+
+	return results
+}
+
+func printApplySummary(results []applyResult) error {
+	failed := 0
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "FILE\tNODES\tSTATUS\tERROR")
+
+	for _, result := range results {
+		status := "ok"
+		errMsg := ""
+
+		switch {
+		case result.skipped:
+			status = "skipped"
+			failed++
+		case result.err != nil:
+			status = "failed"
+			errMsg = result.err.Error()
+			failed++
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", result.file, strings.Join(result.nodes, ","), status, errMsg)
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("failed to apply %d of %d file(s)", failed, len(results))
+	}
+
+	return nil
+}
+
+// applyFile renders the config file and applies the result to the nodes from fileArgs.
+//
+// The client is closed and in-flight requests are cancelled as soon as ctx is done.
+func applyFile(ctx context.Context, fileArgs global.Args, configFile string, out io.Writer) error {
+	result, err := buildConfig(ctx, "@"+configFile, applyCmdFlags.debug)
+	if err != nil {
+		return err
+	}
+
+	withClient := func(f func(ctx context.Context, c *client.Client) error) error {
+		if applyCmdFlags.insecure {
+			return fileArgs.WithClientMaintenance(applyCmdFlags.certFingerprints, f)
+		}
+
+		return fileArgs.WithClientNoNodes(func(ctx context.Context, cli *client.Client) error {
+			if len(fileArgs.Nodes) < 1 {
+				configContext := cli.GetConfigContext()
+				if configContext == nil {
+					return errors.New("failed to resolve config context")
+				}
+
+				fileArgs.Nodes = configContext.Nodes
 			}
-			if !applyCmdFlags.endpointsFromArgs {
-				GlobalArgs.Endpoints = []string{}
+
+			ctx = client.WithNodes(ctx, fileArgs.Nodes...)
+
+			return f(ctx, cli)
+		})
+	}
+
+	parent := ctx
+
+	return withClient(func(ctx context.Context, c *client.Client) error {
+		// The client context isn't derived from ctx, cancel it together with ctx
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		stop := context.AfterFunc(parent, cancel)
+		defer stop()
+
+		fmt.Fprintf(out, "- talm: file=%s, nodes=%s, endpoints=%s\n", configFile, fileArgs.Nodes, fileArgs.Endpoints)
+
+		if applyCmdFlags.strict {
+			if err := checkStrictRender(ctx, c, configFile, fileArgs.Nodes, out); err != nil {
+				return err
 			}
 		}
+
+		resp, err := c.ApplyConfiguration(ctx, &machineapi.ApplyConfigurationRequest{
+			Data:           result,
+			Mode:           applyCmdFlags.Mode.Mode,
+			DryRun:         applyCmdFlags.dryRun,
+			TryModeTimeout: durationpb.New(applyCmdFlags.configTryTimeout),
+		})
+		if err != nil {
+			return fmt.Errorf("error applying new configuration: %s", err)
+		}
+
+		printApplyResults(out, resp)
+
 		return nil
+	})
+}
+
+// buildConfig applies the patch, either inline or "@file", on top of the generated config
+// and returns the resulting machine config.
+func buildConfig(ctx context.Context, patch string, debug bool) ([]byte, error) {
+	opts := engine.Options{
+		TalosVersion:      applyCmdFlags.talosVersion,
+		WithSecrets:       applyCmdFlags.withSecrets,
+		KubernetesVersion: applyCmdFlags.kubernetesVersion,
+		Debug:             debug,
 	}
+
+	configBundle, err := engine.FullConfigProcess(ctx, opts, []string{patch})
+	if err != nil {
+		return nil, fmt.Errorf("full config processing error: %s", err)
+	}
+
+	machineType := configBundle.ControlPlaneCfg.Machine().Type()
+	result, err := engine.SerializeConfiguration(configBundle, machineType)
+	if err != nil {
+		return nil, fmt.Errorf("error serializing configuration: %s", err)
+	}
+
+	return result, nil
+}
+
+// printApplyResults is helpers.PrintApplyResults writing to out.
+func printApplyResults(out io.Writer, resp *machineapi.ApplyConfigurationResponse) {
+	for _, m := range resp.GetMessages() {
+		for _, w := range m.GetWarnings() {
+			fmt.Fprintf(out, "WARNING: %s\n", w)
+		}
+
+		if m.ModeDetails != "" {
+			fmt.Fprintln(out, m.ModeDetails)
+		}
+	}
+}
+
+// checkStrictRender renders templates from the modeline of the config file in strict mode against
+// every node, with the same values as `talm template`, so files generated from undefined values
+// or empty lookups are not applied.
+//
+// The result must match the last rendered output of the file, kept in .talm/rendered, or the file itself
+// if there is none, otherwise the file is outdated and has to be re-rendered first.
+func checkStrictRender(ctx context.Context, c *client.Client, configFile string, nodes []string, out io.Writer) error {
+	modelineConfig, err := modeline.ReadAndParseModeline(configFile)
+	if err != nil {
+		return fmt.Errorf("modeline parsing failed for %s: %w", configFile, err)
+	}
+	if len(modelineConfig.Templates) == 0 {
+		return nil
+	}
+
+	opts := engine.Options{
+		Insecure:          applyCmdFlags.insecure,
+		ValueFiles:        applyCmdFlags.valueFiles,
+		StringValues:      applyCmdFlags.stringValues,
+		Values:            applyCmdFlags.values,
+		FileValues:        applyCmdFlags.fileValues,
+		JsonValues:        applyCmdFlags.jsonValues,
+		LiteralValues:     applyCmdFlags.literalValues,
+		TalosVersion:      applyCmdFlags.talosVersion,
+		WithSecrets:       applyCmdFlags.withSecrets,
+		Full:              Config.TemplateOptions.Full,
+		PatchFormat:       Config.TemplateOptions.PatchFormat,
+		Root:              Config.RootDir,
+		Strict:            true,
+		KubernetesVersion: applyCmdFlags.kubernetesVersion,
+		TemplateFiles:     modelineConfig.Templates,
+		NodeValueFiles:    modelineConfig.ValueFiles,
+		NodeValues:        modelineConfig.Values,
+	}
+	if !applyCmdFlags.talosVersionFromArgs && modelineConfig.TalosVersion != "" {
+		opts.TalosVersion = modelineConfig.TalosVersion
+	}
+
+	renderedFile := configFile
+	if _, err := os.Stat(renderedBasePath(configFile)); err == nil {
+		renderedFile = renderedBasePath(configFile)
+	}
+	expected, err := buildConfig(ctx, "@"+renderedFile, false)
+	if err != nil {
+		return err
+	}
+
+	for _, node := range nodes {
+		rendered, err := engine.Render(client.WithNode(ctx, node), c, opts)
+		if err != nil {
+			return fmt.Errorf("strict rendering of %s failed for node %s: %w", configFile, node, err)
+		}
+
+		actual, err := buildConfig(ctx, string(rendered), false)
+		if err != nil {
+			return fmt.Errorf("strict rendering of %s for node %s: %w", configFile, node, err)
+		}
+
+		changes, err := yamltools.Changes(expected, actual)
+		if err != nil {
+			return err
+		}
+		if len(changes) > 0 {
+			for _, change := range changes {
+				fmt.Fprintf(out, "  %s %s\n", change.Op, change.Path)
+			}
+			return fmt.Errorf("%s doesn't match the strict rendering for node %s (%d change(s)), re-render it with 'talm template -f %s -I'", configFile, node, len(changes), configFile)
+		}
+	}
+
+	return nil
 }
 
 // readFirstLine reads and returns the first line of the file specified by the filename.
@@ -191,14 +438,23 @@ func readFirstLine(filename string) (string, error) {
 func init() {
 	applyCmd.Flags().BoolVarP(&applyCmdFlags.insecure, "insecure", "i", false, "apply using the insecure (encrypted with no auth) maintenance service")
 	applyCmd.Flags().StringSliceVarP(&applyCmdFlags.configFiles, "file", "f", nil, "specify config files or patches in a YAML file (can specify multiple)")
+	applyCmd.Flags().StringSliceVar(&applyCmdFlags.valueFiles, "values", nil, "specify values in a YAML file for --strict rendering (can specify multiple)")
+	applyCmd.Flags().StringArrayVar(&applyCmdFlags.values, "set", nil, "set values for --strict rendering on the command line (can specify multiple or separate values with commas: key1=val1,key2=val2)")
+	applyCmd.Flags().StringArrayVar(&applyCmdFlags.stringValues, "set-string", nil, "set STRING values for --strict rendering on the command line (can specify multiple or separate values with commas: key1=val1,key2=val2)")
+	applyCmd.Flags().StringArrayVar(&applyCmdFlags.fileValues, "set-file", nil, "set values for --strict rendering from respective files specified via the command line (can specify multiple or separate values with commas: key1=path1,key2=path2)")
+	applyCmd.Flags().StringArrayVar(&applyCmdFlags.jsonValues, "set-json", nil, "set JSON values for --strict rendering on the command line (can specify multiple or separate values with commas: key1=jsonval1,key2=jsonval2)")
+	applyCmd.Flags().StringArrayVar(&applyCmdFlags.literalValues, "set-literal", nil, "set a literal STRING value for --strict rendering on the command line")
 	applyCmd.Flags().StringVar(&applyCmdFlags.talosVersion, "talos-version", "", "the desired Talos version to generate config for (backwards compatibility, e.g. v0.8)")
 	applyCmd.Flags().StringVar(&applyCmdFlags.withSecrets, "with-secrets", "", "use a secrets file generated using 'gen secrets'")
 	applyCmd.Flags().StringVar(&applyCmdFlags.kubernetesVersion, "kubernetes-version", constants.DefaultKubernetesVersion, "desired kubernetes version to run")
 	applyCmd.Flags().BoolVarP(&applyCmdFlags.debug, "debug", "", false, "show only rendered patches")
+	applyCmd.Flags().BoolVar(&applyCmdFlags.strict, "strict", false, "re-render templates from the modeline in strict mode and refuse to apply files which reference undefined values, lookups which found nothing or don't match the rendering")
 	applyCmd.Flags().BoolVar(&applyCmdFlags.dryRun, "dry-run", false, "check how the config change will be applied in dry-run mode")
 	applyCmd.Flags().DurationVar(&applyCmdFlags.configTryTimeout, "timeout", constants.ConfigTryTimeout, "the config will be rolled back after specified timeout (if try mode is selected)")
 	applyCmd.Flags().StringSliceVar(&applyCmdFlags.certFingerprints, "cert-fingerprint", nil, "list of server certificate fingeprints to accept (defaults to no check)")
 	applyCmd.Flags().BoolVar(&applyCmdFlags.force, "force", false, "will overwrite existing files")
+	applyCmd.Flags().IntVar(&applyCmdFlags.parallel, "parallel", 0, "apply up to N files concurrently and print a summary (0 applies files one by one)")
+	applyCmd.Flags().BoolVar(&applyCmdFlags.failFast, "fail-fast", false, "stop scheduling new files after the first failure in --parallel mode")
 	helpers.AddModeFlags(&applyCmdFlags.Mode, applyCmd)
 
 	addCommand(applyCmd)
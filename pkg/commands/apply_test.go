@@ -0,0 +1,102 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunParallelOutput(t *testing.T) {
+	files := []string{"nodes/a.yaml", "nodes/b.yaml", "nodes/c.yaml", "nodes/d.yaml"}
+
+	var out bytes.Buffer
+	results := runParallel(files, len(files), false, &out, func(ctx context.Context, file string, w io.Writer) ([]string, error) {
+		fmt.Fprintf(w, "start %s\n", file)
+		time.Sleep(10 * time.Millisecond)
+		fmt.Fprintf(w, "end %s\n", file)
+		return []string{file}, nil
+	})
+
+	for i, result := range results {
+		if result.file != files[i] {
+			t.Errorf("result %d: expected file %s, got %s", i, files[i], result.file)
+		}
+		if result.err != nil || result.skipped {
+			t.Errorf("result %d: unexpected failure: %+v", i, result)
+		}
+	}
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != 2*len(files) {
+		t.Fatalf("expected %d lines of output, got:\n%s", 2*len(files), out.String())
+	}
+	for i := 0; i < len(lines); i += 2 {
+		file := strings.TrimPrefix(lines[i], "start ")
+		if lines[i+1] != "end "+file {
+			t.Fatalf("output of files is interleaved:\n%s", out.String())
+		}
+	}
+}
+
+func TestRunParallelFailFast(t *testing.T) {
+	files := []string{"nodes/a.yaml", "nodes/b.yaml", "nodes/c.yaml", "nodes/d.yaml"}
+	started := make(chan struct{})
+
+	results := runParallel(files, 2, true, io.Discard, func(ctx context.Context, file string, w io.Writer) ([]string, error) {
+		switch file {
+		case "nodes/a.yaml":
+			<-started
+			return nil, errors.New("apply failed")
+		case "nodes/b.yaml":
+			close(started)
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(5 * time.Second):
+				return nil, errors.New("in-flight apply was not cancelled")
+			}
+		}
+		return nil, nil
+	})
+
+	if results[0].err == nil || results[0].err.Error() != "apply failed" {
+		t.Errorf("expected the first file to fail, got %+v", results[0])
+	}
+	if !errors.Is(results[1].err, context.Canceled) {
+		t.Errorf("expected the in-flight file to be cancelled, got %+v", results[1])
+	}
+	for _, result := range results[2:] {
+		if !result.skipped {
+			t.Errorf("expected %s to be skipped, got %+v", result.file, result)
+		}
+	}
+}
+
+func TestRunParallelContinuesWithoutFailFast(t *testing.T) {
+	files := []string{"nodes/a.yaml", "nodes/b.yaml", "nodes/c.yaml"}
+
+	results := runParallel(files, 1, false, io.Discard, func(ctx context.Context, file string, w io.Writer) ([]string, error) {
+		if file == "nodes/a.yaml" {
+			return nil, errors.New("apply failed")
+		}
+		return nil, ctx.Err()
+	})
+
+	if results[0].err == nil {
+		t.Errorf("expected the first file to fail")
+	}
+	for _, result := range results[1:] {
+		if result.err != nil || result.skipped {
+			t.Errorf("expected %s to be applied, got %+v", result.file, result)
+		}
+	}
+}
@@ -0,0 +1,471 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aenix-io/talm/pkg/generated"
+	"github.com/pmezard/go-difflib/difflib"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// libraryChartName is the name of the library chart shipped in the binary.
+	libraryChartName = "talm"
+	// chartLockFile records the installed library chart version and checksums of its files.
+	chartLockFile = "talm.lock.yaml"
+)
+
+var chartCmdFlags struct {
+	force     bool
+	unpin     bool
+	plainHTTP bool
+}
+
+// chartLock is the content of the chart lock file.
+type chartLock struct {
+	Name      string            `yaml:"name"`
+	Version   string            `yaml:"version"`
+	Pinned    bool              `yaml:"pinned,omitempty"`
+	Generated time.Time         `yaml:"generated"`
+	Digests   map[string]string `yaml:"digests"`
+}
+
+var chartCmd = &cobra.Command{
+	Use:   "chart",
+	Short: "Manage the Talm library chart installed in charts/talm",
+	Long:  ``,
+}
+
+var chartStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the installed library chart version and differences with the one shipped in the binary",
+	Long:  ``,
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		lock, err := loadChartLock()
+		if err != nil {
+			return err
+		}
+		installed, err := installedLibraryChart()
+		if err != nil {
+			return err
+		}
+		embedded := embeddedLibraryChart()
+
+		installedVersion := "unknown"
+		if lock != nil {
+			installedVersion = lock.Version
+			if lock.Pinned {
+				installedVersion += " (pinned)"
+			}
+		} else if chart, ok := installed["Chart.yaml"]; ok {
+			var metadata struct {
+				Version string `yaml:"version"`
+			}
+			if err = yaml.Unmarshal(chart, &metadata); err == nil && metadata.Version != "" {
+				installedVersion = metadata.Version
+			}
+		}
+		fmt.Printf("Installed version: %s\n", installedVersion)
+		fmt.Printf("Embedded version:  %s\n", Config.InitOptions.Version)
+		if lock == nil {
+			fmt.Printf("No %s found, local modifications can't be told apart from upstream changes.\n", chartLockFile)
+		}
+
+		modified := make(map[string]bool)
+		for _, path := range modifiedChartFiles(installed, embedded, lock) {
+			modified[path] = true
+		}
+
+		var diffs []string
+		for _, path := range chartPaths(installed, embedded, lock) {
+			installedData, isInstalled := installed[path]
+			embeddedData, isEmbedded := embedded[path]
+			if bytes.Equal(installedData, embeddedData) && isInstalled == isEmbedded {
+				continue
+			}
+
+			var status string
+			switch {
+			case !isInstalled:
+				status = "missing"
+			case !isEmbedded:
+				status = "removed upstream"
+			case lock == nil:
+				status = "differs"
+			case modified[path]:
+				status = "modified locally"
+			default:
+				status = "outdated"
+			}
+			if isInstalled && modified[path] && status != "modified locally" {
+				status += ", modified locally"
+			}
+			fmt.Printf("  %s: %s\n", status, path)
+
+			diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+				A:        difflib.SplitLines(string(installedData)),
+				B:        difflib.SplitLines(string(embeddedData)),
+				FromFile: filepath.Join("installed", path),
+				ToFile:   filepath.Join("embedded", path),
+				Context:  3,
+			})
+			if err != nil {
+				return err
+			}
+			diffs = append(diffs, diff)
+		}
+
+		if len(diffs) == 0 {
+			fmt.Println("Library chart is up to date.")
+			return nil
+		}
+
+		fmt.Println()
+		fmt.Print(strings.Join(diffs, ""))
+		return nil
+	},
+}
+
+var chartUpgradeCmd = &cobra.Command{
+	Use:   "upgrade",
+	Short: "Replace the installed library chart with the one shipped in the binary",
+	Long: `Replace the installed library chart with the one shipped in the binary.
+
+Locally modified files of the chart are not overwritten unless --force is given,
+in that case they are backed up to .talm/backup first. Upgrade of a pinned chart
+to another version requires --force as well.
+
+Without talm.lock.yaml files differing from the shipped chart are treated as
+modified locally: the lock file is written, but the files are left as is unless
+--force is given.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return upgradeLibraryChart(chartCmdFlags.force)
+	},
+}
+
+var chartPinCmd = &cobra.Command{
+	Use:   "pin",
+	Short: "Pin the installed library chart version to prevent upgrades",
+	Long:  ``,
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		lock, err := loadChartLock()
+		if err != nil {
+			return err
+		}
+		if lock == nil {
+			return fmt.Errorf("%s not found, run 'talm chart upgrade' first", chartLockFile)
+		}
+
+		lock.Pinned = !chartCmdFlags.unpin
+		if err = saveChartLock(lock); err != nil {
+			return err
+		}
+
+		if lock.Pinned {
+			fmt.Fprintf(os.Stderr, "Library chart pinned to version %s\n", lock.Version)
+		} else {
+			fmt.Fprintf(os.Stderr, "Library chart unpinned\n")
+		}
+		return nil
+	},
+}
+
+var chartDepsCmd = &cobra.Command{
+	Use:   "deps",
+	Short: "Download chart dependencies declared in Chart.yaml from OCI registries and Helm repositories",
+	Long: `Download chart dependencies declared in Chart.yaml into charts/.
+
+Versions are resolved from Chart.lock if it exists, otherwise the latest versions matching
+the constraints are downloaded and recorded in Chart.lock.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return updateChartDependencies(chartCmdFlags.plainHTTP)
+	},
+}
+
+// upgradeLibraryChart writes the embedded library chart into charts/talm and records it in the lock file.
+func upgradeLibraryChart(force bool) error {
+	lock, err := loadChartLock()
+	if err != nil {
+		return err
+	}
+	if lock != nil && lock.Pinned && lock.Version != Config.InitOptions.Version && !force {
+		return fmt.Errorf("library chart is pinned to version %s, use 'talm chart pin --unpin' or --force to upgrade it to %s", lock.Version, Config.InitOptions.Version)
+	}
+
+	installed, err := installedLibraryChart()
+	if err != nil {
+		return err
+	}
+	embedded := embeddedLibraryChart()
+
+	modified := modifiedChartFiles(installed, embedded, lock)
+	if len(modified) > 0 {
+		if !force {
+			return fmt.Errorf("library chart files are modified locally: %s, use 'talm chart status' to review the changes and --force to overwrite them", strings.Join(modified, ", "))
+		}
+		backupDir, err := backupChartFiles(installed, modified)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Local modifications backed up to %s\n", backupDir)
+	}
+
+	// Without the lock file local modifications can't be told apart from changes of other versions,
+	// differing files are treated as modified locally. The lock is written without touching the files,
+	// so that they are reported by 'talm chart status' and overwritten only with --force.
+	if differing := untrackedChartFiles(installed, embedded, lock); len(differing) > 0 {
+		if !force {
+			if err = saveChartLock(newChartLock(embedded)); err != nil {
+				return err
+			}
+			return fmt.Errorf("%s not found and library chart files differ from version %s: %s, they may be modified locally, use 'talm chart status' to review the changes and --force to overwrite them",
+				chartLockFile, Config.InitOptions.Version, strings.Join(differing, ", "))
+		}
+		backupDir, err := backupChartFiles(installed, differing)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Files differing from version %s backed up to %s\n", Config.InitOptions.Version, backupDir)
+	}
+
+	chartDir := libraryChartDir()
+	if lock != nil {
+		for path := range lock.Digests {
+			if _, ok := embedded[path]; ok {
+				continue
+			}
+			if err = os.Remove(filepath.Join(chartDir, path)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+
+	for path, data := range embedded {
+		if bytes.Equal(installed[path], data) {
+			continue
+		}
+		file := filepath.Join(chartDir, path)
+		if err = os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+			return err
+		}
+		if err = os.WriteFile(file, data, 0o644); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Updated %s\n", file)
+	}
+
+	newLock := newChartLock(embedded)
+	if lock != nil {
+		newLock.Pinned = lock.Pinned
+	}
+	return saveChartLock(newLock)
+}
+
+// newChartLock returns the lock recording the embedded library chart.
+func newChartLock(embedded map[string][]byte) *chartLock {
+	lock := &chartLock{
+		Name:      libraryChartName,
+		Version:   Config.InitOptions.Version,
+		Generated: time.Now().UTC(),
+		Digests:   make(map[string]string, len(embedded)),
+	}
+	for path, data := range embedded {
+		lock.Digests[path] = chartDigest(data)
+	}
+	return lock
+}
+
+// modifiedChartFiles returns installed files which would be lost on upgrade: files changed since they
+// were recorded in the lock file and files missing in the lock differing from the embedded ones.
+//
+// Without the lock file nothing is known to be modified, see untrackedChartFiles.
+func modifiedChartFiles(installed, embedded map[string][]byte, lock *chartLock) []string {
+	if lock == nil {
+		return nil
+	}
+
+	var modified []string
+	for path, data := range installed {
+		pristine := lock.Digests[path]
+		if pristine == "" {
+			embeddedData, ok := embedded[path]
+			if !ok {
+				// Files unknown to the chart are left as is
+				continue
+			}
+			pristine = chartDigest(embeddedData)
+		}
+		if chartDigest(data) != pristine {
+			modified = append(modified, path)
+		}
+	}
+	sort.Strings(modified)
+	return modified
+}
+
+// untrackedChartFiles returns installed files differing from the embedded ones when there is no lock file,
+// they are either modified locally or come from another version of the chart.
+func untrackedChartFiles(installed, embedded map[string][]byte, lock *chartLock) []string {
+	if lock != nil {
+		return nil
+	}
+
+	var differing []string
+	for path, data := range installed {
+		if embeddedData, ok := embedded[path]; ok && !bytes.Equal(data, embeddedData) {
+			differing = append(differing, path)
+		}
+	}
+	sort.Strings(differing)
+	return differing
+}
+
+// backupChartFiles copies installed files to a new directory under .talm/backup and returns its path.
+func backupChartFiles(installed map[string][]byte, paths []string) (string, error) {
+	backupDir := filepath.Join(Config.RootDir, ".talm", "backup", time.Now().UTC().Format("20060102-150405"), "charts", libraryChartName)
+	for _, path := range paths {
+		file := filepath.Join(backupDir, path)
+		if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+			return "", err
+		}
+		if err := os.WriteFile(file, installed[path], 0o644); err != nil {
+			return "", err
+		}
+	}
+	return backupDir, nil
+}
+
+// chartPaths returns sorted paths of all installed, embedded and locked files.
+func chartPaths(installed, embedded map[string][]byte, lock *chartLock) []string {
+	seen := make(map[string]bool)
+	for path := range installed {
+		seen[path] = true
+	}
+	for path := range embedded {
+		seen[path] = true
+	}
+	if lock != nil {
+		for path := range lock.Digests {
+			seen[path] = true
+		}
+	}
+
+	paths := make([]string, 0, len(seen))
+	for path := range seen {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func libraryChartDir() string {
+	return filepath.Join(Config.RootDir, "charts", libraryChartName)
+}
+
+// embeddedLibraryChart returns files of the library chart shipped in the binary by their path in the chart.
+func embeddedLibraryChart() map[string][]byte {
+	files := make(map[string][]byte)
+	for path, content := range generated.PresetFiles {
+		chartPath, ok := strings.CutPrefix(path, libraryChartName+"/")
+		if !ok {
+			continue
+		}
+		if chartPath == "Chart.yaml" {
+			content = fmt.Sprintf(content, libraryChartName, Config.InitOptions.Version)
+		}
+		files[chartPath] = []byte(content)
+	}
+	return files
+}
+
+// installedLibraryChart reads files of the library chart from charts/talm.
+func installedLibraryChart() (map[string][]byte, error) {
+	chartDir := libraryChartDir()
+	files := make(map[string][]byte)
+	err := filepath.WalkDir(chartDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) && path == chartDir {
+				return fs.SkipAll
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(chartDir, path)
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = data
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read library chart: %w", err)
+	}
+	return files, nil
+}
+
+func chartDigest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// loadChartLock reads the chart lock file, nil is returned if it doesn't exist.
+func loadChartLock() (*chartLock, error) {
+	data, err := os.ReadFile(filepath.Join(Config.RootDir, chartLockFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", chartLockFile, err)
+	}
+
+	lock := &chartLock{}
+	if err = yaml.Unmarshal(data, lock); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal %s: %w", chartLockFile, err)
+	}
+	return lock, nil
+}
+
+func saveChartLock(lock *chartLock) error {
+	data, err := yaml.Marshal(lock)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(Config.RootDir, chartLockFile), data, 0o644)
+}
+
+func init() {
+	chartUpgradeCmd.Flags().BoolVar(&chartCmdFlags.force, "force", false, "overwrite locally modified files (after backing them up) and upgrade a pinned chart")
+	chartPinCmd.Flags().BoolVar(&chartCmdFlags.unpin, "unpin", false, "remove the pin to allow upgrades")
+	chartDepsCmd.Flags().BoolVar(&chartCmdFlags.plainHTTP, "plain-http", false, "use insecure HTTP connections to download chart dependencies")
+
+	chartCmd.AddCommand(chartStatusCmd)
+	chartCmd.AddCommand(chartUpgradeCmd)
+	chartCmd.AddCommand(chartPinCmd)
+	chartCmd.AddCommand(chartDepsCmd)
+	addCommand(chartCmd)
+}
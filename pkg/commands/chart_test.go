@@ -0,0 +1,109 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestModifiedChartFiles(t *testing.T) {
+	embedded := map[string][]byte{
+		"Chart.yaml":             []byte("version: 2"),
+		"templates/_helpers.tpl": []byte("helpers v2"),
+		"templates/new.tpl":      []byte("new"),
+	}
+	installed := map[string][]byte{
+		"Chart.yaml":             []byte("version: 1"),
+		"templates/_helpers.tpl": []byte("helpers v1, modified"),
+		"templates/custom.tpl":   []byte("custom"),
+	}
+	lock := &chartLock{Digests: map[string]string{
+		"Chart.yaml":             chartDigest([]byte("version: 1")),
+		"templates/_helpers.tpl": chartDigest([]byte("helpers v1")),
+	}}
+
+	// Upstream changes of files recorded in the lock are not local modifications
+	if modified := modifiedChartFiles(installed, embedded, lock); !reflect.DeepEqual(modified, []string{"templates/_helpers.tpl"}) {
+		t.Errorf("unexpected modified files with lock: %v", modified)
+	}
+
+	if modified := modifiedChartFiles(installed, embedded, nil); modified != nil {
+		t.Errorf("expected no modified files without lock, got %v", modified)
+	}
+	if differing := untrackedChartFiles(installed, embedded, nil); !reflect.DeepEqual(differing, []string{"Chart.yaml", "templates/_helpers.tpl"}) {
+		t.Errorf("unexpected differing files without lock: %v", differing)
+	}
+	if differing := untrackedChartFiles(installed, embedded, lock); differing != nil {
+		t.Errorf("expected no untracked files with lock, got %v", differing)
+	}
+}
+
+func TestUpgradeLibraryChartWithoutLock(t *testing.T) {
+	rootDir := Config.RootDir
+	t.Cleanup(func() { Config.RootDir = rootDir })
+	Config.RootDir = t.TempDir()
+
+	embedded := embeddedLibraryChart()
+	helpers := filepath.Join(libraryChartDir(), "templates", "_helpers.tpl")
+	if err := os.MkdirAll(filepath.Dir(helpers), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(helpers, []byte("helpers of an older version"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Differing files may be modified locally, they are left as is and recorded in the lock
+	if err := upgradeLibraryChart(false); err == nil {
+		t.Fatal("expected upgrade without lock to refuse overwriting a differing file")
+	}
+	data, err := os.ReadFile(helpers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "helpers of an older version" {
+		t.Errorf("differing file was overwritten without --force")
+	}
+	lock, err := loadChartLock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lock == nil {
+		t.Fatal("lock file wasn't written")
+	}
+
+	// Once the lock is written the file is reported as modified locally
+	installed, err := installedLibraryChart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if modified := modifiedChartFiles(installed, embedded, lock); len(modified) != 1 || modified[0] != "templates/_helpers.tpl" {
+		t.Errorf("expected the differing file to be modified locally, got %v", modified)
+	}
+	if err := upgradeLibraryChart(false); err == nil {
+		t.Error("expected upgrade to refuse overwriting a modified file")
+	}
+
+	if err := upgradeLibraryChart(true); err != nil {
+		t.Fatalf("forced upgrade failed: %s", err)
+	}
+	data, err = os.ReadFile(helpers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != string(embedded["templates/_helpers.tpl"]) {
+		t.Errorf("installed file wasn't upgraded")
+	}
+
+	backups, err := filepath.Glob(filepath.Join(Config.RootDir, ".talm", "backup", "*", "charts", libraryChartName, "templates", "_helpers.tpl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 1 {
+		t.Fatalf("expected a single backup of the differing file, got %v", backups)
+	}
+}
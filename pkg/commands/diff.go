@@ -0,0 +1,258 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/aenix-io/talm/pkg/engine"
+	"github.com/aenix-io/talm/pkg/yamltools"
+	"github.com/cosi-project/runtime/pkg/safe"
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+
+	"github.com/siderolabs/talos/pkg/machinery/client"
+	"github.com/siderolabs/talos/pkg/machinery/config/encoder"
+	"github.com/siderolabs/talos/pkg/machinery/constants"
+	configres "github.com/siderolabs/talos/pkg/machinery/resources/config"
+)
+
+var diffCmdFlags struct {
+	configFiles       []string // -f/--files
+	talosVersion      string
+	withSecrets       string
+	kubernetesVersion string
+	output            string
+	nodesFromArgs     bool
+	endpointsFromArgs bool
+}
+
+// diffResult holds the differences between the rendered and the live configuration of a single node.
+type diffResult struct {
+	File    string             `json:"file"`
+	Node    string             `json:"node"`
+	Changes []yamltools.Change `json:"changes"`
+}
+
+var diffCmd = &cobra.Command{
+	Use:   "diff",
+	Short: "Show differences between rendered node files and the live machine config",
+	Long:  ``,
+	Args:  cobra.NoArgs,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if !cmd.Flags().Changed("talos-version") {
+			diffCmdFlags.talosVersion = Config.TemplateOptions.TalosVersion
+		}
+		if !cmd.Flags().Changed("with-secrets") {
+			diffCmdFlags.withSecrets = Config.TemplateOptions.WithSecrets
+		}
+		if !cmd.Flags().Changed("kubernetes-version") {
+			diffCmdFlags.kubernetesVersion = Config.TemplateOptions.KubernetesVersion
+		}
+		if diffCmdFlags.output != "text" && diffCmdFlags.output != "json" {
+			return fmt.Errorf("invalid output format %q, valid values are: text, json", diffCmdFlags.output)
+		}
+		if len(diffCmdFlags.configFiles) == 0 {
+			return fmt.Errorf("at least one node file must be specified with --file")
+		}
+		diffCmdFlags.nodesFromArgs = len(GlobalArgs.Nodes) > 0
+		diffCmdFlags.endpointsFromArgs = len(GlobalArgs.Endpoints) > 0
+
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return diffCommandError(diff(context.Background()))
+	},
+}
+
+// Exit codes of diff, the same as of diff(1).
+const (
+	diffExitDrift = 1
+	diffExitError = 2
+)
+
+// diffCommandError maps the result of diff to the exit code of the command.
+func diffCommandError(drifted int, err error) error {
+	if err != nil {
+		return &ExitCodeError{Code: diffExitError, Err: err}
+	}
+	if drifted > 0 {
+		return &ExitCodeError{Code: diffExitDrift, Err: fmt.Errorf("configuration drift detected on %d node(s)", drifted)}
+	}
+
+	return nil
+}
+
+// diff prints the differences of every node file and returns the number of drifted nodes.
+func diff(ctx context.Context) (int, error) {
+	var results []diffResult
+
+	for _, configFile := range diffCmdFlags.configFiles {
+		fileArgs, err := modelineArgs(configFile, diffCmdFlags.nodesFromArgs, diffCmdFlags.endpointsFromArgs)
+		if err != nil {
+			return 0, err
+		}
+
+		opts := engine.Options{
+			TalosVersion:      diffCmdFlags.talosVersion,
+			WithSecrets:       diffCmdFlags.withSecrets,
+			KubernetesVersion: diffCmdFlags.kubernetesVersion,
+		}
+
+		patches := []string{"@" + configFile}
+		configBundle, err := engine.FullConfigProcess(ctx, opts, patches)
+		if err != nil {
+			return 0, fmt.Errorf("full config processing error: %s", err)
+		}
+
+		machineType := configBundle.ControlPlaneCfg.Machine().Type()
+		rendered, err := engine.SerializeConfiguration(configBundle, machineType)
+		if err != nil {
+			return 0, fmt.Errorf("error serializing configuration: %s", err)
+		}
+
+		err = fileArgs.WithClientNoNodes(func(ctx context.Context, c *client.Client) error {
+			for _, node := range fileArgs.Nodes {
+				live, err := readMachineConfig(client.WithNode(ctx, node), c)
+				if err != nil {
+					return fmt.Errorf("error reading machine config from node %s: %w", node, err)
+				}
+
+				changes, err := yamltools.Changes(live, rendered)
+				if err != nil {
+					return fmt.Errorf("error comparing configurations for node %s: %w", node, err)
+				}
+
+				results = append(results, diffResult{
+					File:    configFile,
+					Node:    node,
+					Changes: changes,
+				})
+			}
+
+			return nil
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if diffCmdFlags.output == "json" {
+		if err := printDiffJSON(os.Stdout, results); err != nil {
+			return 0, err
+		}
+	} else {
+		if err := printDiffText(os.Stdout, results); err != nil {
+			return 0, err
+		}
+	}
+
+	drifted := 0
+	for _, result := range results {
+		if len(result.Changes) > 0 {
+			drifted++
+		}
+	}
+
+	return drifted, nil
+}
+
+// readMachineConfig fetches the active machine configuration of the node set in the context.
+func readMachineConfig(ctx context.Context, c *client.Client) ([]byte, error) {
+	mc, err := safe.StateGetByID[*configres.MachineConfig](ctx, c.COSI, configres.V1Alpha1ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return mc.Provider().EncodeBytes(encoder.WithComments(encoder.CommentsDisabled))
+}
+
+func printDiffJSON(w io.Writer, results []diffResult) error {
+	if results == nil {
+		results = []diffResult{}
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(results)
+}
+
+func printDiffText(w io.Writer, results []diffResult) error {
+	bold := color.New(color.Bold)
+
+	for _, result := range results {
+		if len(result.Changes) == 0 {
+			fmt.Fprintf(w, "- talm: file=%s, node=%s: no changes\n", result.File, result.Node)
+			continue
+		}
+
+		bold.Fprintf(w, "--- %s (live, node %s)\n", result.File, result.Node)
+		bold.Fprintf(w, "+++ %s (rendered)\n", result.File)
+
+		if err := printChanges(w, result.Changes); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// printChanges prints every change as a hunk with old and new values.
+func printChanges(w io.Writer, changes []yamltools.Change) error {
+	cyan := color.New(color.FgCyan)
+	red := color.New(color.FgRed)
+	green := color.New(color.FgGreen)
+
+	for _, change := range changes {
+		cyan.Fprintf(w, "@@ %s @@\n", change.Path)
+
+		if change.Op != yamltools.ChangeAdd {
+			if err := printDiffValue(w, red, "-", change.Old); err != nil {
+				return err
+			}
+		}
+		if change.Op != yamltools.ChangeRemove {
+			if err := printDiffValue(w, green, "+", change.New); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// printDiffValue prints value as YAML with every line prefixed by the diff marker.
+func printDiffValue(w io.Writer, c *color.Color, marker string, value interface{}) error {
+	data, err := yaml.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		c.Fprintf(w, "%s %s\n", marker, line)
+	}
+
+	return nil
+}
+
+func init() {
+	diffCmd.Flags().StringSliceVarP(&diffCmdFlags.configFiles, "file", "f", nil, "specify config files or patches in a YAML file (can specify multiple)")
+	diffCmd.Flags().StringVar(&diffCmdFlags.talosVersion, "talos-version", "", "the desired Talos version to generate config for (backwards compatibility, e.g. v0.8)")
+	diffCmd.Flags().StringVar(&diffCmdFlags.withSecrets, "with-secrets", "", "use a secrets file generated using 'gen secrets'")
+	diffCmd.Flags().StringVar(&diffCmdFlags.kubernetesVersion, "kubernetes-version", constants.DefaultKubernetesVersion, "desired kubernetes version to run")
+	diffCmd.Flags().StringVarP(&diffCmdFlags.output, "output", "o", "text", "output format (text, json)")
+	diffCmd.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error {
+		return &ExitCodeError{Code: diffExitError, Err: err}
+	})
+
+	addCommand(diffCmd)
+}
@@ -0,0 +1,80 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/aenix-io/talm/pkg/yamltools"
+	"github.com/fatih/color"
+)
+
+func TestDiffCommandError(t *testing.T) {
+	testCases := []struct {
+		name    string
+		drifted int
+		err     error
+		code    int // 0 for no error
+	}{
+		{name: "no drift", drifted: 0},
+		{name: "drift", drifted: 2, code: diffExitDrift},
+		{name: "error", err: errors.New("connection refused"), code: diffExitError},
+		{name: "error wins over drift", drifted: 1, err: errors.New("connection refused"), code: diffExitError},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := diffCommandError(tc.drifted, tc.err)
+			if tc.code == 0 {
+				if err != nil {
+					t.Fatalf("expected no error, got %s", err)
+				}
+				return
+			}
+
+			var exitErr *ExitCodeError
+			if !errors.As(err, &exitErr) || exitErr.Code != tc.code {
+				t.Fatalf("expected exit code %d, got %v", tc.code, err)
+			}
+		})
+	}
+
+	// Invalid flags are errors too, not drift
+	var exitErr *ExitCodeError
+	if err := diffCmd.FlagErrorFunc()(diffCmd, errors.New("unknown flag")); !errors.As(err, &exitErr) || exitErr.Code != diffExitError {
+		t.Errorf("expected exit code %d for a flag error, got %v", diffExitError, err)
+	}
+}
+
+func TestPrintChanges(t *testing.T) {
+	noColor := color.NoColor
+	t.Cleanup(func() { color.NoColor = noColor })
+	color.NoColor = true
+
+	var buf bytes.Buffer
+	err := printChanges(&buf, []yamltools.Change{
+		{Path: "machine.network.hostname", Op: yamltools.ChangeReplace, Old: "node1", New: "node2"},
+		{Path: "machine.certSANs[1]", Op: yamltools.ChangeAdd, New: "1.2.3.5"},
+		{Path: "machine.network.interfaces[interface=eth1]", Op: yamltools.ChangeRemove, Old: map[string]interface{}{"interface": "eth1", "dhcp": true}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `@@ machine.network.hostname @@
+- node1
++ node2
+@@ machine.certSANs[1] @@
++ 1.2.3.5
+@@ machine.network.interfaces[interface=eth1] @@
+- dhcp: true
+- interface: eth1
+`
+	if buf.String() != expected {
+		t.Errorf("unexpected output:\n%s\nexpected:\n%s", buf.String(), expected)
+	}
+}
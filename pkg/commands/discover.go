@@ -0,0 +1,313 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aenix-io/talm/pkg/engine"
+	"github.com/aenix-io/talm/pkg/modeline"
+	"github.com/spf13/cobra"
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/grpc/codes"
+
+	"github.com/siderolabs/talos/pkg/machinery/client"
+	"github.com/siderolabs/talos/pkg/machinery/constants"
+)
+
+// maxDiscoverAddresses limits the size of the scanned range.
+const maxDiscoverAddresses = 1 << 16
+
+var discoverCmdFlags struct {
+	cidr         string
+	port         int
+	parallel     int
+	timeout      time.Duration
+	write        bool
+	templateFile string
+}
+
+var discoverCmd = &cobra.Command{
+	Use:   "discover",
+	Short: "Find Talos nodes in maintenance mode in a subnet",
+	Long: `Probe the Talos API port across the subnet, identify machines in maintenance mode
+by their SMBIOS UUID, serial number, MAC addresses and disks and print an inventory table.
+Nodes which already have a configuration reject the maintenance connection and are listed
+with the "configured" status.
+
+With --write a node file nodes/<hostname>.yaml is rendered from the template for every
+found machine in maintenance mode. Existing node files are not touched.`,
+	Example: `  talm discover --cidr 10.0.0.0/24
+  talm discover --cidr 10.0.0.0/24 --write --template templates/worker.yaml`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		prefix, err := netip.ParsePrefix(discoverCmdFlags.cidr)
+		if err != nil {
+			return fmt.Errorf("invalid --cidr: %w", err)
+		}
+		if discoverCmdFlags.parallel < 1 {
+			return fmt.Errorf("--parallel must be at least 1")
+		}
+		if discoverCmdFlags.write && discoverCmdFlags.templateFile == "" {
+			return fmt.Errorf("--write requires --template")
+		}
+
+		addresses, err := prefixAddresses(prefix.Masked())
+		if err != nil {
+			return err
+		}
+
+		machines := discoverMachines(addresses)
+		if len(machines) == 0 {
+			fmt.Fprintf(os.Stderr, "no Talos nodes found in %s\n", prefix)
+			return nil
+		}
+
+		printDiscoveredMachines(machines)
+
+		if !discoverCmdFlags.write {
+			return nil
+		}
+
+		return writeDiscoveredNodeFiles(machines)
+	},
+}
+
+// discoveredMachine is a Talos node found in the subnet.
+type discoveredMachine struct {
+	address    string
+	hostname   string
+	uuid       string
+	serial     string
+	macs       []string
+	disks      []string
+	configured bool
+	err        error
+}
+
+func (m *discoveredMachine) status() string {
+	switch {
+	case m.configured:
+		return "configured"
+	case m.err != nil:
+		return "error"
+	default:
+		return "maintenance"
+	}
+}
+
+// prefixAddresses returns host addresses of the prefix, the network and broadcast addresses of IPv4 subnets are skipped.
+func prefixAddresses(prefix netip.Prefix) ([]netip.Addr, error) {
+	hostBits := prefix.Addr().BitLen() - prefix.Bits()
+	if hostBits > 16 {
+		return nil, fmt.Errorf("subnet %s is too large, at most %d addresses can be scanned", prefix, maxDiscoverAddresses)
+	}
+
+	var addresses []netip.Addr
+	for addr := prefix.Addr(); prefix.Contains(addr); addr = addr.Next() {
+		addresses = append(addresses, addr)
+	}
+	if prefix.Addr().Is4() && hostBits > 1 {
+		addresses = addresses[1 : len(addresses)-1]
+	}
+	return addresses, nil
+}
+
+// discoverMachines probes the Talos API port on every address and collects facts of responding nodes.
+func discoverMachines(addresses []netip.Addr) []discoveredMachine {
+	found := make([]*discoveredMachine, len(addresses))
+
+	var eg errgroup.Group
+	eg.SetLimit(discoverCmdFlags.parallel)
+
+	for i, addr := range addresses {
+		eg.Go(func() error {
+			address := addr.String()
+
+			conn, err := net.DialTimeout("tcp", net.JoinHostPort(address, strconv.Itoa(discoverCmdFlags.port)), discoverCmdFlags.timeout)
+			if err != nil {
+				return nil
+			}
+			conn.Close() //nolint:errcheck
+
+			found[i] = identifyMachine(address)
+			return nil
+		})
+	}
+
+	eg.Wait() //nolint:errcheck
+
+	var machines []discoveredMachine
+	for _, m := range found {
+		if m != nil {
+			machines = append(machines, *m)
+		}
+	}
+	return machines
+}
+
+// identifyMachine reads hardware resources from the node through the maintenance API,
+// the same resources are used by the talm.discovered.* helpers.
+func identifyMachine(address string) *discoveredMachine {
+	m := &discoveredMachine{address: address}
+
+	args := GlobalArgs
+	args.Nodes = []string{address}
+	args.Endpoints = []string{address}
+
+	var facts *engine.Facts
+	m.err = args.WithClientMaintenance(nil, func(ctx context.Context, c *client.Client) error {
+		ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		defer cancel()
+
+		var err error
+		facts, err = engine.CollectFacts(ctx, c, address, []string{"hostname", "systeminformation", "links", "disks"})
+		return err
+	})
+	if isConfiguredNodeError(m.err) {
+		m.configured = true
+		m.err = nil
+		return m
+	}
+	if m.err != nil {
+		return m
+	}
+
+	if hostname := firstResourceSpec(facts, "hostname"); hostname != nil {
+		m.hostname = fmt.Sprint(hostname["hostname"])
+	}
+	if m.hostname == "" {
+		m.hostname = "talos-" + strings.NewReplacer(".", "-", ":", "-").Replace(address)
+	}
+	if info := firstResourceSpec(facts, "systeminformation"); info != nil {
+		m.uuid = stringField(info, "uuid")
+		m.serial = stringField(info, "serialnumber")
+	}
+
+	for _, link := range facts.Resources["links"] {
+		spec, _ := link["spec"].(map[string]interface{})
+		if stringField(spec, "busPath") != "" && stringField(spec, "hardwareAddr") != "" {
+			m.macs = append(m.macs, stringField(spec, "hardwareAddr"))
+		}
+	}
+	for _, disk := range facts.Resources["disks"] {
+		spec, _ := disk["spec"].(map[string]interface{})
+		if spec["cdrom"] == true || spec["readonly"] == true || stringField(spec, "dev_path") == "" {
+			continue
+		}
+		m.disks = append(m.disks, fmt.Sprintf("%s(%s)", stringField(spec, "dev_path"), stringField(spec, "pretty_size")))
+	}
+	sort.Strings(m.macs)
+	sort.Strings(m.disks)
+
+	return m
+}
+
+// isConfiguredNodeError reports whether the node refused the maintenance connection,
+// configured nodes require a client certificate to access the Talos API.
+func isConfiguredNodeError(err error) bool {
+	if err == nil {
+		return false
+	}
+	switch client.StatusCode(err) {
+	case codes.Unauthenticated, codes.PermissionDenied:
+		return true
+	}
+	return strings.Contains(err.Error(), "certificate required") || strings.Contains(err.Error(), "bad certificate")
+}
+
+func firstResourceSpec(facts *engine.Facts, kind string) map[string]interface{} {
+	resources := facts.Resources[kind]
+	if len(resources) == 0 {
+		return nil
+	}
+	spec, _ := resources[0]["spec"].(map[string]interface{})
+	return spec
+}
+
+func stringField(m map[string]interface{}, key string) string {
+	if value, ok := m[key]; ok && value != nil {
+		return fmt.Sprint(value)
+	}
+	return ""
+}
+
+func printDiscoveredMachines(machines []discoveredMachine) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "ADDRESS\tSTATUS\tHOSTNAME\tUUID\tSERIAL\tMACS\tDISKS\tERROR")
+
+	for _, m := range machines {
+		errMsg := ""
+		if m.err != nil {
+			errMsg = m.err.Error()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", m.address, m.status(), m.hostname, m.uuid, m.serial, strings.Join(m.macs, ","), strings.Join(m.disks, ","), errMsg)
+	}
+
+	w.Flush() //nolint:errcheck
+}
+
+var nodeFileNameRegexp = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// writeDiscoveredNodeFiles renders the template for every identified machine through the maintenance API
+// into nodes/<hostname>.yaml, existing files and configured nodes are skipped.
+func writeDiscoveredNodeFiles(machines []discoveredMachine) error {
+	templateCmdFlags.insecure = true
+	templateCmdFlags.inplace = true
+	if err := templateCmd.PreRunE(templateCmd, nil); err != nil {
+		return err
+	}
+
+	first := true
+	for _, m := range machines {
+		if m.err != nil || m.configured {
+			continue
+		}
+
+		configFile := filepath.Join(Config.RootDir, "nodes", nodeFileNameRegexp.ReplaceAllString(m.hostname, "-")+".yaml")
+		if _, err := os.Stat(configFile); err == nil {
+			fmt.Fprintf(os.Stderr, "- talm: file=%s already exists, skipping\n", configFile)
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(configFile), 0o755); err != nil {
+			return err
+		}
+
+		modelineConfig := &modeline.Config{
+			Nodes:     []string{m.address},
+			Endpoints: []string{m.address},
+			Templates: []string{discoverCmdFlags.templateFile},
+		}
+		if err := templateNodeFile(nil, configFile, modelineConfig, first); err != nil {
+			return fmt.Errorf("node %s: %w", m.address, err)
+		}
+		first = false
+	}
+
+	return nil
+}
+
+func init() {
+	discoverCmd.Flags().StringVar(&discoverCmdFlags.cidr, "cidr", "", "subnet to scan, e.g. 10.0.0.0/24")
+	discoverCmd.Flags().IntVar(&discoverCmdFlags.port, "port", constants.ApidPort, "Talos API port to probe")
+	discoverCmd.Flags().IntVar(&discoverCmdFlags.parallel, "parallel", 64, "number of addresses probed concurrently")
+	discoverCmd.Flags().DurationVar(&discoverCmdFlags.timeout, "timeout", 2*time.Second, "timeout to connect to every address")
+	discoverCmd.Flags().BoolVar(&discoverCmdFlags.write, "write", false, "write nodes/<hostname>.yaml for every found machine")
+	discoverCmd.Flags().StringVarP(&discoverCmdFlags.templateFile, "template", "t", "", "template to render node files from with --write, e.g. templates/worker.yaml")
+	discoverCmd.MarkFlagRequired("cidr") //nolint:errcheck
+
+	addCommand(discoverCmd)
+}
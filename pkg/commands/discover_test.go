@@ -0,0 +1,79 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"errors"
+	"net/netip"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestPrefixAddresses(t *testing.T) {
+	testCases := []struct {
+		prefix string
+		count  int
+		first  string
+		last   string
+	}{
+		{"10.0.0.0/24", 254, "10.0.0.1", "10.0.0.254"},
+		{"10.0.0.5/32", 1, "10.0.0.5", "10.0.0.5"},
+		{"10.0.0.4/31", 2, "10.0.0.4", "10.0.0.5"},
+		{"10.0.0.0/30", 2, "10.0.0.1", "10.0.0.2"},
+		{"10.0.0.0/16", 65534, "10.0.0.1", "10.0.255.254"},
+		{"255.255.255.0/24", 254, "255.255.255.1", "255.255.255.254"},
+		{"fd00::/126", 4, "fd00::", "fd00::3"},
+		{"fd00::1/128", 1, "fd00::1", "fd00::1"},
+		{"ffff:ffff:ffff:ffff:ffff:ffff:ffff:fff0/124", 16, "ffff:ffff:ffff:ffff:ffff:ffff:ffff:fff0", "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff"},
+	}
+
+	for _, tc := range testCases {
+		addresses, err := prefixAddresses(netip.MustParsePrefix(tc.prefix))
+		if err != nil {
+			t.Errorf("prefixAddresses(%s): %s", tc.prefix, err)
+			continue
+		}
+		if len(addresses) != tc.count {
+			t.Errorf("prefixAddresses(%s): expected %d addresses, got %d", tc.prefix, tc.count, len(addresses))
+			continue
+		}
+		if first, last := addresses[0].String(), addresses[len(addresses)-1].String(); first != tc.first || last != tc.last {
+			t.Errorf("prefixAddresses(%s): expected %s-%s, got %s-%s", tc.prefix, tc.first, tc.last, first, last)
+		}
+	}
+
+	for _, prefix := range []string{"10.0.0.0/15", "0.0.0.0/0", "fd00::/64", "fd00::/111"} {
+		if _, err := prefixAddresses(netip.MustParsePrefix(prefix)); err == nil {
+			t.Errorf("prefixAddresses(%s): expected an error", prefix)
+		}
+	}
+}
+
+func TestIsConfiguredNodeError(t *testing.T) {
+	testCases := []struct {
+		err        error
+		configured bool
+	}{
+		{nil, false},
+		{status.Error(codes.Unavailable, `connection error: desc = "error reading server preface: remote error: tls: certificate required"`), true},
+		{status.Error(codes.Unavailable, `connection error: desc = "transport: authentication handshake failed: remote error: tls: bad certificate"`), true},
+		{status.Error(codes.PermissionDenied, "not authorized"), true},
+		{status.Error(codes.Unavailable, `connection error: desc = "transport: error while dialing: connection refused"`), false},
+		{errors.New("context deadline exceeded"), false},
+	}
+
+	for _, tc := range testCases {
+		if configured := isConfiguredNodeError(tc.err); configured != tc.configured {
+			t.Errorf("isConfiguredNodeError(%v): expected %v, got %v", tc.err, tc.configured, configured)
+		}
+	}
+
+	m := discoveredMachine{configured: true}
+	if m.status() != "configured" {
+		t.Errorf("unexpected status %q", m.status())
+	}
+}
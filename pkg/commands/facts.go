@@ -0,0 +1,82 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"context"
+	"os"
+
+	"github.com/aenix-io/talm/pkg/engine"
+	"github.com/spf13/cobra"
+
+	"github.com/siderolabs/talos/cmd/talosctl/pkg/talos/helpers"
+	"github.com/siderolabs/talos/pkg/machinery/client"
+)
+
+var factsCmdFlags struct {
+	insecure    bool
+	configFiles []string // -f/--files
+	kinds       []string
+}
+
+var factsCmd = &cobra.Command{
+	Use:   "facts",
+	Short: "Manage snapshots of node facts used for offline rendering",
+	Long:  ``,
+}
+
+var factsCollectCmd = &cobra.Command{
+	Use:   "collect",
+	Short: "Dump resources looked up by templates into a facts snapshot",
+	Long: `Dump resources looked up by templates into a facts snapshot.
+
+The snapshot is written to stdout and can be passed to 'talm template --facts'
+to render templates without access to the node.`,
+	Args: cobra.NoArgs,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		nodesFromArgs := len(GlobalArgs.Nodes) > 0
+		endpointsFromArgs := len(GlobalArgs.Endpoints) > 0
+		for _, configFile := range factsCmdFlags.configFiles {
+			if err := processModelineAndUpdateGlobals(configFile, nodesFromArgs, endpointsFromArgs, false); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if factsCmdFlags.insecure {
+			return WithClientMaintenance(nil, factsCollect)
+		}
+
+		return WithClient(factsCollect)
+	},
+}
+
+func factsCollect(ctx context.Context, c *client.Client) error {
+	if err := helpers.FailIfMultiNodes(ctx, "talm facts collect"); err != nil {
+		return err
+	}
+
+	var node string
+	if len(GlobalArgs.Nodes) > 0 {
+		node = GlobalArgs.Nodes[0]
+	}
+
+	facts, err := engine.CollectFacts(ctx, c, node, factsCmdFlags.kinds)
+	if err != nil {
+		return err
+	}
+
+	return facts.Write(os.Stdout)
+}
+
+func init() {
+	factsCollectCmd.Flags().BoolVarP(&factsCmdFlags.insecure, "insecure", "i", false, "collect facts using the insecure (encrypted with no auth) maintenance service")
+	factsCollectCmd.Flags().StringSliceVarP(&factsCmdFlags.configFiles, "file", "f", nil, "specify config files to take nodes and endpoints from")
+	factsCollectCmd.Flags().StringSliceVar(&factsCmdFlags.kinds, "kinds", engine.DefaultFactKinds, "resource kinds to collect")
+
+	factsCmd.AddCommand(factsCollectCmd)
+	addCommand(factsCmd)
+}
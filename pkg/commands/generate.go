@@ -0,0 +1,33 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"github.com/spf13/cobra"
+)
+
+var generateCmd = &cobra.Command{
+	Use:   "generate",
+	Short: "Render every node from the inventory into node files",
+	Long: `Render templates for every node described in inventory.yaml and write the
+results into nodes/, creating missing files and updating existing ones.
+
+This is a shortcut for 'talm template --all --in-place'.`,
+	Args: cobra.NoArgs,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		templateCmdFlags.all = true
+		templateCmdFlags.inplace = true
+		return templateCmd.PreRunE(cmd, args)
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return templateCmd.RunE(cmd, args)
+	},
+}
+
+func init() {
+	addTemplateFlags(generateCmd)
+
+	addCommand(generateCmd)
+}
@@ -12,8 +12,10 @@ import (
 	"time"
 
 	"github.com/aenix-io/talm/pkg/generated"
+	"github.com/aenix-io/talm/pkg/sops"
 	"github.com/spf13/cobra"
 	"gopkg.in/yaml.v3"
+	"helm.sh/helm/v3/pkg/chart"
 
 	"github.com/siderolabs/talos/cmd/talosctl/cmd/mgmt/gen"
 	"github.com/siderolabs/talos/pkg/machinery/config"
@@ -22,10 +24,13 @@ import (
 )
 
 var initCmdFlags struct {
-	force        bool
-	preset       string
-	talosVersion string
-	update       bool
+	force         bool
+	preset        string
+	talosVersion  string
+	update        bool
+	encrypt       bool
+	ageRecipients []string
+	plainHTTP     bool
 }
 
 // initCmd represents the `init` command.
@@ -38,6 +43,12 @@ var initCmd = &cobra.Command{
 		if !cmd.Flags().Changed("talos-version") {
 			initCmdFlags.talosVersion = Config.TemplateOptions.TalosVersion
 		}
+		if len(initCmdFlags.ageRecipients) == 0 {
+			initCmdFlags.ageRecipients = sops.RecipientsFromEnv()
+		}
+		if initCmdFlags.encrypt && len(initCmdFlags.ageRecipients) == 0 {
+			return fmt.Errorf("--encrypt requires at least one --age-recipient or %s to be set", sops.AgeRecipientsEnv)
+		}
 		return nil
 	},
 	RunE: func(cmd *cobra.Command, args []string) error {
@@ -48,7 +59,7 @@ var initCmd = &cobra.Command{
 		)
 
 		if initCmdFlags.update {
-			return updateTalmLibraryChart()
+			return upgradeLibraryChart(initCmdFlags.force)
 		}
 		if initCmdFlags.talosVersion != "" {
 			versionContract, err = config.ParseContractFromVersion(initCmdFlags.talosVersion)
@@ -64,8 +75,12 @@ var initCmd = &cobra.Command{
 			return fmt.Errorf("failed to create secrets bundle: %w", err)
 		}
 		var genOptions []generate.Option //nolint:prealloc
+		var presetChart *chart.Chart
 		if !isValidPreset(initCmdFlags.preset) {
-			return fmt.Errorf("invalid preset: %s. Valid presets are: %s", initCmdFlags.preset, generated.AvailablePresets)
+			presetChart, _, err = loadPreset(initCmdFlags.preset, initCmdFlags.plainHTTP)
+			if err != nil {
+				return err
+			}
 		}
 		if initCmdFlags.talosVersion != "" {
 			var versionContract *config.VersionContract
@@ -136,8 +151,26 @@ var initCmd = &cobra.Command{
 			}
 		}
 
-		return nil
+		if presetChart != nil {
+			for _, f := range presetChart.Raw {
+				// The library chart is always installed from the binary
+				if strings.HasPrefix(f.Name, "charts/"+libraryChartName+"/") {
+					continue
+				}
+				if err = writeToDestination(f.Data, filepath.Join(Config.RootDir, f.Name), 0o644); err != nil {
+					return err
+				}
+			}
+		}
+
+		if err = saveChartLock(newChartLock(embeddedLibraryChart())); err != nil {
+			return err
+		}
 
+		if presetChart != nil && len(presetChart.Metadata.Dependencies) > 0 {
+			return updateChartDependencies(initCmdFlags.plainHTTP)
+		}
+		return nil
 	},
 }
 
@@ -152,57 +185,26 @@ func writeSecretsBundleToFile(bundle *secrets.Bundle) error {
 		return err
 	}
 
-	return writeToDestination(bundleBytes, secretsFile, 0o644)
-}
-
-func updateTalmLibraryChart() error {
-	talmChartDir := filepath.Join(Config.RootDir, "charts/talm")
-
-	if err := os.RemoveAll(talmChartDir); err != nil {
-		return fmt.Errorf("failed to remove existing talm chart directory: %w", err)
-	}
-
-	content, exists := generated.PresetFiles["talm/Chart.yaml"]
-	if !exists {
-		return fmt.Errorf("talm chart preset not found")
+	if !initCmdFlags.encrypt {
+		return writeToDestination(bundleBytes, secretsFile, 0o600)
 	}
 
-	file := filepath.Join(talmChartDir, "Chart.yaml")
-	err := writeToDestination([]byte(fmt.Sprintf(content, "talm", Config.InitOptions.Version)), file, 0o644)
+	encrypted, err := sops.Encrypt(bundleBytes, initCmdFlags.ageRecipients)
 	if err != nil {
-		return err
-	}
-
-	// Remove the existing talm chart directory
-	if err := os.RemoveAll(talmChartDir); err != nil {
-		return fmt.Errorf("failed to remove existing talm chart directory: %w", err)
+		return fmt.Errorf("failed to encrypt secrets: %w", err)
 	}
 
-	for path, content := range generated.PresetFiles {
-		parts := strings.SplitN(path, "/", 2)
-		chartName := parts[0]
-		// Write library chart
-		if chartName == "talm" {
-			file := filepath.Join(Config.RootDir, filepath.Join("charts", path))
-			if parts[len(parts)-1] == "Chart.yaml" {
-				writeToDestination([]byte(fmt.Sprintf(content, "talm", Config.InitOptions.Version)), file, 0o644)
-			} else {
-				err = writeToDestination([]byte(content), file, 0o644)
-			}
-			if err != nil {
-				return err
-			}
-		}
-	}
-
-	return nil
+	return writeToDestination(encrypted, secretsFile, 0o644)
 }
 
 func init() {
 	initCmd.Flags().StringVar(&initCmdFlags.talosVersion, "talos-version", "", "the desired Talos version to generate config for (backwards compatibility, e.g. v0.8)")
-	initCmd.Flags().StringVarP(&initCmdFlags.preset, "preset", "p", "generic", "specify preset to generate files")
+	initCmd.Flags().StringVarP(&initCmdFlags.preset, "preset", "p", "generic", "specify preset to generate files: name of a built-in preset, an OCI reference (oci://registry/charts/preset:1.0.0), a chart URL or a local path")
+	initCmd.Flags().BoolVar(&initCmdFlags.plainHTTP, "plain-http", false, "use insecure HTTP connections to download the preset and chart dependencies")
 	initCmd.Flags().BoolVar(&initCmdFlags.force, "force", false, "will overwrite existing files")
-	initCmd.Flags().BoolVarP(&initCmdFlags.update, "update", "u", false, "update Talm library chart")
+	initCmd.Flags().BoolVarP(&initCmdFlags.update, "update", "u", false, "update Talm library chart, same as 'talm chart upgrade'")
+	initCmd.Flags().BoolVar(&initCmdFlags.encrypt, "encrypt", false, "encrypt secrets.yaml with sops using age keys")
+	initCmd.Flags().StringSliceVar(&initCmdFlags.ageRecipients, "age-recipient", nil, "age public key to encrypt secrets.yaml for (can specify multiple, defaults to "+sops.AgeRecipientsEnv+")")
 
 	addCommand(initCmd)
 }
@@ -0,0 +1,128 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aenix-io/talm/pkg/engine"
+	"github.com/spf13/cobra"
+
+	"github.com/siderolabs/talos/pkg/machinery/constants"
+)
+
+var lintCmdFlags struct {
+	configFiles       []string // -f/--files
+	valueFiles        []string // --values
+	templateFiles     []string // -t/--template
+	stringValues      []string // --set-string
+	values            []string // --set
+	fileValues        []string // --set-file
+	jsonValues        []string // --set-json
+	literalValues     []string // --set-literal
+	talosVersion      string
+	withSecrets       string
+	kubernetesVersion string
+	mode              string
+	strict            bool
+	facts             string
+}
+
+var lintCmd = &cobra.Command{
+	Use:   "lint",
+	Short: "Check templates, values and node files for errors",
+	Long: `Render every template of the project without access to the nodes and
+validate the result with the Talos config validator.
+
+Missing required values, template and YAML errors, unknown fields and invalid or
+deprecated options are reported. The command exits with non-zero code if any
+error is found.
+
+Lookups find nothing unless they are served from a snapshot passed with --facts,
+so errors about the install disk and network interfaces discovered by templates
+are reported as warnings without it.`,
+	Args: cobra.NoArgs,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		lintCmdFlags.valueFiles = append(Config.TemplateOptions.ValueFiles, lintCmdFlags.valueFiles...)
+		lintCmdFlags.values = append(Config.TemplateOptions.Values, lintCmdFlags.values...)
+		lintCmdFlags.stringValues = append(Config.TemplateOptions.StringValues, lintCmdFlags.stringValues...)
+		lintCmdFlags.fileValues = append(Config.TemplateOptions.FileValues, lintCmdFlags.fileValues...)
+		lintCmdFlags.jsonValues = append(Config.TemplateOptions.JsonValues, lintCmdFlags.jsonValues...)
+		lintCmdFlags.literalValues = append(Config.TemplateOptions.LiteralValues, lintCmdFlags.literalValues...)
+		if !cmd.Flags().Changed("talos-version") {
+			lintCmdFlags.talosVersion = Config.TemplateOptions.TalosVersion
+		}
+		if !cmd.Flags().Changed("with-secrets") {
+			lintCmdFlags.withSecrets = Config.TemplateOptions.WithSecrets
+		}
+		if !cmd.Flags().Changed("kubernetes-version") {
+			lintCmdFlags.kubernetesVersion = Config.TemplateOptions.KubernetesVersion
+		}
+		if !cmd.Flags().Changed("strict") {
+			lintCmdFlags.strict = Config.TemplateOptions.Strict
+		}
+
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		opts := engine.Options{
+			ValueFiles:        lintCmdFlags.valueFiles,
+			StringValues:      lintCmdFlags.stringValues,
+			Values:            lintCmdFlags.values,
+			FileValues:        lintCmdFlags.fileValues,
+			JsonValues:        lintCmdFlags.jsonValues,
+			LiteralValues:     lintCmdFlags.literalValues,
+			TalosVersion:      lintCmdFlags.talosVersion,
+			WithSecrets:       lintCmdFlags.withSecrets,
+			Root:              Config.RootDir,
+			Offline:           true,
+			Strict:            lintCmdFlags.strict,
+			Facts:             lintCmdFlags.facts,
+			KubernetesVersion: lintCmdFlags.kubernetesVersion,
+			TemplateFiles:     lintCmdFlags.templateFiles,
+		}
+
+		messages, err := engine.Lint(opts, lintCmdFlags.mode, lintCmdFlags.configFiles)
+		if err != nil {
+			return err
+		}
+
+		errorsCount := 0
+		for _, message := range messages {
+			if message.Severity == engine.LintError {
+				errorsCount++
+			}
+			fmt.Println(message)
+		}
+
+		if errorsCount > 0 {
+			return fmt.Errorf("linting failed: %d error(s) found", errorsCount)
+		}
+
+		fmt.Println("No errors found.")
+
+		return nil
+	},
+}
+
+func init() {
+	lintCmd.Flags().StringSliceVarP(&lintCmdFlags.configFiles, "file", "f", nil, "specify node files to validate (can specify multiple)")
+	lintCmd.Flags().StringSliceVarP(&lintCmdFlags.valueFiles, "values", "", []string{}, "specify values in a YAML file (can specify multiple)")
+	lintCmd.Flags().StringSliceVarP(&lintCmdFlags.templateFiles, "template", "t", []string{}, "specify templates to lint, all templates are linted by default (can specify multiple)")
+	lintCmd.Flags().StringArrayVar(&lintCmdFlags.values, "set", []string{}, "set values on the command line (can specify multiple or separate values with commas: key1=val1,key2=val2)")
+	lintCmd.Flags().StringArrayVar(&lintCmdFlags.stringValues, "set-string", []string{}, "set STRING values on the command line (can specify multiple or separate values with commas: key1=val1,key2=val2)")
+	lintCmd.Flags().StringArrayVar(&lintCmdFlags.fileValues, "set-file", []string{}, "set values from respective files specified via the command line (can specify multiple or separate values with commas: key1=path1,key2=path2)")
+	lintCmd.Flags().StringArrayVar(&lintCmdFlags.jsonValues, "set-json", []string{}, "set JSON values on the command line (can specify multiple or separate values with commas: key1=jsonval1,key2=jsonval2)")
+	lintCmd.Flags().StringArrayVar(&lintCmdFlags.literalValues, "set-literal", []string{}, "set a literal STRING value on the command line")
+	lintCmd.Flags().StringVar(&lintCmdFlags.talosVersion, "talos-version", "", "the desired Talos version to validate config for (backwards compatibility, e.g. v0.8)")
+	lintCmd.Flags().StringVar(&lintCmdFlags.withSecrets, "with-secrets", "", "use a secrets file generated using 'gen secrets'")
+	lintCmd.Flags().StringVar(&lintCmdFlags.kubernetesVersion, "kubernetes-version", constants.DefaultKubernetesVersion, "desired kubernetes version to run")
+	lintCmd.Flags().BoolVar(&lintCmdFlags.strict, "strict", false, "fail on references to undefined values")
+	lintCmd.Flags().StringVar(&lintCmdFlags.facts, "facts", "", "serve lookup functions from a facts snapshot collected by 'talm facts collect'")
+	lintCmd.Flags().StringVarP(&lintCmdFlags.mode, "mode", "m", "metal", fmt.Sprintf("the mode to validate the config for (valid values are %s)", strings.Join(engine.LintModes, ", ")))
+
+	addCommand(lintCmd)
+}
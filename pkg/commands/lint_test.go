@@ -0,0 +1,81 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aenix-io/talm/pkg/engine"
+	"github.com/aenix-io/talm/pkg/generated"
+)
+
+// writePresetProject lays out the embedded preset and the library chart the same way as 'talm init'.
+func writePresetProject(t *testing.T, preset string) string {
+	t.Helper()
+
+	root := t.TempDir()
+	files := map[string]string{}
+	for path, content := range generated.PresetFiles {
+		chartName, name, _ := strings.Cut(path, "/")
+		switch chartName {
+		case preset:
+			if name == "Chart.yaml" {
+				content = fmt.Sprintf(content, "test", "0.1.0")
+			}
+			files[name] = content
+		case libraryChartName:
+			if name == "Chart.yaml" {
+				content = fmt.Sprintf(content, libraryChartName, "0.1.0")
+			}
+			files[filepath.Join("charts", path)] = content
+		}
+	}
+	writeTestFiles(t, root, files)
+
+	return root
+}
+
+func TestLintPresets(t *testing.T) {
+	for _, preset := range generated.AvailablePresets {
+		t.Run(preset, func(t *testing.T) {
+			root := writePresetProject(t, preset)
+
+			messages, err := engine.Lint(engine.Options{Root: root, Offline: true}, "metal", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, message := range messages {
+				if message.Severity == engine.LintError {
+					t.Errorf("unexpected error without facts: %s", message)
+				}
+			}
+
+			// Facts of a node without disks make the missing install disk an error again
+			var facts strings.Builder
+			facts.WriteString("resources:\n")
+			for _, kind := range engine.DefaultFactKinds {
+				facts.WriteString("  " + kind + ": []\n")
+			}
+			writeTestFiles(t, root, map[string]string{"facts.yaml": facts.String()})
+
+			messages, err = engine.Lint(engine.Options{Root: root, Offline: true, Facts: filepath.Join(root, "facts.yaml")}, "metal", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			found := false
+			for _, message := range messages {
+				if message.Severity == engine.LintError && strings.Contains(message.Message, "install disk") {
+					found = true
+				}
+			}
+			if !found {
+				t.Errorf("expected an error about the install disk with facts, got %v", messages)
+			}
+		})
+	}
+}
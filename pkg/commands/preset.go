@@ -0,0 +1,128 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/downloader"
+	"helm.sh/helm/v3/pkg/getter"
+	"helm.sh/helm/v3/pkg/helmpath"
+	"helm.sh/helm/v3/pkg/registry"
+)
+
+// isExternalPreset reports whether the preset refers to a chart in an OCI registry,
+// a chart archive URL or a local chart instead of a preset compiled into the binary.
+func isExternalPreset(preset string) bool {
+	if registry.IsOCI(preset) || strings.HasPrefix(preset, "http://") || strings.HasPrefix(preset, "https://") {
+		return true
+	}
+	if filepath.IsAbs(preset) || strings.HasPrefix(preset, "./") || strings.HasPrefix(preset, "../") || strings.HasSuffix(preset, ".tgz") {
+		return true
+	}
+	_, err := os.Stat(filepath.Join(preset, "Chart.yaml"))
+	return err == nil
+}
+
+// loadExternalPreset loads the preset chart from an OCI registry, a chart archive URL,
+// a local chart directory or a local chart archive.
+func loadExternalPreset(ref string, plainHTTP bool) (*chart.Chart, error) {
+	path := ref
+	if registry.IsOCI(ref) || strings.HasPrefix(ref, "http://") || strings.HasPrefix(ref, "https://") {
+		tmpDir, err := os.MkdirTemp("", "talm-preset-")
+		if err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(tmpDir) //nolint:errcheck
+
+		registryClient, err := newRegistryClient(plainHTTP)
+		if err != nil {
+			return nil, err
+		}
+		chartDownloader := downloader.ChartDownloader{
+			Out:              os.Stderr,
+			Verify:           downloader.VerifyNever,
+			Getters:          chartGetters(),
+			Options:          []getter.Option{getter.WithPlainHTTP(plainHTTP), getter.WithRegistryClient(registryClient)},
+			RegistryClient:   registryClient,
+			RepositoryConfig: helmpath.ConfigPath("repositories.yaml"),
+			RepositoryCache:  helmpath.CachePath("repository"),
+		}
+		chartRef, version := splitPresetVersion(ref)
+		if path, _, err = chartDownloader.DownloadTo(chartRef, version, tmpDir); err != nil {
+			return nil, fmt.Errorf("failed to download preset %s: %w", ref, err)
+		}
+	}
+
+	presetChart, err := loader.Load(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load preset %s: %w", ref, err)
+	}
+	if presetChart.Metadata.Type == "library" {
+		return nil, fmt.Errorf("preset %s is a library chart, use it as a dependency instead", ref)
+	}
+	return presetChart, nil
+}
+
+// splitPresetVersion splits the tag off an OCI reference, as the chart downloader
+// expects the version separately and resolves the latest tag otherwise.
+func splitPresetVersion(ref string) (string, string) {
+	if !registry.IsOCI(ref) {
+		return ref, ""
+	}
+	slash := strings.LastIndex(ref, "/")
+	if colon := strings.LastIndex(ref, ":"); colon > slash {
+		return ref[:colon], ref[colon+1:]
+	}
+	return ref, ""
+}
+
+// updateChartDependencies downloads dependencies declared in Chart.yaml of the project into charts/
+// and records them in Chart.lock.
+func updateChartDependencies(plainHTTP bool) error {
+	registryClient, err := newRegistryClient(plainHTTP)
+	if err != nil {
+		return err
+	}
+
+	manager := &downloader.Manager{
+		Out:              os.Stderr,
+		ChartPath:        Config.RootDir,
+		Verify:           downloader.VerifyNever,
+		Getters:          chartGetters(),
+		RegistryClient:   registryClient,
+		RepositoryConfig: helmpath.ConfigPath("repositories.yaml"),
+		RepositoryCache:  helmpath.CachePath("repository"),
+	}
+	if err = manager.Build(); err != nil {
+		return fmt.Errorf("failed to update chart dependencies: %w", err)
+	}
+	return nil
+}
+
+// newRegistryClient returns an OCI registry client using credentials of 'helm registry login'.
+func newRegistryClient(plainHTTP bool) (*registry.Client, error) {
+	opts := []registry.ClientOption{
+		registry.ClientOptWriter(os.Stderr),
+		registry.ClientOptCredentialsFile(helmpath.ConfigPath("registry", "config.json")),
+	}
+	if plainHTTP {
+		opts = append(opts, registry.ClientOptPlainHTTP())
+	}
+	return registry.NewClient(opts...)
+}
+
+// chartGetters returns getters for HTTP(S) chart repositories and OCI registries.
+func chartGetters() getter.Providers {
+	return getter.Providers{
+		{Schemes: []string{"http", "https"}, New: getter.NewHTTPGetter},
+		{Schemes: []string{registry.OCIScheme}, New: getter.NewOCIGetter},
+	}
+}
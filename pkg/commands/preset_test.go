@@ -0,0 +1,228 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	specs "github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/registry"
+)
+
+// testRegistry is an in-memory OCI registry serving Helm charts.
+type testRegistry struct {
+	blobs     map[digest.Digest][]byte
+	manifests map[string]map[string][]byte // repository -> tag or digest -> manifest
+}
+
+func newTestRegistry(t *testing.T) (*testRegistry, string) {
+	t.Helper()
+
+	for _, env := range []string{"HELM_CONFIG_HOME", "HELM_CACHE_HOME", "HELM_DATA_HOME"} {
+		t.Setenv(env, t.TempDir())
+	}
+
+	r := &testRegistry{blobs: map[digest.Digest][]byte{}, manifests: map[string]map[string][]byte{}}
+	server := httptest.NewServer(r)
+	t.Cleanup(server.Close)
+
+	return r, strings.TrimPrefix(server.URL, "http://")
+}
+
+// push stores the chart as the tag of its version in the repository.
+func (r *testRegistry) push(t *testing.T, repository string, ch *chart.Chart) {
+	t.Helper()
+
+	archive, err := chartutil.Save(ch, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := json.Marshal(ch.Metadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manifest, err := json.Marshal(ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    r.blob(registry.ConfigMediaType, config),
+		Layers:    []ocispec.Descriptor{r.blob(registry.ChartLayerMediaType, data)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r.manifests[repository] == nil {
+		r.manifests[repository] = map[string][]byte{}
+	}
+	r.manifests[repository][ch.Metadata.Version] = manifest
+	r.manifests[repository][digest.FromBytes(manifest).String()] = manifest
+}
+
+func (r *testRegistry) blob(mediaType string, data []byte) ocispec.Descriptor {
+	d := digest.FromBytes(data)
+	r.blobs[d] = data
+	return ocispec.Descriptor{MediaType: mediaType, Digest: d, Size: int64(len(data))}
+}
+
+func (r *testRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	path := strings.TrimPrefix(req.URL.Path, "/v2/")
+
+	var (
+		data        []byte
+		contentType = "application/octet-stream"
+	)
+	switch {
+	case req.URL.Path == "/v2/":
+		data = []byte("{}")
+	case strings.HasSuffix(path, "/tags/list"):
+		repository := strings.TrimSuffix(path, "/tags/list")
+		if r.manifests[repository] == nil {
+			http.Error(w, `{"errors":[{"code":"NAME_UNKNOWN"}]}`, http.StatusNotFound)
+			return
+		}
+		tags := []string{}
+		for ref := range r.manifests[repository] {
+			if !strings.HasPrefix(ref, "sha256:") {
+				tags = append(tags, ref)
+			}
+		}
+		sort.Strings(tags)
+		data, _ = json.Marshal(map[string]interface{}{"name": repository, "tags": tags}) //nolint:errcheck
+		contentType = "application/json"
+	case strings.Contains(path, "/manifests/"):
+		repository, ref, _ := strings.Cut(path, "/manifests/")
+		manifest, ok := r.manifests[repository][ref]
+		if !ok {
+			http.Error(w, `{"errors":[{"code":"MANIFEST_UNKNOWN"}]}`, http.StatusNotFound)
+			return
+		}
+		data = manifest
+		contentType = ocispec.MediaTypeImageManifest
+	case strings.Contains(path, "/blobs/"):
+		_, ref, _ := strings.Cut(path, "/blobs/")
+		blob, ok := r.blobs[digest.Digest(ref)]
+		if !ok {
+			http.Error(w, `{"errors":[{"code":"BLOB_UNKNOWN"}]}`, http.StatusNotFound)
+			return
+		}
+		data = blob
+	default:
+		http.NotFound(w, req)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Docker-Content-Digest", digest.FromBytes(data).String())
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	if req.Method != http.MethodHead {
+		w.Write(data) //nolint:errcheck
+	}
+}
+
+func testPresetChart(version string) *chart.Chart {
+	return &chart.Chart{
+		Metadata: &chart.Metadata{APIVersion: chart.APIVersionV2, Name: "ourpreset", Version: version, Type: "application"},
+		Templates: []*chart.File{
+			{Name: "templates/controlplane.yaml", Data: []byte("machine:\n  type: controlplane\n")},
+		},
+	}
+}
+
+func TestLoadExternalPresetOCI(t *testing.T) {
+	r, host := newTestRegistry(t)
+	for _, version := range []string{"1.0.0", "1.2.0", "1.10.0"} {
+		r.push(t, "charts/ourpreset", testPresetChart(version))
+	}
+
+	testCases := []struct {
+		ref     string
+		version string
+	}{
+		{"oci://" + host + "/charts/ourpreset", "1.10.0"},
+		{"oci://" + host + "/charts/ourpreset:1.2.0", "1.2.0"},
+	}
+
+	for _, tc := range testCases {
+		presetChart, err := loadExternalPreset(tc.ref, true)
+		if err != nil {
+			t.Errorf("loadExternalPreset(%s): %s", tc.ref, err)
+			continue
+		}
+		if presetChart.Metadata.Version != tc.version {
+			t.Errorf("loadExternalPreset(%s): expected version %s, got %s", tc.ref, tc.version, presetChart.Metadata.Version)
+		}
+		if len(presetChart.Templates) != 1 || presetChart.Templates[0].Name != "templates/controlplane.yaml" {
+			t.Errorf("loadExternalPreset(%s): unexpected templates %v", tc.ref, presetChart.Templates)
+		}
+	}
+
+	for _, ref := range []string{
+		"oci://" + host + "/charts/missing",
+		"oci://" + host + "/charts/ourpreset:2.0.0",
+	} {
+		_, err := loadExternalPreset(ref, true)
+		if err == nil || !strings.Contains(err.Error(), "failed to download preset "+ref) {
+			t.Errorf("loadExternalPreset(%s): expected a download error, got %v", ref, err)
+		}
+	}
+}
+
+func TestUpdateChartDependenciesOCI(t *testing.T) {
+	r, host := newTestRegistry(t)
+	for _, version := range []string{"1.0.0", "1.2.0", "2.0.0"} {
+		r.push(t, "charts/ourpreset", testPresetChart(version))
+	}
+
+	rootDir := Config.RootDir
+	t.Cleanup(func() { Config.RootDir = rootDir })
+	Config.RootDir = t.TempDir()
+
+	writeTestFiles(t, Config.RootDir, map[string]string{
+		"Chart.yaml": "apiVersion: v2\nname: cluster\nversion: 0.1.0\ndependencies:\n" +
+			"  - name: ourpreset\n    version: ~1.0\n    repository: oci://" + host + "/charts\n",
+	})
+
+	if err := updateChartDependencies(true); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(Config.RootDir, "charts", "ourpreset-1.0.0.tgz")); err != nil {
+		t.Errorf("pinned dependency wasn't downloaded: %s", err)
+	}
+	lock, err := os.ReadFile(filepath.Join(Config.RootDir, "Chart.lock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(lock), "version: 1.0.0") {
+		t.Errorf("unexpected Chart.lock:\n%s", lock)
+	}
+
+	writeTestFiles(t, Config.RootDir, map[string]string{
+		"Chart.yaml": "apiVersion: v2\nname: cluster\nversion: 0.1.0\ndependencies:\n" +
+			"  - name: missing\n    version: 1.0.0\n    repository: oci://" + host + "/charts\n",
+	})
+	os.Remove(filepath.Join(Config.RootDir, "Chart.lock")) //nolint:errcheck
+
+	if err := updateChartDependencies(true); err == nil {
+		t.Error("expected an error for a missing dependency")
+	}
+}
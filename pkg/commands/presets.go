@@ -0,0 +1,319 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/aenix-io/talm/pkg/engine"
+	"github.com/aenix-io/talm/pkg/generated"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+
+	"github.com/siderolabs/talos/pkg/machinery/constants"
+)
+
+// presetRequiredValuesAnnotation lists values which have to be set by the user in Chart.yaml of a preset.
+const presetRequiredValuesAnnotation = "talm.aenix.io/required-values"
+
+var presetsCmdFlags struct {
+	plainHTTP bool
+	raw       bool
+}
+
+var presetsCmd = &cobra.Command{
+	Use:   "presets",
+	Short: "List presets available for 'talm init'",
+	Long: `List presets available for 'talm init'.
+
+Besides presets built into the binary, remote presets can be configured in
+` + "`<user config dir>/talm/presets.yaml`" + `:
+
+  presets:
+    ourpreset: oci://registry.example.com/charts/ourpreset:1.2.0`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return listPresets(os.Stdout)
+	},
+}
+
+var presetsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List presets available for 'talm init'",
+	Long:  ``,
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return listPresets(os.Stdout)
+	},
+}
+
+var presetsShowCmd = &cobra.Command{
+	Use:   "show <preset>",
+	Short: "Show default values and templates of a preset rendered with them",
+	Long: `Show default values of a preset and its templates rendered offline with them,
+without initializing a project. The preset can be a name of a built-in or configured
+preset, an OCI reference, a chart URL or a local path.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		presetChart, source, err := loadPreset(args[0], presetsCmdFlags.plainHTTP)
+		if err != nil {
+			return err
+		}
+
+		printPresetInfo(os.Stdout, args[0], source, presetChart)
+
+		for _, f := range presetChart.Raw {
+			if f.Name == "values.yaml" {
+				fmt.Printf("\n# Source: %s\n%s", f.Name, f.Data)
+			}
+		}
+
+		for _, tmpl := range presetTemplates(presetChart) {
+			if presetsCmdFlags.raw {
+				fmt.Printf("\n---\n# Source: %s\n%s", tmpl.Name, tmpl.Data)
+				continue
+			}
+			output, err := renderPresetTemplate(presetChart, tmpl.Name)
+			if err != nil {
+				return fmt.Errorf("failed to render %s: %w", tmpl.Name, err)
+			}
+			fmt.Printf("\n---\n# Source: %s\n%s", tmpl.Name, output)
+		}
+
+		return nil
+	},
+}
+
+func listPresets(w io.Writer) error {
+	for i, name := range generated.AvailablePresets {
+		presetChart, err := builtinPreset(name)
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		printPresetInfo(w, name, "built-in", presetChart)
+	}
+
+	configured, err := loadPresetsConfig()
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(configured))
+	for name := range configured {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Fprintln(w)
+		presetChart, err := loadExternalPreset(configured[name], presetsCmdFlags.plainHTTP)
+		if err != nil {
+			fmt.Fprintf(w, "%s (%s)\n  Error: %v\n", name, configured[name], err)
+			continue
+		}
+		printPresetInfo(w, name, configured[name], presetChart)
+	}
+
+	return nil
+}
+
+// printPresetInfo prints description, required values, default versions and files of the preset.
+func printPresetInfo(w io.Writer, name, source string, presetChart *chart.Chart) {
+	talosVersion, kubernetesVersion := presetVersions(presetChart)
+	if talosVersion == "" {
+		talosVersion = "latest supported"
+	}
+	requiredValues := presetChart.Metadata.Annotations[presetRequiredValuesAnnotation]
+	if requiredValues == "" {
+		requiredValues = "none"
+	}
+
+	files := make([]string, 0, len(presetChart.Raw))
+	for _, f := range presetChart.Raw {
+		files = append(files, f.Name)
+	}
+	sort.Strings(files)
+	files = append(files, "charts/"+libraryChartName+"/", "secrets.yaml", "talosconfig", chartLockFile)
+
+	fmt.Fprintf(w, "%s (%s)\n", name, source)
+	fmt.Fprintf(w, "  Description:        %s\n", presetChart.Metadata.Description)
+	fmt.Fprintf(w, "  Required values:    %s\n", requiredValues)
+	fmt.Fprintf(w, "  Talos version:      %s\n", talosVersion)
+	fmt.Fprintf(w, "  Kubernetes version: %s\n", kubernetesVersion)
+	fmt.Fprintf(w, "  Files:              %s\n", strings.Join(files, ", "))
+}
+
+// presetVersions returns default Talos and Kubernetes versions from templateOptions in Chart.yaml of the preset.
+func presetVersions(presetChart *chart.Chart) (string, string) {
+	var chartConfig struct {
+		TemplateOptions struct {
+			TalosVersion      string `yaml:"talosVersion"`
+			KubernetesVersion string `yaml:"kubernetesVersion"`
+		} `yaml:"templateOptions"`
+	}
+	for _, f := range presetChart.Raw {
+		if f.Name == "Chart.yaml" {
+			yaml.Unmarshal(f.Data, &chartConfig) //nolint:errcheck
+		}
+	}
+
+	kubernetesVersion := chartConfig.TemplateOptions.KubernetesVersion
+	if kubernetesVersion == "" {
+		kubernetesVersion = constants.DefaultKubernetesVersion
+	}
+	return chartConfig.TemplateOptions.TalosVersion, kubernetesVersion
+}
+
+// loadPreset loads a built-in preset, a preset configured in presets.yaml or an external chart.
+func loadPreset(name string, plainHTTP bool) (*chart.Chart, string, error) {
+	if isValidPreset(name) {
+		presetChart, err := builtinPreset(name)
+		return presetChart, "built-in", err
+	}
+
+	ref, err := resolvePresetRef(name)
+	if err != nil {
+		return nil, "", err
+	}
+	if !isExternalPreset(ref) {
+		return nil, "", fmt.Errorf("unknown preset: %s. Valid presets are: %s, an OCI reference (oci://...), a chart URL or a local path", name, generated.AvailablePresets)
+	}
+
+	presetChart, err := loadExternalPreset(ref, plainHTTP)
+	return presetChart, ref, err
+}
+
+// builtinPreset loads the preset chart compiled into the binary.
+func builtinPreset(name string) (*chart.Chart, error) {
+	var files []*loader.BufferedFile
+	for path, content := range generated.PresetFiles {
+		chartPath, ok := strings.CutPrefix(path, name+"/")
+		if !ok {
+			continue
+		}
+		if chartPath == "Chart.yaml" {
+			content = fmt.Sprintf(content, name, Config.InitOptions.Version)
+		}
+		files = append(files, &loader.BufferedFile{Name: chartPath, Data: []byte(content)})
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("preset %s not found", name)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+
+	return loader.LoadFiles(files)
+}
+
+// presetsConfigPath returns path of the file with configured remote presets.
+func presetsConfigPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "talm", "presets.yaml"), nil
+}
+
+// loadPresetsConfig returns references of configured remote presets by their names.
+func loadPresetsConfig() (map[string]string, error) {
+	path, err := presetsConfigPath()
+	if err != nil {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var presetsConfig struct {
+		Presets map[string]string `yaml:"presets"`
+	}
+	if err = yaml.Unmarshal(data, &presetsConfig); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal %s: %w", path, err)
+	}
+	return presetsConfig.Presets, nil
+}
+
+// resolvePresetRef returns the reference of a configured remote preset, other presets are returned as is.
+func resolvePresetRef(preset string) (string, error) {
+	configured, err := loadPresetsConfig()
+	if err != nil {
+		return "", err
+	}
+	if ref, ok := configured[preset]; ok {
+		return ref, nil
+	}
+	return preset, nil
+}
+
+// presetTemplates returns templates of the preset which render machine configs, partials are skipped.
+func presetTemplates(presetChart *chart.Chart) []*chart.File {
+	var templates []*chart.File
+	for _, tmpl := range presetChart.Templates {
+		if strings.HasPrefix(filepath.Base(tmpl.Name), "_") || filepath.Ext(tmpl.Name) != ".yaml" {
+			continue
+		}
+		templates = append(templates, tmpl)
+	}
+	sort.Slice(templates, func(i, j int) bool { return templates[i].Name < templates[j].Name })
+	return templates
+}
+
+// renderPresetTemplate renders the template offline with default values of the preset
+// in a temporary project.
+func renderPresetTemplate(presetChart *chart.Chart, templateFile string) ([]byte, error) {
+	root, err := os.MkdirTemp("", "talm-preset-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(root) //nolint:errcheck
+
+	files := map[string][]byte{}
+	for _, f := range presetChart.Raw {
+		files[f.Name] = f.Data
+	}
+	for path, data := range embeddedLibraryChart() {
+		files[filepath.Join("charts", libraryChartName, path)] = data
+	}
+	for path, data := range files {
+		file := filepath.Join(root, path)
+		if err = os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+			return nil, err
+		}
+		if err = os.WriteFile(file, data, 0o644); err != nil {
+			return nil, err
+		}
+	}
+
+	talosVersion, kubernetesVersion := presetVersions(presetChart)
+	return engine.Render(context.Background(), nil, engine.Options{
+		Root:              root,
+		Offline:           true,
+		TalosVersion:      talosVersion,
+		KubernetesVersion: kubernetesVersion,
+		TemplateFiles:     []string{templateFile},
+	})
+}
+
+func init() {
+	presetsCmd.PersistentFlags().BoolVar(&presetsCmdFlags.plainHTTP, "plain-http", false, "use insecure HTTP connections to download remote presets")
+	presetsShowCmd.Flags().BoolVar(&presetsCmdFlags.raw, "raw", false, "print template sources instead of rendering them")
+
+	presetsCmd.AddCommand(presetsListCmd)
+	presetsCmd.AddCommand(presetsShowCmd)
+	addCommand(presetsCmd)
+}
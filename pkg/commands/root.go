@@ -40,7 +40,9 @@ var Config struct {
 		WithSecrets       string   `yaml:"withSecrets"`
 		KubernetesVersion string   `yaml:"kubernetesVersion"`
 		Full              bool     `yaml:"full"`
+		PatchFormat       string   `yaml:"patchFormat"`
 		Debug             bool     `yaml:"debug"`
+		Strict            bool     `yaml:"strict"`
 	} `yaml:"templateOptions"`
 	ApplyOptions struct {
 		DryRun           bool   `yaml:"preserve"`
@@ -94,6 +96,20 @@ func WithClientMaintenance(enforceFingerprints []string, action func(context.Con
 	return GlobalArgs.WithClientMaintenance(enforceFingerprints, action)
 }
 
+// ExitCodeError makes the command exit with Code instead of the default exit code 1.
+type ExitCodeError struct {
+	Code int
+	Err  error
+}
+
+func (e *ExitCodeError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ExitCodeError) Unwrap() error {
+	return e.Err
+}
+
 // Commands is a list of commands published by the package.
 var Commands []*cobra.Command
 
@@ -124,6 +140,9 @@ func processModelineAndUpdateGlobals(configFile string, nodesFromArgs bool, endp
 				GlobalArgs.Endpoints = append(GlobalArgs.Endpoints, modelineConfig.Endpoints...)
 			}
 		}
+		if GlobalArgs.CmdContext == "" {
+			GlobalArgs.CmdContext = modelineConfig.Context
+		}
 	}
 
 	if len(GlobalArgs.Nodes) < 1 {
@@ -132,3 +151,33 @@ func processModelineAndUpdateGlobals(configFile string, nodesFromArgs bool, endp
 
 	return nil
 }
+
+// modelineArgs returns a copy of GlobalArgs with nodes and endpoints taken from the modeline of the config file.
+//
+// Unlike processModelineAndUpdateGlobals it doesn't touch GlobalArgs, so it's safe to use concurrently.
+func modelineArgs(configFile string, nodesFromArgs bool, endpointsFromArgs bool) (global.Args, error) {
+	args := GlobalArgs
+	args.Nodes = append([]string(nil), GlobalArgs.Nodes...)
+	args.Endpoints = append([]string(nil), GlobalArgs.Endpoints...)
+
+	modelineConfig, err := modeline.ReadAndParseModeline(configFile)
+	if err != nil {
+		return args, fmt.Errorf("modeline parsing failed for %s: %w", configFile, err)
+	}
+
+	if !nodesFromArgs && len(modelineConfig.Nodes) > 0 {
+		args.Nodes = modelineConfig.Nodes
+	}
+	if !endpointsFromArgs && len(modelineConfig.Endpoints) > 0 {
+		args.Endpoints = modelineConfig.Endpoints
+	}
+	if args.CmdContext == "" {
+		args.CmdContext = modelineConfig.Context
+	}
+
+	if len(args.Nodes) < 1 {
+		return args, fmt.Errorf("nodes are not set for %s: please use `--nodes` flag or configuration file to set the nodes to run the command against", configFile)
+	}
+
+	return args, nil
+}
@@ -0,0 +1,148 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/aenix-io/talm/pkg/sops"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+
+	"github.com/siderolabs/talos/pkg/machinery/config/generate/secrets"
+)
+
+var secretsCmdFlags struct {
+	withSecrets string
+}
+
+var secretsCmd = &cobra.Command{
+	Use:   "secrets",
+	Short: "Manage the cluster secrets bundle",
+	Long:  ``,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		if !cmd.Flags().Changed("with-secrets") && Config.TemplateOptions.WithSecrets != "" {
+			secretsCmdFlags.withSecrets = Config.TemplateOptions.WithSecrets
+		}
+		return nil
+	},
+}
+
+var secretsEditCmd = &cobra.Command{
+	Use:   "edit",
+	Short: "Edit the secrets bundle in $EDITOR",
+	Long: `Open the secrets bundle in the editor from TALM_EDITOR or EDITOR environment variables.
+
+Encrypted bundles are decrypted into a temporary file and encrypted again for
+the same age recipients after editing.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return secretsEdit(secretsCmdFlags.withSecrets)
+	},
+}
+
+func secretsEdit(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var recipients []string
+	encrypted := sops.IsEncrypted(data)
+	if encrypted {
+		if recipients, err = sops.Recipients(data); err != nil {
+			return err
+		}
+		if data, err = sops.Decrypt(data); err != nil {
+			return fmt.Errorf("failed to decrypt %s: %w", path, err)
+		}
+	}
+
+	tmp, err := os.CreateTemp("", "talm-secrets-*.yaml")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) //nolint:errcheck
+
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close() //nolint:errcheck
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	if err = launchEditor(tmp.Name()); err != nil {
+		return err
+	}
+
+	edited, err := os.ReadFile(tmp.Name())
+	if err != nil {
+		return err
+	}
+
+	if bytes.Equal(edited, data) {
+		fmt.Fprintln(os.Stderr, "Edit cancelled, no changes made.")
+		return nil
+	}
+
+	var bundle secrets.Bundle
+	if err = yaml.Unmarshal(edited, &bundle); err != nil {
+		return fmt.Errorf("edited secrets bundle is invalid, no changes made: %w", err)
+	}
+
+	if encrypted {
+		if edited, err = sops.Encrypt(edited, recipients); err != nil {
+			return fmt.Errorf("failed to encrypt %s: %w", path, err)
+		}
+	}
+
+	if err = os.WriteFile(path, edited, info.Mode().Perm()); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Updated %s\n", path)
+
+	return nil
+}
+
+// launchEditor opens the file in the editor from TALM_EDITOR or EDITOR, falling back to vi.
+func launchEditor(path string) error {
+	editor := "vi"
+	for _, env := range []string{"TALM_EDITOR", "EDITOR"} {
+		if value := strings.TrimSpace(os.Getenv(env)); value != "" {
+			editor = value
+			break
+		}
+	}
+
+	args := strings.Fields(editor)
+	cmd := exec.Command(args[0], append(args[1:], path)...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("editor %q failed: %w", editor, err)
+	}
+
+	return nil
+}
+
+func init() {
+	secretsCmd.PersistentFlags().StringVar(&secretsCmdFlags.withSecrets, "with-secrets", "secrets.yaml", "path to the secrets bundle")
+
+	secretsCmd.AddCommand(secretsEditCmd)
+	addCommand(secretsCmd)
+}
@@ -0,0 +1,690 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aenix-io/talm/pkg/engine"
+	"github.com/aenix-io/talm/pkg/modeline"
+	"github.com/aenix-io/talm/pkg/sops"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/siderolabs/talos/cmd/talosctl/pkg/talos/global"
+	"github.com/siderolabs/talos/pkg/cluster"
+	machineapi "github.com/siderolabs/talos/pkg/machinery/api/machine"
+	"github.com/siderolabs/talos/pkg/machinery/client"
+	clientconfig "github.com/siderolabs/talos/pkg/machinery/client/config"
+	"github.com/siderolabs/talos/pkg/machinery/config"
+	"github.com/siderolabs/talos/pkg/machinery/config/encoder"
+	"github.com/siderolabs/talos/pkg/machinery/config/generate/secrets"
+	"github.com/siderolabs/talos/pkg/machinery/config/machine"
+	"github.com/siderolabs/talos/pkg/machinery/constants"
+	"github.com/siderolabs/talos/pkg/rotate/pki/kubernetes"
+	"github.com/siderolabs/talos/pkg/rotate/pki/talos"
+)
+
+// Secrets which can be rotated, in the order of rotation.
+const (
+	rotateOSCA           = "os-ca"
+	rotateK8sCA          = "k8s-ca"
+	rotateBootstrapToken = "bootstrap-token"
+	rotateTrustdToken    = "trustd-token"
+	rotateAESCBC         = "aescbc"
+)
+
+var rotateTargets = []string{rotateOSCA, rotateK8sCA, rotateBootstrapToken, rotateTrustdToken, rotateAESCBC}
+
+// Finished phases of the aescbc key rotation.
+const (
+	phaseSecretboxEnabled   = "secretbox-enabled"
+	phaseSecretsReencrypted = "secrets-reencrypted"
+)
+
+var secretsRotateCmdFlags struct {
+	what              []string
+	configFiles       []string // -f/--files
+	talosVersion      string
+	kubernetesVersion string
+	dryRun            bool
+	nodesFromArgs     bool
+	endpointsFromArgs bool
+}
+
+var secretsRotateCmd = &cobra.Command{
+	Use:   "rotate",
+	Short: "Rotate cluster secrets and roll them out to the nodes",
+	Long: `Generate new values for the selected secrets and roll them out to the nodes from the node files.
+
+Supported secrets:
+  os-ca            Talos API CA, new talosconfig is written after the rotation
+  k8s-ca           Kubernetes API CA
+  bootstrap-token  Kubernetes bootstrap token (cluster.token)
+  trustd-token     trustd token (machine.token)
+  aescbc           legacy aescbc encryption key, Secrets are re-encrypted with secretbox first
+
+CAs are rotated in phases: the new CA is accepted first, then it becomes issuing,
+and finally the old CA is dropped. Other secrets are rolled out by re-rendering
+the node files with the new secrets like 'talm template -I' does and applying
+them, control plane nodes first. Node files are re-rendered after the rotation
+of CAs too, so the next apply doesn't revert it.
+
+New secrets and finished steps are saved into secrets.pending.yaml next to the
+secrets bundle before nodes are touched, and the bundle is updated after every
+finished step. An interrupted rotation is continued with the same new secrets by
+running the command again, the pending file is removed when the rotation is done.
+
+The secretbox encryption key can't be rotated, as Kubernetes doesn't support
+several keys of the same provider in Talos.`,
+	Args: cobra.NoArgs,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if !cmd.Flags().Changed("talos-version") {
+			secretsRotateCmdFlags.talosVersion = Config.TemplateOptions.TalosVersion
+		}
+		if !cmd.Flags().Changed("kubernetes-version") {
+			secretsRotateCmdFlags.kubernetesVersion = Config.TemplateOptions.KubernetesVersion
+		}
+		if len(secretsRotateCmdFlags.what) == 0 {
+			return fmt.Errorf("--what is required, valid values are: %s", strings.Join(rotateTargets, ", "))
+		}
+		for _, what := range secretsRotateCmdFlags.what {
+			if !slices.Contains(rotateTargets, what) {
+				return fmt.Errorf("unknown secret %q, valid values are: %s", what, strings.Join(rotateTargets, ", "))
+			}
+		}
+		if len(secretsRotateCmdFlags.configFiles) == 0 {
+			return fmt.Errorf("node files must be specified with --file")
+		}
+		secretsRotateCmdFlags.nodesFromArgs = len(GlobalArgs.Nodes) > 0
+		secretsRotateCmdFlags.endpointsFromArgs = len(GlobalArgs.Endpoints) > 0
+
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return secretsRotate(cmd.Context())
+	},
+}
+
+// rotateNodeFile is a node file the rotated secrets are rolled out to.
+type rotateNodeFile struct {
+	file        string
+	args        global.Args
+	machineType machine.Type
+}
+
+// rotationState is an unfinished rotation saved next to the secrets bundle.
+type rotationState struct {
+	What    []string        `yaml:"what"`
+	Done    []string        `yaml:"done,omitempty"`
+	Phase   string          `yaml:"phase,omitempty"`
+	Secrets *secrets.Bundle `yaml:"secrets"`
+}
+
+// secretsRotator holds the state of the rotation.
+type secretsRotator struct {
+	path       string
+	recipients []string
+
+	bundle    *secrets.Bundle
+	newBundle *secrets.Bundle
+	state     *rotationState
+
+	files       []rotateNodeFile
+	clusterInfo cluster.Info
+
+	// renderFile and applyConfig change node files and nodes, they are replaced in tests
+	renderFile  func(file rotateNodeFile, bundle *secrets.Bundle) error
+	applyConfig func(file rotateNodeFile, data []byte) error
+}
+
+func secretsRotate(ctx context.Context) error {
+	templateCmdFlags.inplace = true
+	if err := templateCmd.PreRunE(templateCmd, nil); err != nil {
+		return err
+	}
+
+	r := &secretsRotator{
+		path:        secretsCmdFlags.withSecrets,
+		renderFile:  renderRotatedNodeFile,
+		applyConfig: applyRotatedConfig,
+	}
+
+	return r.run(ctx)
+}
+
+// pendingSecretsPath returns the path of the unfinished rotation state, e.g. secrets.pending.yaml.
+func pendingSecretsPath(path string) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + ".pending" + ext
+}
+
+func (r *secretsRotator) run(ctx context.Context) error {
+	data, err := os.ReadFile(r.path)
+	if err != nil {
+		return err
+	}
+	if sops.IsEncrypted(data) {
+		if r.recipients, err = sops.Recipients(data); err != nil {
+			return err
+		}
+	}
+
+	if r.bundle, err = engine.LoadSecretsBundle(r.path); err != nil {
+		return fmt.Errorf("failed to load secrets bundle: %w", err)
+	}
+
+	var what []string
+	for _, target := range rotateTargets {
+		if slices.Contains(secretsRotateCmdFlags.what, target) {
+			what = append(what, target)
+		}
+	}
+
+	if err = r.loadPending(what); err != nil {
+		return err
+	}
+
+	if r.state == nil {
+		var versionContract *config.VersionContract
+		if secretsRotateCmdFlags.talosVersion != "" {
+			if versionContract, err = config.ParseContractFromVersion(secretsRotateCmdFlags.talosVersion); err != nil {
+				return fmt.Errorf("invalid talos-version: %w", err)
+			}
+		}
+
+		newBundle, err := secrets.NewBundle(secrets.NewFixedClock(time.Now()), versionContract)
+		if err != nil {
+			return fmt.Errorf("failed to generate new secrets: %w", err)
+		}
+		r.state = &rotationState{What: what, Secrets: newBundle}
+	}
+	r.newBundle = r.state.Secrets
+
+	if err = r.loadNodeFiles(ctx); err != nil {
+		return err
+	}
+
+	// New secrets are saved before nodes are touched, so the rotation can be continued
+	if !secretsRotateCmdFlags.dryRun {
+		if err = r.savePending(); err != nil {
+			return err
+		}
+	}
+
+	steps := map[string]func(ctx context.Context) error{
+		rotateOSCA:           r.rotateOSCA,
+		rotateK8sCA:          r.rotateK8sCA,
+		rotateBootstrapToken: r.rotateBootstrapToken,
+		rotateTrustdToken:    r.rotateTrustdToken,
+		rotateAESCBC:         r.rotateAESCBC,
+	}
+
+	for _, what := range r.state.What {
+		if slices.Contains(r.state.Done, what) {
+			fmt.Printf("> Rotation of %s is already done\n", what)
+			continue
+		}
+
+		fmt.Printf("> Rotating %s\n", what)
+
+		if err = steps[what](ctx); err != nil {
+			return fmt.Errorf("failed to rotate %s: %w", what, err)
+		}
+
+		if secretsRotateCmdFlags.dryRun {
+			continue
+		}
+
+		if err = r.saveBundle(); err != nil {
+			return err
+		}
+
+		r.state.Done = append(r.state.Done, what)
+		r.state.Phase = ""
+		if err = r.savePending(); err != nil {
+			return err
+		}
+
+		fmt.Printf("> Rotation of %s is done, %s is updated\n", what, r.path)
+	}
+
+	if secretsRotateCmdFlags.dryRun {
+		fmt.Println("> Dry-run mode enabled, no changes were made to the cluster, re-run without `--dry-run` to apply the changes.")
+		return nil
+	}
+
+	return os.Remove(pendingSecretsPath(r.path))
+}
+
+// loadPending loads the unfinished rotation, it must be continued with the same secrets to rotate.
+func (r *secretsRotator) loadPending(what []string) error {
+	path := pendingSecretsPath(r.path)
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if sops.IsEncrypted(data) {
+		if data, err = sops.Decrypt(data); err != nil {
+			return fmt.Errorf("failed to decrypt %s: %w", path, err)
+		}
+	}
+
+	state := &rotationState{Secrets: &secrets.Bundle{Clock: secrets.NewClock()}}
+	if err = yaml.Unmarshal(data, state); err != nil {
+		return fmt.Errorf("failed to load %s: %w", path, err)
+	}
+
+	if !slices.Equal(state.What, what) {
+		return fmt.Errorf("rotation of %s is not finished: continue it with --what %s or remove %s to start over", strings.Join(state.What, ","), strings.Join(state.What, ","), path)
+	}
+
+	fmt.Printf("> Continuing the rotation from %s\n", path)
+	r.state = state
+
+	return nil
+}
+
+// savePending writes new secrets and finished steps of the rotation, encrypted like the secrets bundle.
+func (r *secretsRotator) savePending() error {
+	path := pendingSecretsPath(r.path)
+
+	data, err := yaml.Marshal(r.state)
+	if err != nil {
+		return err
+	}
+
+	perm := os.FileMode(0o600)
+	if len(r.recipients) > 0 {
+		if data, err = sops.Encrypt(data, r.recipients); err != nil {
+			return fmt.Errorf("failed to encrypt %s: %w", path, err)
+		}
+		perm = 0o644
+	}
+
+	return os.WriteFile(path, data, perm)
+}
+
+// setPhase records a finished phase of the current step.
+func (r *secretsRotator) setPhase(phase string) error {
+	r.state.Phase = phase
+	if secretsRotateCmdFlags.dryRun {
+		return nil
+	}
+
+	return r.savePending()
+}
+
+// loadNodeFiles reads nodes and machine types from the node files.
+func (r *secretsRotator) loadNodeFiles(ctx context.Context) error {
+	var state clusterNodes
+
+	for _, configFile := range secretsRotateCmdFlags.configFiles {
+		fileArgs, err := modelineArgs(configFile, secretsRotateCmdFlags.nodesFromArgs, secretsRotateCmdFlags.endpointsFromArgs)
+		if err != nil {
+			return err
+		}
+
+		configBundle, err := engine.FullConfigProcess(ctx, r.renderOptions(), []string{"@" + configFile})
+		if err != nil {
+			return fmt.Errorf("full config processing error: %s", err)
+		}
+
+		machineType := configBundle.ControlPlaneCfg.Machine().Type()
+		if machineType.IsControlPlane() {
+			state.ControlPlaneNodes = append(state.ControlPlaneNodes, fileArgs.Nodes...)
+		} else {
+			state.WorkerNodes = append(state.WorkerNodes, fileArgs.Nodes...)
+		}
+
+		r.files = append(r.files, rotateNodeFile{file: configFile, args: fileArgs, machineType: machineType})
+	}
+
+	if len(state.ControlPlaneNodes) == 0 {
+		return fmt.Errorf("node files of control plane nodes must be specified")
+	}
+
+	// Control plane nodes are always updated first, the first file is also used to reach the cluster
+	slices.SortStableFunc(r.files, func(a, b rotateNodeFile) int {
+		switch {
+		case a.machineType.IsControlPlane() == b.machineType.IsControlPlane():
+			return 0
+		case a.machineType.IsControlPlane():
+			return -1
+		default:
+			return 1
+		}
+	})
+
+	if err := state.InitNodeInfos(); err != nil {
+		return err
+	}
+	r.clusterInfo = &state
+
+	return nil
+}
+
+func (r *secretsRotator) renderOptions() engine.Options {
+	return engine.Options{
+		TalosVersion:      secretsRotateCmdFlags.talosVersion,
+		SecretsBundle:     r.bundle,
+		KubernetesVersion: secretsRotateCmdFlags.kubernetesVersion,
+	}
+}
+
+func (r *secretsRotator) rotateOSCA(ctx context.Context) error {
+	talosconfig, err := clientconfig.Open(GlobalArgs.Talosconfig)
+	if err != nil {
+		return fmt.Errorf("failed to open config file %q: %w", GlobalArgs.Talosconfig, err)
+	}
+
+	contextName := talosconfig.Context
+	if GlobalArgs.CmdContext != "" {
+		contextName = GlobalArgs.CmdContext
+	}
+
+	var newTalosconfig *clientconfig.Config
+
+	err = r.files[0].args.WithClientNoNodes(func(ctx context.Context, c *client.Client) error {
+		newTalosconfig, err = talos.Rotate(ctx, talos.Options{
+			DryRun:        secretsRotateCmdFlags.dryRun,
+			CurrentClient: c,
+			ClusterInfo:   r.clusterInfo,
+			ContextName:   contextName,
+			Endpoints:     c.GetEndpoints(),
+			NewTalosCA:    r.newBundle.Certs.OS,
+			EncoderOption: encoder.WithComments(encoder.CommentsDisabled),
+			Printf:        func(format string, args ...any) { fmt.Printf(format, args...) },
+		})
+
+		return err
+	})
+	if err != nil || secretsRotateCmdFlags.dryRun {
+		return err
+	}
+
+	r.bundle.Certs.OS = r.newBundle.Certs.OS
+
+	// Keep other contexts of the talosconfig untouched
+	talosconfig.Contexts[contextName] = newTalosconfig.Contexts[contextName]
+
+	fmt.Printf("> Writing new talosconfig to %q\n", GlobalArgs.Talosconfig)
+
+	if err = talosconfig.Save(GlobalArgs.Talosconfig); err != nil {
+		return err
+	}
+
+	return r.renderFiles()
+}
+
+func (r *secretsRotator) rotateK8sCA(ctx context.Context) error {
+	err := r.files[0].args.WithClientNoNodes(func(ctx context.Context, c *client.Client) error {
+		return kubernetes.Rotate(ctx, kubernetes.Options{
+			DryRun:          secretsRotateCmdFlags.dryRun,
+			TalosClient:     c,
+			ClusterInfo:     r.clusterInfo,
+			NewKubernetesCA: r.newBundle.Certs.K8s,
+			EncoderOption:   encoder.WithComments(encoder.CommentsDisabled),
+			Printf:          func(format string, args ...any) { fmt.Printf(format, args...) },
+		})
+	})
+	if err != nil || secretsRotateCmdFlags.dryRun {
+		return err
+	}
+
+	r.bundle.Certs.K8s = r.newBundle.Certs.K8s
+
+	fmt.Println("> New kubeconfig can be fetched with `talm kubeconfig`.")
+
+	return r.renderFiles()
+}
+
+func (r *secretsRotator) rotateBootstrapToken(ctx context.Context) error {
+	r.bundle.Secrets.BootstrapToken = r.newBundle.Secrets.BootstrapToken
+
+	return r.rollOut(ctx, false)
+}
+
+func (r *secretsRotator) rotateTrustdToken(ctx context.Context) error {
+	r.bundle.TrustdInfo.Token = r.newBundle.TrustdInfo.Token
+
+	return r.rollOut(ctx, false)
+}
+
+// rotateAESCBC replaces the legacy aescbc key. Talos uses secretbox for writing if both keys are set,
+// so the secretbox key is enabled first and all Secrets are re-encrypted with it before the aescbc key is changed.
+func (r *secretsRotator) rotateAESCBC(ctx context.Context) error {
+	if r.state.Phase == "" {
+		if r.bundle.Secrets.SecretboxEncryptionSecret == "" {
+			if r.newBundle.Secrets.SecretboxEncryptionSecret == "" {
+				return fmt.Errorf("secretbox encryption is not supported by Talos version %q, aescbc key can't be rotated safely", secretsRotateCmdFlags.talosVersion)
+			}
+
+			fmt.Println("> Phase 1: enabling secretbox encryption")
+
+			r.bundle.Secrets.SecretboxEncryptionSecret = r.newBundle.Secrets.SecretboxEncryptionSecret
+			if err := r.rollOut(ctx, true); err != nil {
+				return err
+			}
+
+			if !secretsRotateCmdFlags.dryRun {
+				if err := r.saveBundle(); err != nil {
+					return err
+				}
+			}
+		}
+
+		if err := r.setPhase(phaseSecretboxEnabled); err != nil {
+			return err
+		}
+	}
+
+	if r.state.Phase == phaseSecretboxEnabled {
+		fmt.Println("> Phase 2: re-encrypting Secrets with secretbox")
+
+		if err := r.reencryptSecrets(); err != nil {
+			return err
+		}
+
+		if err := r.setPhase(phaseSecretsReencrypted); err != nil {
+			return err
+		}
+	}
+
+	if r.newBundle.Secrets.AESCBCEncryptionSecret == "" {
+		fmt.Println("> Phase 3: removing aescbc key")
+	} else {
+		fmt.Println("> Phase 3: replacing aescbc key")
+	}
+
+	r.bundle.Secrets.AESCBCEncryptionSecret = r.newBundle.Secrets.AESCBCEncryptionSecret
+
+	return r.rollOut(ctx, true)
+}
+
+// reencryptSecrets rewrites all Secrets, so kube-apiserver stores them encrypted with the current key.
+func (r *secretsRotator) reencryptSecrets() error {
+	return r.files[0].args.WithClientNoNodes(func(ctx context.Context, c *client.Client) error {
+		clientProvider := &cluster.ConfigClientProvider{
+			DefaultClient: c,
+		}
+		defer clientProvider.Close() //nolint:errcheck
+
+		kubernetesClient := &cluster.KubernetesClient{
+			ClientProvider: clientProvider,
+		}
+		defer kubernetesClient.K8sClose() //nolint:errcheck
+
+		clientset, err := kubernetesClient.K8sClient(ctx)
+		if err != nil {
+			return err
+		}
+
+		list, err := clientset.CoreV1().Secrets(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return err
+		}
+
+		for _, secret := range list.Items {
+			if secretsRotateCmdFlags.dryRun {
+				continue
+			}
+
+			_, err = clientset.CoreV1().Secrets(secret.Namespace).Update(ctx, &secret, metav1.UpdateOptions{})
+			// Conflicting updates write the secret anyway
+			if err != nil && !apierrors.IsConflict(err) && !apierrors.IsNotFound(err) {
+				return fmt.Errorf("failed to re-encrypt secret %s/%s: %w", secret.Namespace, secret.Name, err)
+			}
+		}
+
+		fmt.Printf("> Re-encrypted %d Secrets\n", len(list.Items))
+
+		return nil
+	})
+}
+
+// rollOut re-renders node files with the current secrets and applies them, control plane nodes first.
+func (r *secretsRotator) rollOut(ctx context.Context, controlPlaneOnly bool) error {
+	for _, file := range r.files {
+		if controlPlaneOnly && !file.machineType.IsControlPlane() {
+			continue
+		}
+
+		if !secretsRotateCmdFlags.dryRun {
+			if err := r.renderFile(file, r.bundle); err != nil {
+				return fmt.Errorf("failed to re-render %s: %w", file.file, err)
+			}
+		}
+
+		configBundle, err := engine.FullConfigProcess(ctx, r.renderOptions(), []string{"@" + file.file})
+		if err != nil {
+			return fmt.Errorf("full config processing error: %s", err)
+		}
+
+		if err = checkRotatedSecrets(configBundle.ControlPlaneCfg, r.bundle); err != nil {
+			return fmt.Errorf("%s: %w", file.file, err)
+		}
+
+		result, err := engine.SerializeConfiguration(configBundle, file.machineType)
+		if err != nil {
+			return fmt.Errorf("error serializing configuration: %s", err)
+		}
+
+		fmt.Printf("- talm: file=%s, nodes=%s, endpoints=%s\n", file.file, file.args.Nodes, file.args.Endpoints)
+
+		if err = r.applyConfig(file, result); err != nil {
+			return fmt.Errorf("error applying new configuration to %s: %w", file.file, err)
+		}
+	}
+
+	return nil
+}
+
+// renderFiles re-renders all node files with the current secrets.
+func (r *secretsRotator) renderFiles() error {
+	for _, file := range r.files {
+		if err := r.renderFile(file, r.bundle); err != nil {
+			return fmt.Errorf("failed to re-render %s: %w", file.file, err)
+		}
+	}
+
+	return nil
+}
+
+// checkRotatedSecrets makes sure the node file doesn't override rolled out secrets of the bundle.
+func checkRotatedSecrets(cfg config.Provider, bundle *secrets.Bundle) error {
+	rendered := secrets.NewBundleFromConfig(secrets.NewClock(), cfg)
+
+	for _, secret := range []struct {
+		name            string
+		rendered, value string
+	}{
+		{"cluster.token", rendered.Secrets.BootstrapToken, bundle.Secrets.BootstrapToken},
+		{"machine.token", rendered.TrustdInfo.Token, bundle.TrustdInfo.Token},
+		{"cluster.secretboxEncryptionSecret", rendered.Secrets.SecretboxEncryptionSecret, bundle.Secrets.SecretboxEncryptionSecret},
+		{"cluster.aescbcEncryptionSecret", rendered.Secrets.AESCBCEncryptionSecret, bundle.Secrets.AESCBCEncryptionSecret},
+	} {
+		if secret.rendered != secret.value {
+			return fmt.Errorf("%s is set in the file and differs from the secrets bundle, remove it from the file to roll out the rotated value", secret.name)
+		}
+	}
+
+	return nil
+}
+
+// renderRotatedNodeFile re-renders the node file in place with the given secrets,
+// the same way as 'talm template -I' does.
+func renderRotatedNodeFile(file rotateNodeFile, bundle *secrets.Bundle) error {
+	modelineConfig, err := modeline.ReadAndParseModeline(file.file)
+	if err != nil {
+		return fmt.Errorf("modeline parsing failed: %w", err)
+	}
+	if len(modelineConfig.Templates) == 0 {
+		fmt.Printf("- talm: file=%s has no templates in the modeline, it is applied as is\n", file.file)
+		return nil
+	}
+
+	templateCmdFlags.secretsBundle = bundle
+	defer func() { templateCmdFlags.secretsBundle = nil }()
+
+	return templateNodeFile(nil, file.file, modelineConfig, true)
+}
+
+// applyRotatedConfig applies the config to the nodes of the node file.
+func applyRotatedConfig(file rotateNodeFile, data []byte) error {
+	return file.args.WithClientNoNodes(func(ctx context.Context, c *client.Client) error {
+		ctx = client.WithNodes(ctx, file.args.Nodes...)
+
+		_, err := c.ApplyConfiguration(ctx, &machineapi.ApplyConfigurationRequest{
+			Data:   data,
+			Mode:   machineapi.ApplyConfigurationRequest_AUTO,
+			DryRun: secretsRotateCmdFlags.dryRun,
+		})
+
+		return err
+	})
+}
+
+// saveBundle writes the secrets bundle, encrypting it for the same recipients if it was encrypted.
+func (r *secretsRotator) saveBundle() error {
+	data, err := yaml.Marshal(r.bundle)
+	if err != nil {
+		return err
+	}
+
+	perm := os.FileMode(0o600)
+	if len(r.recipients) > 0 {
+		if data, err = sops.Encrypt(data, r.recipients); err != nil {
+			return fmt.Errorf("failed to encrypt %s: %w", r.path, err)
+		}
+		perm = 0o644
+	}
+
+	return os.WriteFile(r.path, data, perm)
+}
+
+func init() {
+	secretsRotateCmd.Flags().StringSliceVar(&secretsRotateCmdFlags.what, "what", nil, fmt.Sprintf("secrets to rotate (%s)", strings.Join(rotateTargets, ", ")))
+	secretsRotateCmd.Flags().StringSliceVarP(&secretsRotateCmdFlags.configFiles, "file", "f", nil, "specify node files of all cluster nodes (can specify multiple)")
+	secretsRotateCmd.Flags().StringVar(&secretsRotateCmdFlags.talosVersion, "talos-version", "", "the desired Talos version to generate secrets for (backwards compatibility, e.g. v0.8)")
+	secretsRotateCmd.Flags().StringVar(&secretsRotateCmdFlags.kubernetesVersion, "kubernetes-version", constants.DefaultKubernetesVersion, "desired kubernetes version to run")
+	secretsRotateCmd.Flags().BoolVar(&secretsRotateCmdFlags.dryRun, "dry-run", false, "show the rotation plan without changing the cluster")
+
+	secretsCmd.AddCommand(secretsRotateCmd)
+}
@@ -0,0 +1,175 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aenix-io/talm/pkg/engine"
+	"gopkg.in/yaml.v3"
+
+	"github.com/siderolabs/talos/pkg/machinery/config/configloader"
+	"github.com/siderolabs/talos/pkg/machinery/config/generate/secrets"
+)
+
+// rotationRecorder records the order of changes made by the rotation.
+type rotationRecorder struct {
+	t          *testing.T
+	events     []string
+	tokens     []string
+	failOnFile string
+}
+
+func (rec *rotationRecorder) rotator(secretsPath string) *secretsRotator {
+	return &secretsRotator{
+		path: secretsPath,
+		renderFile: func(file rotateNodeFile, bundle *secrets.Bundle) error {
+			// New secrets must be saved before any node file is changed
+			pending, err := os.ReadFile(pendingSecretsPath(secretsPath))
+			if err != nil {
+				rec.t.Errorf("node file %s is rendered before the pending secrets are saved: %s", file.file, err)
+			}
+			var state rotationState
+			if err = yaml.Unmarshal(pending, &state); err != nil || state.Secrets.Secrets.BootstrapToken != bundle.Secrets.BootstrapToken {
+				rec.t.Errorf("pending secrets don't match the rendered ones: %v", err)
+			}
+
+			rec.events = append(rec.events, "render "+filepath.Base(file.file))
+			return nil
+		},
+		applyConfig: func(file rotateNodeFile, data []byte) error {
+			rec.events = append(rec.events, "apply "+filepath.Base(file.file))
+			if file.file == rec.failOnFile {
+				return errors.New("connection refused")
+			}
+
+			cfg, err := configloader.NewFromBytes(data)
+			if err != nil {
+				rec.t.Fatal(err)
+			}
+			rec.tokens = append(rec.tokens, secrets.NewBundleFromConfig(secrets.NewClock(), cfg).Secrets.BootstrapToken)
+
+			return nil
+		},
+	}
+}
+
+func TestSecretsRotatePhases(t *testing.T) {
+	flags := secretsRotateCmdFlags
+	t.Cleanup(func() { secretsRotateCmdFlags = flags })
+
+	root := t.TempDir()
+
+	bundle, err := secrets.NewBundle(secrets.NewFixedClock(time.Now()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := yaml.Marshal(bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeTestFiles(t, root, map[string]string{
+		"secrets.yaml": string(data),
+		"nodes/worker1.yaml": "# talm: nodes=[\"10.0.0.2\"]\nmachine:\n  type: worker\n" +
+			"cluster:\n  clusterName: test\n  controlPlane:\n    endpoint: https://10.0.0.1:6443\n",
+		"nodes/cp1.yaml": "# talm: nodes=[\"10.0.0.1\"]\nmachine:\n  type: controlplane\n" +
+			"cluster:\n  clusterName: test\n  controlPlane:\n    endpoint: https://10.0.0.1:6443\n",
+	})
+	secretsPath := filepath.Join(root, "secrets.yaml")
+	worker, controlPlane := filepath.Join(root, "nodes", "worker1.yaml"), filepath.Join(root, "nodes", "cp1.yaml")
+
+	secretsRotateCmdFlags.what = []string{rotateTrustdToken, rotateBootstrapToken}
+	secretsRotateCmdFlags.configFiles = []string{worker, controlPlane}
+	secretsRotateCmdFlags.dryRun = false
+
+	// The rotation is interrupted on the worker node
+	rec := &rotationRecorder{t: t, failOnFile: worker}
+	if err = rec.rotator(secretsPath).run(context.Background()); err == nil {
+		t.Fatal("expected the rotation to fail")
+	}
+	if expected := []string{"render cp1.yaml", "apply cp1.yaml", "render worker1.yaml", "apply worker1.yaml"}; !reflect.DeepEqual(rec.events, expected) {
+		t.Errorf("unexpected order of changes %v, expected %v", rec.events, expected)
+	}
+	current, err := engine.LoadSecretsBundle(secretsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current.Secrets.BootstrapToken != bundle.Secrets.BootstrapToken {
+		t.Error("secrets bundle is updated before the rotation is rolled out")
+	}
+	if len(rec.tokens) != 1 || rec.tokens[0] == bundle.Secrets.BootstrapToken {
+		t.Fatalf("expected the new token to be applied, got %v", rec.tokens)
+	}
+	newToken := rec.tokens[0]
+
+	// Another set of secrets can't be rotated until the rotation is finished
+	secretsRotateCmdFlags.what = []string{rotateOSCA}
+	if err = (&rotationRecorder{t: t}).rotator(secretsPath).run(context.Background()); err == nil {
+		t.Error("expected an error for an unfinished rotation")
+	}
+
+	// The rotation is continued with the same secrets
+	secretsRotateCmdFlags.what = []string{rotateBootstrapToken, rotateTrustdToken}
+	rec = &rotationRecorder{t: t}
+	if err = rec.rotator(secretsPath).run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"render cp1.yaml", "apply cp1.yaml", "render worker1.yaml", "apply worker1.yaml", // bootstrap-token
+		"render cp1.yaml", "apply cp1.yaml", "render worker1.yaml", "apply worker1.yaml", // trustd-token
+	}
+	if !reflect.DeepEqual(rec.events, expected) {
+		t.Errorf("unexpected order of changes %v, expected %v", rec.events, expected)
+	}
+	for _, token := range rec.tokens {
+		if token != newToken {
+			t.Errorf("continued rotation applied token %s, expected %s", token, newToken)
+		}
+	}
+
+	current, err = engine.LoadSecretsBundle(secretsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current.Secrets.BootstrapToken != newToken || current.TrustdInfo.Token == bundle.TrustdInfo.Token {
+		t.Error("secrets bundle isn't updated with the rotated secrets")
+	}
+	if _, err = os.Stat(pendingSecretsPath(secretsPath)); !os.IsNotExist(err) {
+		t.Errorf("pending secrets aren't removed after the rotation: %v", err)
+	}
+}
+
+func TestCheckRotatedSecrets(t *testing.T) {
+	bundle, err := secrets.NewBundle(secrets.NewFixedClock(time.Now()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	configFile := filepath.Join(t.TempDir(), "node1.yaml")
+	writeTestFiles(t, filepath.Dir(configFile), map[string]string{
+		"node1.yaml": "machine:\n  type: worker\n  token: explicit.token1234567890\n" +
+			"cluster:\n  clusterName: test\n  controlPlane:\n    endpoint: https://10.0.0.1:6443\n",
+	})
+
+	configBundle, err := engine.FullConfigProcess(context.Background(), engine.Options{SecretsBundle: bundle}, []string{"@" + configFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = checkRotatedSecrets(configBundle.ControlPlaneCfg, bundle); err == nil {
+		t.Error("expected an error for a token set in the node file")
+	}
+
+	bundle.TrustdInfo.Token = "explicit.token1234567890"
+	if err = checkRotatedSecrets(configBundle.ControlPlaneCfg, bundle); err != nil {
+		t.Error(err)
+	}
+}
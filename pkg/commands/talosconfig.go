@@ -0,0 +1,306 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+
+	"github.com/aenix-io/talm/pkg/engine"
+	"github.com/aenix-io/talm/pkg/inventory"
+	"github.com/aenix-io/talm/pkg/modeline"
+	"github.com/spf13/cobra"
+
+	clientconfig "github.com/siderolabs/talos/pkg/machinery/client/config"
+)
+
+var talosconfigCmdFlags struct {
+	configFiles  []string // -f/--files
+	merge        bool
+	talosVersion string
+	withSecrets  string
+}
+
+var talosconfigCmd = &cobra.Command{
+	Use:   "talosconfig",
+	Short: "Manage the talosconfig of the project",
+	Long:  ``,
+}
+
+var talosconfigSyncCmd = &cobra.Command{
+	Use:   "sync",
+	Short: "Update talosconfig contexts from the node files",
+	Long: `Update talosconfig contexts from the node files, so commands run without --file
+reach the cluster.
+
+Endpoints of a context are set to the addresses of control plane nodes and its nodes
+to addresses of all nodes. Node files are grouped into contexts by the context from
+their modeline, files without it go to the current context of the talosconfig or to
+the one set with --context. New contexts get the credentials of the current one.
+
+Node files of all nodes from inventory.yaml are used unless files are specified with --file,
+without the inventory YAML files with a modeline from nodes/ are used.`,
+	Args: cobra.NoArgs,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if !cmd.Flags().Changed("talos-version") {
+			talosconfigCmdFlags.talosVersion = Config.TemplateOptions.TalosVersion
+		}
+		if !cmd.Flags().Changed("with-secrets") {
+			talosconfigCmdFlags.withSecrets = Config.TemplateOptions.WithSecrets
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return syncTalosconfig(context.Background())
+	},
+}
+
+// talosconfigMembers are nodes of a single talosconfig context.
+type talosconfigMembers struct {
+	endpoints []string
+	nodes     []string
+}
+
+func syncTalosconfig(ctx context.Context) error {
+	configFiles := talosconfigCmdFlags.configFiles
+	if len(configFiles) == 0 {
+		var err error
+		if configFiles, err = projectNodeFiles(); err != nil {
+			return err
+		}
+		if len(configFiles) == 0 {
+			return fmt.Errorf("no node files found in %s", filepath.Join(Config.RootDir, "nodes"))
+		}
+	}
+
+	talosconfig, err := clientconfig.Open(GlobalArgs.Talosconfig)
+	if err != nil {
+		return fmt.Errorf("failed to open config file %q: %w", GlobalArgs.Talosconfig, err)
+	}
+
+	defaultContext := talosconfig.Context
+	if GlobalArgs.CmdContext != "" {
+		defaultContext = GlobalArgs.CmdContext
+	}
+
+	members, err := talosconfigContexts(ctx, configFiles, defaultContext)
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(members))
+	for name := range members {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		talosContext, ok := talosconfig.Contexts[name]
+		if !ok {
+			// Contexts of other environments use the same credentials
+			current, ok := talosconfig.Contexts[talosconfig.Context]
+			if !ok {
+				return fmt.Errorf("context %q is not defined in %s", talosconfig.Context, GlobalArgs.Talosconfig)
+			}
+			copied := *current
+			talosContext = &copied
+			talosconfig.Contexts[name] = talosContext
+		}
+
+		talosContext.Endpoints = members[name].endpoints
+		talosContext.Nodes = members[name].nodes
+		fmt.Printf("- talm: context=%s, endpoints=%s, nodes=%s\n", name, talosContext.Endpoints, talosContext.Nodes)
+	}
+
+	if _, ok := talosconfig.Contexts[defaultContext]; ok {
+		talosconfig.Context = defaultContext
+	}
+
+	fmt.Printf("> Writing talosconfig to %q\n", GlobalArgs.Talosconfig)
+	if err = talosconfig.Save(GlobalArgs.Talosconfig); err != nil {
+		return err
+	}
+
+	if talosconfigCmdFlags.merge {
+		return mergeTalosconfig(talosconfig, names)
+	}
+
+	return nil
+}
+
+// projectNodeFiles returns node files of the project: files of all nodes from the inventory if it exists,
+// otherwise YAML files from nodes/ with a modeline. Other YAML files found there are skipped with a warning.
+func projectNodeFiles() ([]string, error) {
+	nodesDir := filepath.Join(Config.RootDir, "nodes")
+
+	inventoryFile := filepath.Join(Config.RootDir, inventory.FileName)
+	if _, err := os.Stat(inventoryFile); err == nil {
+		inv, err := inventory.Load(inventoryFile)
+		if err != nil {
+			return nil, err
+		}
+
+		var configFiles []string
+		for _, node := range inv.Nodes {
+			configFile := node.FilePath(Config.RootDir)
+			if _, err := os.Stat(configFile); err != nil {
+				fmt.Fprintf(os.Stderr, "WARNING: skipping node %s: %s, render it with 'talm template --all'\n", node.Name, err)
+				continue
+			}
+			configFiles = append(configFiles, configFile)
+		}
+		return configFiles, nil
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	found, err := filepath.Glob(filepath.Join(nodesDir, "*.yaml"))
+	if err != nil {
+		return nil, err
+	}
+
+	var configFiles []string
+	for _, configFile := range found {
+		if _, err := modeline.ReadAndParseModeline(configFile); err != nil {
+			fmt.Fprintf(os.Stderr, "WARNING: skipping %s, it's not a node file: %s\n", configFile, err)
+			continue
+		}
+		configFiles = append(configFiles, configFile)
+	}
+	return configFiles, nil
+}
+
+// talosconfigContexts groups nodes of the node files by contexts from their modelines.
+func talosconfigContexts(ctx context.Context, configFiles []string, defaultContext string) (map[string]*talosconfigMembers, error) {
+	opts := engine.Options{
+		TalosVersion:      talosconfigCmdFlags.talosVersion,
+		WithSecrets:       talosconfigCmdFlags.withSecrets,
+		KubernetesVersion: Config.TemplateOptions.KubernetesVersion,
+	}
+
+	members := map[string]*talosconfigMembers{}
+	for _, configFile := range configFiles {
+		modelineConfig, err := modeline.ReadAndParseModeline(configFile)
+		if err != nil {
+			return nil, fmt.Errorf("modeline parsing failed for %s: %w", configFile, err)
+		}
+
+		configBundle, err := engine.FullConfigProcess(ctx, opts, []string{"@" + configFile})
+		if err != nil {
+			return nil, fmt.Errorf("full config processing error: %s", err)
+		}
+
+		name := modelineConfig.Context
+		if name == "" {
+			name = defaultContext
+		}
+		if members[name] == nil {
+			members[name] = &talosconfigMembers{}
+		}
+
+		m := members[name]
+		for _, node := range modelineConfig.Nodes {
+			if !slices.Contains(m.nodes, node) {
+				m.nodes = append(m.nodes, node)
+			}
+			if configBundle.ControlPlaneCfg.Machine().Type().IsControlPlane() && !slices.Contains(m.endpoints, node) {
+				m.endpoints = append(m.endpoints, node)
+			}
+		}
+	}
+
+	for name, m := range members {
+		if len(m.endpoints) == 0 {
+			return nil, fmt.Errorf("context %s: node files of control plane nodes must be specified", name)
+		}
+		sort.Strings(m.endpoints)
+		sort.Strings(m.nodes)
+	}
+
+	return members, nil
+}
+
+// mergeTalosconfig copies the synced contexts into ~/.talos/config. Contexts of the same cluster are updated,
+// other contexts with the same names are kept and the synced ones are renamed, as 'talosctl config merge' does.
+// The current context is switched only if there is none.
+func mergeTalosconfig(talosconfig *clientconfig.Config, names []string) error {
+	talosDir, err := clientconfig.GetTalosDirectory()
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(talosDir, "config")
+
+	merged := &clientconfig.Config{}
+	if _, err = os.Stat(path); err == nil {
+		if merged, err = clientconfig.Open(path); err != nil {
+			return fmt.Errorf("failed to open config file %q: %w", path, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	if merged.Contexts == nil {
+		merged.Contexts = map[string]*clientconfig.Context{}
+	}
+
+	currentContext := talosconfig.Context
+	added := &clientconfig.Config{Contexts: map[string]*clientconfig.Context{}}
+	for _, name := range names {
+		synced := talosconfig.Contexts[name]
+		if existing := sameClusterContext(merged, name, synced); existing != "" {
+			merged.Contexts[existing] = synced
+			if name == currentContext {
+				currentContext = existing
+			}
+			continue
+		}
+		added.Contexts[name] = synced
+	}
+
+	for _, rename := range merged.Merge(added) {
+		fmt.Fprintf(os.Stderr, "renamed talosconfig context %s\n", rename.String())
+		if rename.From == currentContext {
+			currentContext = rename.To
+		}
+	}
+	if _, ok := merged.Contexts[merged.Context]; !ok && slices.Contains(names, talosconfig.Context) {
+		merged.Context = currentContext
+	}
+
+	fmt.Printf("> Merging contexts %s into %q\n", names, path)
+
+	return merged.Save(path)
+}
+
+// sameClusterContext returns the context merged from the named one before, i.e. having its name or the name
+// it was renamed to and the same CA, or an empty string.
+func sameClusterContext(merged *clientconfig.Config, name string, synced *clientconfig.Context) string {
+	for i := 0; ; i++ {
+		mergedName := name
+		if i > 0 {
+			mergedName = fmt.Sprintf("%s-%d", name, i)
+		}
+		existing, ok := merged.Contexts[mergedName]
+		if !ok {
+			return ""
+		}
+		if existing.CA == synced.CA {
+			return mergedName
+		}
+	}
+}
+
+func init() {
+	talosconfigSyncCmd.Flags().StringSliceVarP(&talosconfigCmdFlags.configFiles, "file", "f", nil, "specify node files (all files from nodes/ by default)")
+	talosconfigSyncCmd.Flags().BoolVarP(&talosconfigCmdFlags.merge, "merge", "m", false, "merge the synced contexts into ~/.talos/config")
+	talosconfigSyncCmd.Flags().StringVar(&talosconfigCmdFlags.talosVersion, "talos-version", "", "the desired Talos version to generate config for (backwards compatibility, e.g. v0.8)")
+	talosconfigSyncCmd.Flags().StringVar(&talosconfigCmdFlags.withSecrets, "with-secrets", "", "use a secrets file generated using 'gen secrets'")
+
+	talosconfigCmd.AddCommand(talosconfigSyncCmd)
+	addCommand(talosconfigCmd)
+}
@@ -0,0 +1,135 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	clientconfig "github.com/siderolabs/talos/pkg/machinery/client/config"
+)
+
+func writeTestFiles(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestProjectNodeFiles(t *testing.T) {
+	rootDir := Config.RootDir
+	t.Cleanup(func() { Config.RootDir = rootDir })
+	Config.RootDir = t.TempDir()
+
+	writeTestFiles(t, Config.RootDir, map[string]string{
+		"nodes/node1.yaml":      "# talm: nodes=[\"10.0.0.1\"], endpoints=[\"10.0.0.1\"], templates=[\"templates/controlplane.yaml\"]\nmachine:\n  type: controlplane\n",
+		"nodes/node2.yaml":      "# talm: nodes=[\"10.0.0.2\"], endpoints=[\"10.0.0.1\"], templates=[\"templates/worker.yaml\"]\nmachine:\n  type: worker\n",
+		"nodes/node1.lock.yaml": "lookups: []\n",
+		"nodes/extra.yaml":      "machine:\n  type: worker\n",
+	})
+
+	files, err := projectNodeFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{filepath.Join(Config.RootDir, "nodes", "node1.yaml"), filepath.Join(Config.RootDir, "nodes", "node2.yaml")}
+	if !reflect.DeepEqual(files, expected) {
+		t.Errorf("expected %v, got %v", expected, files)
+	}
+
+	// The inventory lists node files explicitly, missing files are skipped
+	writeTestFiles(t, Config.RootDir, map[string]string{
+		"inventory.yaml": "nodes:\n  - name: node2\n    address: 10.0.0.2\n    role: worker\n  - name: node3\n    address: 10.0.0.3\n    role: worker\n",
+	})
+
+	files, err = projectNodeFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = []string{filepath.Join(Config.RootDir, "nodes", "node2.yaml")}
+	if !reflect.DeepEqual(files, expected) {
+		t.Errorf("expected %v from the inventory, got %v", expected, files)
+	}
+}
+
+func TestMergeTalosconfig(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	talosDir, err := clientconfig.GetTalosDirectory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(talosDir, "config")
+
+	synced := &clientconfig.Config{
+		Context: "prod",
+		Contexts: map[string]*clientconfig.Context{
+			"prod": {CA: "prod-ca", Endpoints: []string{"10.0.0.1"}},
+			"dev":  {CA: "dev-ca", Endpoints: []string{"10.0.1.1"}},
+		},
+	}
+
+	// Without the config the synced contexts are copied as is
+	if err = mergeTalosconfig(synced, []string{"dev", "prod"}); err != nil {
+		t.Fatal(err)
+	}
+	merged, err := clientconfig.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if merged.Context != "prod" || len(merged.Contexts) != 2 {
+		t.Errorf("unexpected merged config, current %q, contexts %v", merged.Context, merged.Contexts)
+	}
+
+	// Contexts of the same cluster are updated, contexts of other clusters are kept
+	merged.Context = "dev"
+	merged.Contexts["dev"] = &clientconfig.Context{CA: "other-ca", Endpoints: []string{"192.168.0.1"}}
+	if err = merged.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	synced.Contexts["prod"].Endpoints = []string{"10.0.0.2"}
+
+	if err = mergeTalosconfig(synced, []string{"dev", "prod"}); err != nil {
+		t.Fatal(err)
+	}
+	if merged, err = clientconfig.Open(path); err != nil {
+		t.Fatal(err)
+	}
+	if merged.Context != "dev" {
+		t.Errorf("current context switched to %q", merged.Context)
+	}
+	if got := merged.Contexts["prod"].Endpoints; !reflect.DeepEqual(got, []string{"10.0.0.2"}) {
+		t.Errorf("context of the same cluster wasn't updated, endpoints %v", got)
+	}
+	if got := merged.Contexts["dev"].CA; got != "other-ca" {
+		t.Errorf("context of another cluster was replaced, CA %q", got)
+	}
+	if renamed, ok := merged.Contexts["dev-1"]; !ok || renamed.CA != "dev-ca" {
+		t.Errorf("expected the synced context to be renamed to dev-1, got %v", merged.Contexts)
+	}
+
+	// The renamed context is updated by further merges
+	synced.Contexts["dev"].Endpoints = []string{"10.0.1.2"}
+	if err = mergeTalosconfig(synced, []string{"dev", "prod"}); err != nil {
+		t.Fatal(err)
+	}
+	if merged, err = clientconfig.Open(path); err != nil {
+		t.Fatal(err)
+	}
+	if len(merged.Contexts) != 3 {
+		t.Errorf("expected contexts dev, dev-1 and prod, got %v", merged.Contexts)
+	}
+	if got := merged.Contexts["dev-1"].Endpoints; !reflect.DeepEqual(got, []string{"10.0.1.2"}) {
+		t.Errorf("renamed context wasn't updated, endpoints %v", got)
+	}
+}
@@ -5,40 +5,60 @@
 package commands
 
 import (
+	"bytes"
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 
 	"github.com/aenix-io/talm/pkg/engine"
+	"github.com/aenix-io/talm/pkg/inventory"
 	"github.com/aenix-io/talm/pkg/modeline"
+	"github.com/aenix-io/talm/pkg/yamltools"
 	"github.com/spf13/cobra"
 
 	"github.com/siderolabs/talos/pkg/machinery/client"
+	"github.com/siderolabs/talos/pkg/machinery/config/generate/secrets"
 	"github.com/siderolabs/talos/pkg/machinery/constants"
 )
 
 var templateCmdFlags struct {
-	insecure          bool
-	configFiles       []string // -f/--files
-	valueFiles        []string // --values
-	templateFiles     []string // -t/--template
-	stringValues      []string // --set-string
-	values            []string // --set
-	fileValues        []string // --set-file
-	jsonValues        []string // --set-json
-	literalValues     []string // --set-literal
-	talosVersion      string
-	withSecrets       string
-	full              bool
-	debug             bool
-	offline           bool
-	kubernetesVersion string
-	inplace           bool
-	nodesFromArgs     bool
-	endpointsFromArgs bool
-	templatesFromArgs bool
+	insecure             bool
+	configFiles          []string // -f/--files
+	valueFiles           []string // --values
+	templateFiles        []string // -t/--template
+	stringValues         []string // --set-string
+	values               []string // --set
+	fileValues           []string // --set-file
+	jsonValues           []string // --set-json
+	literalValues        []string // --set-literal
+	talosVersion         string
+	withSecrets          string
+	full                 bool
+	patchFormat          string
+	debug                bool
+	offline              bool
+	strict               bool
+	facts                string
+	lock                 bool
+	locked               bool
+	kubernetesVersion    string
+	inplace              bool
+	nodesFromArgs        bool
+	endpointsFromArgs    bool
+	templatesFromArgs    bool
+	talosVersionFromArgs bool
+	all                  bool
+	inventory            string
+	selector             string
+	force                bool
+	explainLookups       bool
+	k8sLookup            bool
+	kubeconfig           string
+	secretsBundle        *secrets.Bundle // overrides withSecrets, set by secrets rotate
 }
 
 var templateCmd = &cobra.Command{
@@ -53,7 +73,8 @@ var templateCmd = &cobra.Command{
 		templateCmdFlags.fileValues = append(Config.TemplateOptions.FileValues, templateCmdFlags.fileValues...)
 		templateCmdFlags.jsonValues = append(Config.TemplateOptions.JsonValues, templateCmdFlags.jsonValues...)
 		templateCmdFlags.literalValues = append(Config.TemplateOptions.LiteralValues, templateCmdFlags.literalValues...)
-		if !cmd.Flags().Changed("talos-version") {
+		templateCmdFlags.talosVersionFromArgs = cmd.Flags().Changed("talos-version")
+		if !templateCmdFlags.talosVersionFromArgs {
 			templateCmdFlags.talosVersion = Config.TemplateOptions.TalosVersion
 		}
 		if !cmd.Flags().Changed("with-secrets") {
@@ -65,12 +86,18 @@ var templateCmd = &cobra.Command{
 		if !cmd.Flags().Changed("full") {
 			templateCmdFlags.full = Config.TemplateOptions.Full
 		}
+		if !cmd.Flags().Changed("patch-format") && Config.TemplateOptions.PatchFormat != "" {
+			templateCmdFlags.patchFormat = Config.TemplateOptions.PatchFormat
+		}
 		if !cmd.Flags().Changed("debug") {
 			templateCmdFlags.debug = Config.TemplateOptions.Debug
 		}
 		if !cmd.Flags().Changed("offline") {
 			templateCmdFlags.offline = Config.TemplateOptions.Offline
 		}
+		if !cmd.Flags().Changed("strict") {
+			templateCmdFlags.strict = Config.TemplateOptions.Strict
+		}
 		templateCmdFlags.templatesFromArgs = len(templateCmdFlags.templateFiles) > 0
 		templateCmdFlags.nodesFromArgs = len(GlobalArgs.Nodes) > 0
 		templateCmdFlags.endpointsFromArgs = len(GlobalArgs.Endpoints) > 0
@@ -82,6 +109,22 @@ var templateCmd = &cobra.Command{
 		return nil
 	},
 	RunE: func(cmd *cobra.Command, args []string) error {
+		if !slices.Contains(engine.PatchFormats, templateCmdFlags.patchFormat) {
+			return fmt.Errorf("invalid patch format %q, valid values are: %s", templateCmdFlags.patchFormat, strings.Join(engine.PatchFormats, ", "))
+		}
+		if templateCmdFlags.full && templateCmdFlags.patchFormat != engine.PatchFormatStrategic {
+			return fmt.Errorf("--patch-format can't be used with --full")
+		}
+		if templateCmdFlags.all && len(templateCmdFlags.configFiles) > 0 {
+			return fmt.Errorf("--all and --file are mutually exclusive")
+		}
+		if (templateCmdFlags.lock || templateCmdFlags.locked) && len(templateCmdFlags.configFiles) == 0 && !templateCmdFlags.all {
+			return fmt.Errorf("--lock and --locked require --file or --all")
+		}
+		if templateCmdFlags.lock && templateCmdFlags.locked {
+			return fmt.Errorf("--lock and --locked are mutually exclusive")
+		}
+
 		templateFunc := template
 		if len(templateCmdFlags.configFiles) > 0 {
 			templateFunc = templateWithFiles
@@ -89,8 +132,11 @@ var templateCmd = &cobra.Command{
 				return fmt.Errorf("cannot use --in-place without --file")
 			}
 		}
+		if templateCmdFlags.all {
+			templateFunc = templateInventory
+		}
 
-		if templateCmdFlags.offline {
+		if templateCmdFlags.offline || templateCmdFlags.facts != "" {
 			return templateFunc(args)(context.Background(), nil)
 		}
 		if templateCmdFlags.insecure {
@@ -103,7 +149,7 @@ var templateCmd = &cobra.Command{
 
 func template(args []string) func(ctx context.Context, c *client.Client) error {
 	return func(ctx context.Context, c *client.Client) error {
-		output, err := generateOutput(ctx, c, args)
+		output, err := generateOutput(ctx, c, args, "", nil)
 		if err != nil {
 			return err
 		}
@@ -115,83 +161,245 @@ func template(args []string) func(ctx context.Context, c *client.Client) error {
 
 func templateWithFiles(args []string) func(ctx context.Context, c *client.Client) error {
 	return func(ctx context.Context, c *client.Client) error {
-		firstFileProcessed := false
-		for _, configFile := range templateCmdFlags.configFiles {
+		for i, configFile := range templateCmdFlags.configFiles {
 			modelineConfig, err := modeline.ReadAndParseModeline(configFile)
 			if err != nil {
 				return fmt.Errorf("modeline parsing failed: %v\n", err)
 			}
-			if !templateCmdFlags.templatesFromArgs {
-				if len(modelineConfig.Templates) == 0 {
-					return fmt.Errorf("modeline does not contain templates information")
-				} else {
-					templateCmdFlags.templateFiles = modelineConfig.Templates
-				}
-			}
-			if !templateCmdFlags.nodesFromArgs {
-				GlobalArgs.Nodes = modelineConfig.Nodes
-			}
-			if !templateCmdFlags.endpointsFromArgs {
-				GlobalArgs.Endpoints = modelineConfig.Endpoints
+			if err = templateNodeFile(args, configFile, modelineConfig, i == 0); err != nil {
+				return err
 			}
-			fmt.Printf("- talm: file=%s, nodes=%s, endpoints=%s, templates=%s\n", configFile, GlobalArgs.Nodes, GlobalArgs.Endpoints, templateCmdFlags.templateFiles)
+		}
+		return nil
+	}
+}
 
-			if len(GlobalArgs.Nodes) < 1 {
-				return errors.New("nodes are not set for the command: please use `--nodes` flag or configuration file to set the nodes to run the command against")
-			}
-			if len(templateCmdFlags.configFiles) != 0 && len(templateCmdFlags.templateFiles) < 1 {
-				return errors.New("templates are not set for the command: please use `--template` flag to set the templates to render manifest from")
-			}
+// templateInventory renders every node selected from the inventory into its node file.
+// Missing files are created, modelines of existing files are updated from the inventory.
+func templateInventory(args []string) func(ctx context.Context, c *client.Client) error {
+	return func(ctx context.Context, c *client.Client) error {
+		inventoryFile := templateCmdFlags.inventory
+		if inventoryFile == "" {
+			inventoryFile = filepath.Join(Config.RootDir, inventory.FileName)
+		}
+		inv, err := inventory.Load(inventoryFile)
+		if err != nil {
+			return err
+		}
+
+		selector, err := inventory.ParseSelector(templateCmdFlags.selector)
+		if err != nil {
+			return err
+		}
+		nodes := inv.Select(selector)
+		if len(nodes) == 0 {
+			return fmt.Errorf("no nodes selected from inventory %s", inventoryFile)
+		}
 
-			template := func(args []string) func(ctx context.Context, c *client.Client) error {
-				return func(ctx context.Context, c *client.Client) error {
-					output, err := generateOutput(ctx, c, args)
-					if err != nil {
-						return err
-					}
-
-					if templateCmdFlags.inplace {
-						err = os.WriteFile(configFile, []byte(output), 0o644)
-						fmt.Fprintf(os.Stderr, "Updated.\n")
-					} else {
-						if firstFileProcessed {
-							fmt.Println("---")
-						}
-						fmt.Printf("%s", output)
-					}
-
-					return nil
+		for i, node := range nodes {
+			configFile := node.FilePath(Config.RootDir)
+
+			var existing *modeline.Config
+			if _, err = os.Stat(configFile); err == nil {
+				if existing, err = modeline.ReadAndParseModeline(configFile); err != nil {
+					return fmt.Errorf("modeline parsing failed for %s: %w", configFile, err)
 				}
+			} else if !os.IsNotExist(err) {
+				return err
 			}
 
-			if templateCmdFlags.offline {
-				err = template(args)(context.Background(), nil)
-			} else if templateCmdFlags.insecure {
-				err = WithClientMaintenance(nil, template(args))
-			} else {
-				err = WithClient(template(args))
+			if err = templateNodeFile(args, configFile, inv.Modeline(node, existing), i == 0); err != nil {
+				return fmt.Errorf("node %s: %w", node.Name, err)
 			}
+		}
+		return nil
+	}
+}
+
+// templateNodeFile renders templates for the node described by modelineConfig and
+// writes the result to configFile with --in-place or prints it otherwise.
+func templateNodeFile(args []string, configFile string, modelineConfig *modeline.Config, first bool) error {
+	cmdContext := GlobalArgs.CmdContext
+	defer func() {
+		// Reset args
+		if !templateCmdFlags.templatesFromArgs {
+			templateCmdFlags.templateFiles = []string{}
+		}
+		if !templateCmdFlags.nodesFromArgs {
+			GlobalArgs.Nodes = []string{}
+		}
+		if !templateCmdFlags.endpointsFromArgs {
+			GlobalArgs.Endpoints = []string{}
+		}
+		GlobalArgs.CmdContext = cmdContext
+	}()
+
+	if !templateCmdFlags.templatesFromArgs {
+		if len(modelineConfig.Templates) == 0 {
+			return fmt.Errorf("modeline does not contain templates information")
+		} else {
+			templateCmdFlags.templateFiles = modelineConfig.Templates
+		}
+	}
+	if !templateCmdFlags.nodesFromArgs {
+		GlobalArgs.Nodes = modelineConfig.Nodes
+	}
+	if !templateCmdFlags.endpointsFromArgs {
+		GlobalArgs.Endpoints = modelineConfig.Endpoints
+	}
+	if cmdContext == "" {
+		GlobalArgs.CmdContext = modelineConfig.Context
+	}
+	fmt.Printf("- talm: file=%s, nodes=%s, endpoints=%s, templates=%s\n", configFile, GlobalArgs.Nodes, GlobalArgs.Endpoints, templateCmdFlags.templateFiles)
+
+	if len(GlobalArgs.Nodes) < 1 {
+		return errors.New("nodes are not set for the command: please use `--nodes` flag or configuration file to set the nodes to run the command against")
+	}
+	if len(templateCmdFlags.templateFiles) < 1 {
+		return errors.New("templates are not set for the command: please use `--template` flag to set the templates to render manifest from")
+	}
+
+	template := func(args []string) func(ctx context.Context, c *client.Client) error {
+		return func(ctx context.Context, c *client.Client) error {
+			output, err := generateOutput(ctx, c, args, engine.LockFilePath(Config.RootDir, configFile), modelineConfig)
 			if err != nil {
 				return err
 			}
 
-			// Reset args
-			firstFileProcessed = true
-			if !templateCmdFlags.templatesFromArgs {
-				templateCmdFlags.templateFiles = []string{}
+			if templateCmdFlags.inplace {
+				if err = writeNodeFile(configFile, output); err != nil {
+					return err
+				}
+			} else {
+				if !first {
+					fmt.Println("---")
+				}
+				fmt.Printf("%s", output)
 			}
-			if !templateCmdFlags.nodesFromArgs {
-				GlobalArgs.Nodes = []string{}
+
+			return nil
+		}
+	}
+
+	if templateCmdFlags.offline || templateCmdFlags.facts != "" {
+		return template(args)(context.Background(), nil)
+	} else if templateCmdFlags.insecure {
+		return WithClientMaintenance(nil, template(args))
+	}
+	return WithClient(template(args))
+}
+
+// renderedDir keeps the last rendered output of every node file, used as a base to merge manual changes.
+var renderedDir = filepath.Join(".talm", "rendered")
+
+// renderedBasePath returns path of the last rendered output of the node file, e.g. .talm/rendered/nodes/node1.yaml.
+func renderedBasePath(configFile string) string {
+	rel := configFile
+	if abs, err := filepath.Abs(configFile); err == nil {
+		if r, err := filepath.Rel(Config.RootDir, abs); err == nil && !strings.HasPrefix(r, "..") {
+			rel = r
+		}
+	}
+	if filepath.IsAbs(rel) || strings.HasPrefix(rel, "..") {
+		rel = filepath.Base(rel)
+	}
+	return filepath.Join(Config.RootDir, renderedDir, rel)
+}
+
+// writeNodeFile writes rendered output into the node file.
+//
+// Changes made to the file by hand since the last rendering are merged into the new output,
+// the file is left untouched if they conflict with changes of the rendered output. Files rendered
+// before, without the last rendered output, are only overwritten if they match the new output or with --force.
+func writeNodeFile(configFile, output string) error {
+	basePath := renderedBasePath(configFile)
+	result := []byte(output)
+
+	current, err := os.ReadFile(configFile)
+	created := os.IsNotExist(err)
+	if err != nil && !created {
+		return err
+	}
+
+	if !created && !templateCmdFlags.force {
+		base, err := os.ReadFile(basePath)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if os.IsNotExist(err) {
+			// Without the last rendered output manual changes can't be told apart from changes of templates
+			_, rendered := splitHeader(output)
+			_, currentBody := splitHeader(string(current))
+
+			changes, err := yamltools.Changes([]byte(currentBody), []byte(rendered))
+			if err != nil {
+				return fmt.Errorf("failed to compare %s with rendered templates: %w", configFile, err)
 			}
-			if !templateCmdFlags.endpointsFromArgs {
-				GlobalArgs.Endpoints = []string{}
+			if len(changes) > 0 {
+				fmt.Fprintf(os.Stderr, "--- %s\n+++ %s (rendered)\n", configFile, configFile)
+				if err := printChanges(os.Stderr, changes); err != nil {
+					return err
+				}
+				return fmt.Errorf("%s differs from rendered templates and there is no previous rendering in %s to merge manual changes with: review the changes and use --force to overwrite it", configFile, renderedDir)
 			}
 		}
-		return nil
+		if err == nil && !bytes.Equal(base, current) {
+			header, rendered := splitHeader(output)
+			_, baseBody := splitHeader(string(base))
+			_, currentBody := splitHeader(string(current))
+
+			merged, conflicts, err := yamltools.Merge3([]byte(baseBody), []byte(currentBody), []byte(rendered))
+			if err != nil {
+				return fmt.Errorf("failed to merge manual changes of %s: %w", configFile, err)
+			}
+			if len(conflicts) > 0 {
+				for _, conflict := range conflicts {
+					fmt.Fprintf(os.Stderr, "CONFLICT %s: file has %v, templates render %v\n", conflict.Path, conflict.Current, conflict.Rendered)
+				}
+				return fmt.Errorf("manual changes of %s conflict with rendered templates: resolve them in the file or use --force to overwrite it", configFile)
+			}
+			result = append([]byte(header), merged...)
+			fmt.Fprintf(os.Stderr, "Manual changes merged.\n")
+		}
 	}
+
+	for _, path := range []string{configFile, basePath} {
+		if err = os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return err
+		}
+	}
+	if err = os.WriteFile(configFile, result, 0o644); err != nil {
+		return err
+	}
+	if err = os.WriteFile(basePath, []byte(output), 0o644); err != nil {
+		return err
+	}
+
+	if created {
+		fmt.Fprintf(os.Stderr, "Created.\n")
+	} else {
+		fmt.Fprintf(os.Stderr, "Updated.\n")
+	}
+	return nil
+}
+
+// splitHeader splits the modeline and other leading comments from the node file body.
+func splitHeader(content string) (string, string) {
+	var header strings.Builder
+	for content != "" {
+		line, rest, _ := strings.Cut(content, "\n")
+		if trimmed := strings.TrimSpace(line); trimmed != "" && !strings.HasPrefix(trimmed, "#") {
+			break
+		}
+		header.WriteString(line + "\n")
+		content = rest
+	}
+	return header.String(), content
 }
 
-func generateOutput(ctx context.Context, c *client.Client, args []string) (string, error) {
+// generateOutput renders templates and prepends the modeline to the result.
+// Per-node overrides from fileModeline are passed to the engine and kept in the generated modeline.
+func generateOutput(ctx context.Context, c *client.Client, args []string, lockFile string, fileModeline *modeline.Config) (string, error) {
 	opts := engine.Options{
 		Insecure:          templateCmdFlags.insecure,
 		ValueFiles:        templateCmdFlags.valueFiles,
@@ -202,71 +410,89 @@ func generateOutput(ctx context.Context, c *client.Client, args []string) (strin
 		LiteralValues:     templateCmdFlags.literalValues,
 		TalosVersion:      templateCmdFlags.talosVersion,
 		WithSecrets:       templateCmdFlags.withSecrets,
+		SecretsBundle:     templateCmdFlags.secretsBundle,
 		Full:              templateCmdFlags.full,
+		PatchFormat:       templateCmdFlags.patchFormat,
 		Debug:             templateCmdFlags.debug,
 		Root:              Config.RootDir,
 		Offline:           templateCmdFlags.offline,
+		Facts:             templateCmdFlags.facts,
+		Strict:            templateCmdFlags.strict,
 		KubernetesVersion: templateCmdFlags.kubernetesVersion,
 		TemplateFiles:     templateCmdFlags.templateFiles,
+		K8sLookup:         templateCmdFlags.k8sLookup,
+		Kubeconfig:        templateCmdFlags.kubeconfig,
+	}
+	if templateCmdFlags.explainLookups {
+		opts.ExplainLookups = os.Stderr
 	}
+	if templateCmdFlags.lock || templateCmdFlags.locked {
+		opts.LockFile = lockFile
+		opts.Locked = templateCmdFlags.locked
+	}
+
+	out := modeline.Config{}
+	if fileModeline != nil {
+		out = *fileModeline
+		opts.NodeValueFiles = fileModeline.ValueFiles
+		opts.NodeValues = fileModeline.Values
+		if !templateCmdFlags.talosVersionFromArgs && fileModeline.TalosVersion != "" {
+			opts.TalosVersion = fileModeline.TalosVersion
+		}
+	}
+	out.Nodes = GlobalArgs.Nodes
+	out.Endpoints = GlobalArgs.Endpoints
+	out.Templates = templateCmdFlags.templateFiles
 
 	result, err := engine.Render(ctx, c, opts)
 	if err != nil {
 		return "", fmt.Errorf("failed to render templates: %w", err)
 	}
 
-	modeline, err := modeline.GenerateModeline(GlobalArgs.Nodes, GlobalArgs.Endpoints, templateCmdFlags.templateFiles)
+	modeline, err := modeline.GenerateModeline(&out)
 	if err != nil {
 		return "", fmt.Errorf("failed to generate modeline: %w", err)
 	}
-	warn := "# THIS FILE IS AUTOGENERATED. DO NOT EDIT IT!"
+	warn := "# THIS FILE IS AUTOGENERATED. MANUAL CHANGES ARE MERGED ON RE-TEMPLATING WITH -I."
 
 	output := fmt.Sprintf("%s\n%s\n%s\n", modeline, warn, string(result))
 	return output, nil
 }
 
 func init() {
-	templateCmd.Flags().BoolVarP(&templateCmdFlags.insecure, "insecure", "i", false, "template using the insecure (encrypted with no auth) maintenance service")
 	templateCmd.Flags().StringSliceVarP(&templateCmdFlags.configFiles, "file", "f", nil, "specify config files for in-place update (can specify multiple)")
 	templateCmd.Flags().BoolVarP(&templateCmdFlags.inplace, "in-place", "I", false, "re-template and update generated files in place (overwrite them)")
-	templateCmd.Flags().StringSliceVarP(&templateCmdFlags.valueFiles, "values", "", []string{}, "specify values in a YAML file (can specify multiple)")
-	templateCmd.Flags().StringSliceVarP(&templateCmdFlags.templateFiles, "template", "t", []string{}, "specify templates to render manifest from (can specify multiple)")
-	templateCmd.Flags().StringArrayVar(&templateCmdFlags.values, "set", []string{}, "set values on the command line (can specify multiple or separate values with commas: key1=val1,key2=val2)")
-	templateCmd.Flags().StringArrayVar(&templateCmdFlags.stringValues, "set-string", []string{}, "set STRING values on the command line (can specify multiple or separate values with commas: key1=val1,key2=val2)")
-	templateCmd.Flags().StringArrayVar(&templateCmdFlags.fileValues, "set-file", []string{}, "set values from respective files specified via the command line (can specify multiple or separate values with commas: key1=path1,key2=path2)")
-	templateCmd.Flags().StringArrayVar(&templateCmdFlags.jsonValues, "set-json", []string{}, "set JSON values on the command line (can specify multiple or separate values with commas: key1=jsonval1,key2=jsonval2)")
-	templateCmd.Flags().StringArrayVar(&templateCmdFlags.literalValues, "set-literal", []string{}, "set a literal STRING value on the command line")
-	templateCmd.Flags().StringVar(&templateCmdFlags.talosVersion, "talos-version", "", "the desired Talos version to generate config for (backwards compatibility, e.g. v0.8)")
-	templateCmd.Flags().StringVar(&templateCmdFlags.withSecrets, "with-secrets", "", "use a secrets file generated using 'gen secrets'")
-	templateCmd.Flags().BoolVarP(&templateCmdFlags.full, "full", "", false, "show full resulting config, not only patch")
-	templateCmd.Flags().BoolVarP(&templateCmdFlags.debug, "debug", "", false, "show only rendered patches")
-	templateCmd.Flags().BoolVarP(&templateCmdFlags.offline, "offline", "", false, "disable gathering information and lookup functions")
-	templateCmd.Flags().StringVar(&templateCmdFlags.kubernetesVersion, "kubernetes-version", constants.DefaultKubernetesVersion, "desired kubernetes version to run")
+	templateCmd.Flags().BoolVar(&templateCmdFlags.all, "all", false, "render every node from the inventory file into its node file under nodes/")
+	addTemplateFlags(templateCmd)
 
 	addCommand(templateCmd)
 }
 
-// generateModeline creates a modeline string using JSON formatting for values
-func generateModeline(templates []string) (string, error) {
-	// Convert Nodes to JSON
-	nodesJSON, err := json.Marshal(GlobalArgs.Nodes)
-	if err != nil {
-		return "", fmt.Errorf("failed to marshal nodes: %v", err)
-	}
-
-	// Convert Endpoints to JSON
-	endpointsJSON, err := json.Marshal(GlobalArgs.Endpoints)
-	if err != nil {
-		return "", fmt.Errorf("failed to marshal endpoints: %v", err)
-	}
-
-	// Convert Templates to JSON
-	templatesJSON, err := json.Marshal(templates)
-	if err != nil {
-		return "", fmt.Errorf("failed to marshal templates: %v", err)
-	}
-
-	// Form the final modeline string
-	modeline := fmt.Sprintf(`# talm: nodes=%s, endpoints=%s, templates=%s`, string(nodesJSON), string(endpointsJSON), string(templatesJSON))
-	return modeline, nil
+// addTemplateFlags defines rendering flags shared by template and generate commands.
+func addTemplateFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVarP(&templateCmdFlags.insecure, "insecure", "i", false, "template using the insecure (encrypted with no auth) maintenance service")
+	cmd.Flags().StringSliceVarP(&templateCmdFlags.valueFiles, "values", "", []string{}, "specify values in a YAML file (can specify multiple)")
+	cmd.Flags().StringSliceVarP(&templateCmdFlags.templateFiles, "template", "t", []string{}, "specify templates to render manifest from (can specify multiple)")
+	cmd.Flags().StringArrayVar(&templateCmdFlags.values, "set", []string{}, "set values on the command line (can specify multiple or separate values with commas: key1=val1,key2=val2)")
+	cmd.Flags().StringArrayVar(&templateCmdFlags.stringValues, "set-string", []string{}, "set STRING values on the command line (can specify multiple or separate values with commas: key1=val1,key2=val2)")
+	cmd.Flags().StringArrayVar(&templateCmdFlags.fileValues, "set-file", []string{}, "set values from respective files specified via the command line (can specify multiple or separate values with commas: key1=path1,key2=path2)")
+	cmd.Flags().StringArrayVar(&templateCmdFlags.jsonValues, "set-json", []string{}, "set JSON values on the command line (can specify multiple or separate values with commas: key1=jsonval1,key2=jsonval2)")
+	cmd.Flags().StringArrayVar(&templateCmdFlags.literalValues, "set-literal", []string{}, "set a literal STRING value on the command line")
+	cmd.Flags().StringVar(&templateCmdFlags.talosVersion, "talos-version", "", "the desired Talos version to generate config for (backwards compatibility, e.g. v0.8)")
+	cmd.Flags().StringVar(&templateCmdFlags.withSecrets, "with-secrets", "", "use a secrets file generated using 'gen secrets'")
+	cmd.Flags().BoolVarP(&templateCmdFlags.full, "full", "", false, "show full resulting config, not only patch")
+	cmd.Flags().StringVar(&templateCmdFlags.patchFormat, "patch-format", engine.PatchFormatStrategic, fmt.Sprintf("format of the rendered patch (valid values are %s)", strings.Join(engine.PatchFormats, ", ")))
+	cmd.Flags().BoolVarP(&templateCmdFlags.debug, "debug", "", false, "show only rendered patches")
+	cmd.Flags().BoolVarP(&templateCmdFlags.offline, "offline", "", false, "disable gathering information and lookup functions")
+	cmd.Flags().BoolVar(&templateCmdFlags.strict, "strict", false, "fail on references to undefined values and lookups which found nothing")
+	cmd.Flags().StringVar(&templateCmdFlags.facts, "facts", "", "serve lookup functions from a facts snapshot collected by 'talm facts collect' instead of the live node")
+	cmd.Flags().BoolVar(&templateCmdFlags.lock, "lock", false, "record lookups performed during rendering into the lock file next to every node file")
+	cmd.Flags().BoolVar(&templateCmdFlags.locked, "locked", false, "serve lookups from the lock file and fail if the node doesn't match it anymore")
+	cmd.Flags().StringVar(&templateCmdFlags.kubernetesVersion, "kubernetes-version", constants.DefaultKubernetesVersion, "desired kubernetes version to run")
+	cmd.Flags().StringVar(&templateCmdFlags.inventory, "inventory", "", "path to the inventory file used with --all (default \"<root>/inventory.yaml\")")
+	cmd.Flags().StringVarP(&templateCmdFlags.selector, "selector", "l", "", "render only inventory nodes matching labels (e.g. role=worker,zone=a)")
+	cmd.Flags().BoolVar(&templateCmdFlags.explainLookups, "explain-lookups", false, "print every lookup performed during rendering with its latency, cache usage and errors to stderr")
+	cmd.Flags().BoolVar(&templateCmdFlags.k8sLookup, "k8s-lookup", false, "enable the k8sLookup function querying the Kubernetes API of the cluster")
+	cmd.Flags().StringVar(&templateCmdFlags.kubeconfig, "kubeconfig", "", "kubeconfig used by k8sLookup (fetched from the node by default)")
+	cmd.Flags().BoolVar(&templateCmdFlags.force, "force", false, "overwrite node files in place discarding manual changes instead of merging them")
 }
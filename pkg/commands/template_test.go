@@ -0,0 +1,35 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aenix-io/talm/pkg/engine"
+	"github.com/aenix-io/talm/pkg/generated"
+)
+
+func TestRenderPresetsOfflineStrict(t *testing.T) {
+	for _, preset := range generated.AvailablePresets {
+		for _, templateFile := range []string{"templates/controlplane.yaml", "templates/worker.yaml"} {
+			t.Run(preset+"/"+templateFile, func(t *testing.T) {
+				root := writePresetProject(t, preset)
+
+				_, err := engine.Render(context.Background(), nil, engine.Options{
+					Root:              root,
+					Offline:           true,
+					Strict:            true,
+					TalosVersion:      "v1.9",
+					KubernetesVersion: "1.32.0",
+					TemplateFiles:     []string{templateFile},
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+			})
+		}
+	}
+}
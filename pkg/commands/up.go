@@ -0,0 +1,322 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aenix-io/talm/pkg/engine"
+	"github.com/siderolabs/go-retry/retry"
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc/codes"
+
+	"github.com/siderolabs/talos/cmd/talosctl/pkg/talos/global"
+	machineapi "github.com/siderolabs/talos/pkg/machinery/api/machine"
+	"github.com/siderolabs/talos/pkg/machinery/client"
+	"github.com/siderolabs/talos/pkg/machinery/config/machine"
+	"github.com/siderolabs/talos/pkg/machinery/constants"
+)
+
+var upCmdFlags struct {
+	configFiles       []string // -f/--files
+	certFingerprints  []string
+	talosVersion      string
+	withSecrets       string
+	kubernetesVersion string
+	parallel          int
+	bootstrapTimeout  time.Duration
+	healthTimeout     time.Duration
+	kubeconfig        string
+	nodesFromArgs     bool
+	endpointsFromArgs bool
+}
+
+var upCmd = &cobra.Command{
+	Use:   "up",
+	Short: "Bring up a new cluster from node files",
+	Long: `Bring up a new cluster from node files in one go:
+
+  1. apply configs to the nodes which are still in maintenance mode, in parallel;
+  2. bootstrap etcd on the first control plane node from the files;
+  3. wait for the cluster to become healthy;
+  4. write the kubeconfig.
+
+Every step is safe to repeat: nodes which already accept the talosconfig credentials
+are not re-applied and bootstrap is skipped if etcd is already bootstrapped, so an
+interrupted run can simply be restarted.`,
+	Example: `  talm up -f nodes/*.yaml`,
+	Args:    cobra.NoArgs,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if !cmd.Flags().Changed("talos-version") {
+			upCmdFlags.talosVersion = Config.TemplateOptions.TalosVersion
+		}
+		if !cmd.Flags().Changed("with-secrets") {
+			upCmdFlags.withSecrets = Config.TemplateOptions.WithSecrets
+		}
+		if !cmd.Flags().Changed("kubernetes-version") {
+			upCmdFlags.kubernetesVersion = Config.TemplateOptions.KubernetesVersion
+		}
+		if !cmd.Flags().Changed("kubeconfig") {
+			upCmdFlags.kubeconfig = filepath.Join(Config.RootDir, "kubeconfig")
+		}
+		if upCmdFlags.parallel < 1 {
+			return fmt.Errorf("--parallel must be at least 1")
+		}
+		upCmdFlags.nodesFromArgs = len(GlobalArgs.Nodes) > 0
+		upCmdFlags.endpointsFromArgs = len(GlobalArgs.Endpoints) > 0
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(upCmdFlags.configFiles) == 0 {
+			return fmt.Errorf("node files must be specified with --file")
+		}
+
+		files, err := loadUpNodeFiles(context.Background())
+		if err != nil {
+			return err
+		}
+
+		var (
+			state     clusterNodes
+			bootstrap *upNodeFile
+		)
+		for i, file := range files {
+			if file.machineType.IsControlPlane() {
+				state.ControlPlaneNodes = append(state.ControlPlaneNodes, file.args.Nodes...)
+				if bootstrap == nil {
+					bootstrap = &files[i]
+				}
+			} else {
+				state.WorkerNodes = append(state.WorkerNodes, file.args.Nodes...)
+			}
+		}
+		if bootstrap == nil {
+			return fmt.Errorf("node files of control plane nodes must be specified")
+		}
+		if err = state.InitNodeInfos(); err != nil {
+			return err
+		}
+
+		// The bootstrap node is always reached directly, so a single node is used for the client
+		bootstrapArgs := bootstrap.args
+		bootstrapArgs.Nodes = bootstrap.args.Nodes[:1]
+
+		runner := newUpRunner()
+
+		fmt.Println("> Applying configuration to nodes in maintenance mode")
+		if err = runner.apply(files); err != nil {
+			return err
+		}
+
+		fmt.Printf("> Bootstrapping etcd on %s\n", bootstrapArgs.Nodes[0])
+		if err = runner.bootstrap(bootstrapArgs); err != nil {
+			return err
+		}
+
+		fmt.Println("> Waiting for the cluster to become healthy")
+		if err = waitClusterHealthy(bootstrapArgs, &state, upCmdFlags.healthTimeout); err != nil {
+			return fmt.Errorf("cluster is not healthy: %w", err)
+		}
+
+		fmt.Printf("> Writing kubeconfig to %s\n", upCmdFlags.kubeconfig)
+		return upKubeconfig(bootstrapArgs)
+	},
+}
+
+// upNodeFile is a node file rendered for bringing the cluster up.
+type upNodeFile struct {
+	file        string
+	args        global.Args
+	machineType machine.Type
+	config      []byte
+}
+
+// loadUpNodeFiles renders the node files and reads their nodes and machine types.
+func loadUpNodeFiles(ctx context.Context) ([]upNodeFile, error) {
+	opts := engine.Options{
+		TalosVersion:      upCmdFlags.talosVersion,
+		WithSecrets:       upCmdFlags.withSecrets,
+		KubernetesVersion: upCmdFlags.kubernetesVersion,
+	}
+
+	files := make([]upNodeFile, 0, len(upCmdFlags.configFiles))
+	for _, configFile := range upCmdFlags.configFiles {
+		fileArgs, err := modelineArgs(configFile, upCmdFlags.nodesFromArgs, upCmdFlags.endpointsFromArgs)
+		if err != nil {
+			return nil, err
+		}
+
+		configBundle, err := engine.FullConfigProcess(ctx, opts, []string{"@" + configFile})
+		if err != nil {
+			return nil, fmt.Errorf("full config processing error: %s", err)
+		}
+
+		machineType := configBundle.ControlPlaneCfg.Machine().Type()
+		result, err := engine.SerializeConfiguration(configBundle, machineType)
+		if err != nil {
+			return nil, fmt.Errorf("error serializing configuration: %s", err)
+		}
+
+		files = append(files, upNodeFile{file: configFile, args: fileArgs, machineType: machineType, config: result})
+	}
+
+	return files, nil
+}
+
+// upRunner applies configs and bootstraps the cluster, functions reaching the nodes are replaced in tests.
+type upRunner struct {
+	out           io.Writer
+	parallel      int
+	retryInterval time.Duration
+
+	nodeConfigured func(args global.Args) bool
+	applyConfig    func(args global.Args, config []byte, out io.Writer) error
+	bootstrapNode  func(args global.Args) error
+}
+
+func newUpRunner() *upRunner {
+	return &upRunner{
+		out:            os.Stdout,
+		parallel:       upCmdFlags.parallel,
+		retryInterval:  time.Second,
+		nodeConfigured: nodeConfigured,
+		applyConfig:    applyMaintenanceConfig,
+		bootstrapNode:  bootstrapNode,
+	}
+}
+
+// apply applies configs to the nodes in maintenance mode, nodes which are already configured are skipped.
+// Files are applied in parallel, nodes of a single file one by one.
+func (r *upRunner) apply(files []upNodeFile) error {
+	configFiles := make([]string, 0, len(files))
+	byFile := make(map[string]upNodeFile, len(files))
+	for _, file := range files {
+		configFiles = append(configFiles, file.file)
+		byFile[file.file] = file
+	}
+
+	results := runParallel(configFiles, r.parallel, false, r.out, func(_ context.Context, configFile string, out io.Writer) ([]string, error) {
+		file := byFile[configFile]
+
+		var errs []error
+		for _, node := range file.args.Nodes {
+			nodeArgs := file.args
+			nodeArgs.Nodes = []string{node}
+
+			if r.nodeConfigured(nodeArgs) {
+				fmt.Fprintf(out, "- talm: file=%s, node=%s is already configured, skipping\n", configFile, node)
+				continue
+			}
+
+			fmt.Fprintf(out, "- talm: file=%s, node=%s\n", configFile, node)
+			if err := r.applyConfig(nodeArgs, file.config, out); err != nil {
+				errs = append(errs, fmt.Errorf("node %s: %w", node, err))
+			}
+		}
+
+		return file.args.Nodes, errors.Join(errs...)
+	})
+
+	return printApplySummary(results)
+}
+
+// bootstrap waits for the API of the node and bootstraps etcd on it, if it isn't bootstrapped yet.
+func (r *upRunner) bootstrap(args global.Args) error {
+	return retry.Constant(upCmdFlags.bootstrapTimeout, retry.WithUnits(r.retryInterval)).Retry(func() error {
+		err := r.bootstrapNode(args)
+		switch {
+		case err == nil:
+			return nil
+		case client.StatusCode(err) == codes.AlreadyExists:
+			fmt.Fprintln(r.out, "- talm: etcd is already bootstrapped, skipping")
+			return nil
+		// The node is still installing or rebooting after the config was applied
+		case errors.Is(err, context.DeadlineExceeded),
+			client.StatusCode(err) == codes.FailedPrecondition,
+			client.StatusCode(err) == codes.DeadlineExceeded,
+			client.StatusCode(err) == codes.Unavailable,
+			strings.Contains(err.Error(), "connection refused"),
+			strings.Contains(err.Error(), "error reading from server: EOF"):
+			return retry.ExpectedError(err)
+		}
+
+		return fmt.Errorf("error executing bootstrap: %w", err)
+	})
+}
+
+// nodeConfigured reports whether the node accepts the talosconfig credentials,
+// so it has left maintenance mode.
+func nodeConfigured(args global.Args) bool {
+	return args.WithClient(func(ctx context.Context, c *client.Client) error {
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+
+		_, err := c.Version(ctx)
+		return err
+	}) == nil
+}
+
+// applyMaintenanceConfig applies the config to the node in maintenance mode.
+func applyMaintenanceConfig(args global.Args, config []byte, out io.Writer) error {
+	return args.WithClientMaintenance(upCmdFlags.certFingerprints, func(ctx context.Context, c *client.Client) error {
+		resp, err := c.ApplyConfiguration(ctx, &machineapi.ApplyConfigurationRequest{
+			Data: config,
+			Mode: machineapi.ApplyConfigurationRequest_AUTO,
+		})
+		if err != nil {
+			return fmt.Errorf("error applying new configuration: %s", err)
+		}
+
+		printApplyResults(out, resp)
+
+		return nil
+	})
+}
+
+// bootstrapNode makes a single attempt to bootstrap etcd on the node.
+func bootstrapNode(args global.Args) error {
+	return args.WithClient(func(ctx context.Context, c *client.Client) error {
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+
+		return c.Bootstrap(ctx, &machineapi.BootstrapRequest{})
+	})
+}
+
+// upKubeconfig writes the admin kubeconfig of the cluster, replacing the existing one.
+func upKubeconfig(args global.Args) error {
+	return args.WithClient(func(ctx context.Context, c *client.Client) error {
+		data, err := c.Kubeconfig(ctx)
+		if err != nil {
+			return fmt.Errorf("error getting kubeconfig: %w", err)
+		}
+
+		if err = os.MkdirAll(filepath.Dir(upCmdFlags.kubeconfig), 0o755); err != nil {
+			return err
+		}
+		return os.WriteFile(upCmdFlags.kubeconfig, data, 0o600)
+	})
+}
+
+func init() {
+	upCmd.Flags().StringSliceVarP(&upCmdFlags.configFiles, "file", "f", nil, "specify node files of the cluster (can specify multiple)")
+	upCmd.Flags().StringSliceVar(&upCmdFlags.certFingerprints, "cert-fingerprint", nil, "list of server certificate fingeprints to accept (defaults to no check)")
+	upCmd.Flags().StringVar(&upCmdFlags.talosVersion, "talos-version", "", "the desired Talos version to generate config for (backwards compatibility, e.g. v0.8)")
+	upCmd.Flags().StringVar(&upCmdFlags.withSecrets, "with-secrets", "", "use a secrets file generated using 'gen secrets'")
+	upCmd.Flags().StringVar(&upCmdFlags.kubernetesVersion, "kubernetes-version", constants.DefaultKubernetesVersion, "desired kubernetes version to run")
+	upCmd.Flags().IntVar(&upCmdFlags.parallel, "parallel", 5, "apply configs to up to N files concurrently")
+	upCmd.Flags().DurationVar(&upCmdFlags.bootstrapTimeout, "bootstrap-timeout", 10*time.Minute, "timeout to wait for the bootstrap node to become ready for bootstrap")
+	upCmd.Flags().DurationVar(&upCmdFlags.healthTimeout, "wait-timeout", 20*time.Minute, "timeout to wait for the cluster to be healthy")
+	upCmd.Flags().StringVar(&upCmdFlags.kubeconfig, "kubeconfig", "", "path to write the admin kubeconfig to (defaults to kubeconfig in the project root)")
+
+	addCommand(upCmd)
+}
@@ -0,0 +1,154 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/siderolabs/talos/cmd/talosctl/pkg/talos/global"
+)
+
+func TestUpApplySkipsConfiguredNodes(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		applied []string
+		out     bytes.Buffer
+	)
+	runner := &upRunner{
+		out:      &out,
+		parallel: 2,
+		nodeConfigured: func(args global.Args) bool {
+			return args.Nodes[0] == "10.0.0.2"
+		},
+		applyConfig: func(args global.Args, config []byte, out io.Writer) error {
+			if len(args.Nodes) != 1 {
+				t.Errorf("config is applied to %v at once", args.Nodes)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			applied = append(applied, args.Nodes...)
+			return nil
+		},
+	}
+
+	files := []upNodeFile{
+		{file: "nodes/cp.yaml", args: global.Args{Nodes: []string{"10.0.0.1", "10.0.0.2"}}},
+		{file: "nodes/worker.yaml", args: global.Args{Nodes: []string{"10.0.0.3"}}},
+	}
+	if err := runner.apply(files); err != nil {
+		t.Fatal(err)
+	}
+
+	sort.Strings(applied)
+	if expected := []string{"10.0.0.1", "10.0.0.3"}; !reflect.DeepEqual(applied, expected) {
+		t.Errorf("expected configs applied to %v, got %v", expected, applied)
+	}
+	if !strings.Contains(out.String(), "node=10.0.0.2 is already configured, skipping") {
+		t.Errorf("configured node isn't reported as skipped:\n%s", out.String())
+	}
+
+	// Other nodes of the file are applied after a failure
+	applied = nil
+	runner.nodeConfigured = func(global.Args) bool { return false }
+	runner.applyConfig = func(args global.Args, config []byte, out io.Writer) error {
+		mu.Lock()
+		defer mu.Unlock()
+		applied = append(applied, args.Nodes...)
+		if args.Nodes[0] == "10.0.0.1" {
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+	if err := runner.apply(files); err == nil {
+		t.Error("expected an error for the failed node")
+	}
+	if len(applied) != 3 {
+		t.Errorf("expected configs applied to all nodes, got %v", applied)
+	}
+}
+
+func TestUpBootstrap(t *testing.T) {
+	timeout := upCmdFlags.bootstrapTimeout
+	t.Cleanup(func() { upCmdFlags.bootstrapTimeout = timeout })
+	upCmdFlags.bootstrapTimeout = 200 * time.Millisecond
+
+	testCases := []struct {
+		name     string
+		errs     []error // results of consecutive attempts, the last one is repeated
+		attempts int
+		fails    bool
+		output   string
+	}{
+		{
+			name:     "bootstrapped",
+			errs:     []error{nil},
+			attempts: 1,
+		},
+		{
+			name: "node is not ready yet",
+			errs: []error{
+				errors.New("rpc error: code = Unavailable desc = connection error: dial tcp 10.0.0.1:50000: connect: connection refused"),
+				status.Error(codes.FailedPrecondition, "time is not in sync yet"),
+				nil,
+			},
+			attempts: 3,
+		},
+		{
+			name:     "already bootstrapped",
+			errs:     []error{status.Error(codes.Unavailable, "rebooting"), status.Error(codes.AlreadyExists, "etcd data directory is not empty")},
+			attempts: 2,
+			output:   "etcd is already bootstrapped",
+		},
+		{
+			name:     "unexpected error",
+			errs:     []error{status.Error(codes.PermissionDenied, "not authorized")},
+			attempts: 1,
+			fails:    true,
+		},
+		{
+			name:  "timeout",
+			errs:  []error{status.Error(codes.Unavailable, "rebooting")},
+			fails: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var out bytes.Buffer
+			attempts := 0
+			runner := &upRunner{
+				out:           &out,
+				retryInterval: 10 * time.Millisecond,
+				bootstrapNode: func(args global.Args) error {
+					err := tc.errs[min(attempts, len(tc.errs)-1)]
+					attempts++
+					return err
+				},
+			}
+
+			err := runner.bootstrap(global.Args{Nodes: []string{"10.0.0.1"}})
+			if tc.fails != (err != nil) {
+				t.Fatalf("unexpected result: %v", err)
+			}
+			if tc.attempts > 0 && attempts != tc.attempts {
+				t.Errorf("expected %d attempts, got %d", tc.attempts, attempts)
+			}
+			if !strings.Contains(out.String(), tc.output) {
+				t.Errorf("expected output %q, got %q", tc.output, out.String())
+			}
+		})
+	}
+}
@@ -22,11 +22,13 @@ import (
 
 	"github.com/siderolabs/talos/cmd/talosctl/cmd/common"
 	"github.com/siderolabs/talos/cmd/talosctl/pkg/talos/action"
+	"github.com/siderolabs/talos/cmd/talosctl/pkg/talos/global"
 	"github.com/siderolabs/talos/cmd/talosctl/pkg/talos/helpers"
 	"github.com/siderolabs/talos/pkg/cli"
-	"github.com/siderolabs/talos/pkg/machinery/api/machine"
+	machineapi "github.com/siderolabs/talos/pkg/machinery/api/machine"
 	"github.com/siderolabs/talos/pkg/machinery/client"
 	"github.com/siderolabs/talos/pkg/machinery/config/configloader"
+	"github.com/siderolabs/talos/pkg/machinery/config/machine"
 	"github.com/siderolabs/talos/pkg/machinery/constants"
 )
 
@@ -41,6 +43,11 @@ var upgradeCmdFlags struct {
 	talosVersion      string
 	withSecrets       string
 	kubernetesVersion string
+	nodesFromArgs     bool
+	endpointsFromArgs bool
+	rolling           bool
+	maxUnavailable    int
+	healthTimeout     time.Duration
 }
 
 var upgradeCmd = &cobra.Command{
@@ -67,6 +74,8 @@ var upgradeCmd = &cobra.Command{
 		if !cmd.Flags().Changed("force") {
 			upgradeCmdFlags.force = Config.UpgradeOptions.Force
 		}
+		upgradeCmdFlags.nodesFromArgs = len(GlobalArgs.Nodes) > 0
+		upgradeCmdFlags.endpointsFromArgs = len(GlobalArgs.Endpoints) > 0
 		return nil
 	},
 
@@ -78,6 +87,12 @@ var upgradeCmd = &cobra.Command{
 		if upgradeCmdFlags.wait && upgradeCmdFlags.insecure {
 			return fmt.Errorf("cannot use --wait and --insecure together")
 		}
+		if upgradeCmdFlags.rolling && !upgradeCmdFlags.wait {
+			return fmt.Errorf("cannot use --rolling with --wait=false")
+		}
+		if upgradeCmdFlags.maxUnavailable < 1 {
+			return fmt.Errorf("--max-unavailable must be at least 1")
+		}
 		if upgradeCmdFlags.insecure {
 			return WithClientMaintenance(nil, upgrade(args))
 		}
@@ -88,84 +103,130 @@ var upgradeCmd = &cobra.Command{
 
 func upgrade(args []string) func(ctx context.Context, c *client.Client) error {
 	return func(ctx context.Context, c *client.Client) error {
-		nodesFromArgs := len(GlobalArgs.Nodes) > 0
-		endpointsFromArgs := len(GlobalArgs.Endpoints) > 0
-		rebootModeStr := strings.ToUpper(upgradeCmdFlags.rebootMode)
-
-		rebootMode, rebootModeOk := machine.UpgradeRequest_RebootMode_value[rebootModeStr]
-		if !rebootModeOk {
-			return fmt.Errorf("invalid reboot mode: %s", upgradeCmdFlags.rebootMode)
-		}
-
+		targets := make([]upgradeTarget, 0, len(upgradeCmdFlags.configFiles))
 		for _, configFile := range upgradeCmdFlags.configFiles {
-			if err := processModelineAndUpdateGlobals(configFile, nodesFromArgs, endpointsFromArgs, true); err != nil {
-				return err
-			}
-
-			eopts := engine.Options{
-				TalosVersion:      upgradeCmdFlags.talosVersion,
-				WithSecrets:       upgradeCmdFlags.withSecrets,
-				KubernetesVersion: upgradeCmdFlags.kubernetesVersion,
-			}
-
-			patches := []string{"@" + configFile}
-			configBundle, err := engine.FullConfigProcess(ctx, eopts, patches)
-			if err != nil {
-				return fmt.Errorf("full config processing error: %s", err)
-			}
-
-			machineType := configBundle.ControlPlaneCfg.Machine().Type()
-			result, err := engine.SerializeConfiguration(configBundle, machineType)
-			if err != nil {
-				return fmt.Errorf("error serializing configuration: %s", err)
-			}
-
-			config, err := configloader.NewFromBytes(result)
+			target, err := prepareUpgrade(ctx, configFile)
 			if err != nil {
 				return err
 			}
+			targets = append(targets, target)
+		}
 
-			image := config.Machine().Install().Image()
-			if image == "" {
-				return fmt.Errorf("error getting image from config")
-			}
+		if upgradeCmdFlags.wait || upgradeCmdFlags.rolling {
+			common.SuppressErrors = true
+		}
 
-			opts := []client.UpgradeOption{
-				client.WithUpgradeImage(image),
-				client.WithUpgradeRebootMode(machine.UpgradeRequest_RebootMode(rebootMode)),
-				client.WithUpgradePreserve(upgradeCmdFlags.preserve),
-				client.WithUpgradeStage(upgradeCmdFlags.stage),
-				client.WithUpgradeForce(upgradeCmdFlags.force),
-			}
+		if upgradeCmdFlags.rolling {
+			return upgradeRolling(targets)
+		}
 
+		for _, target := range targets {
 			if !upgradeCmdFlags.wait {
-				return runUpgradeNoWait(opts)
+				if err := runUpgradeNoWait(&target.args, target.opts); err != nil {
+					return err
+				}
+
+				continue
 			}
 
-			common.SuppressErrors = true
+			fmt.Printf("- talm: file=%s, nodes=%s, endpoints=%s, image=%s\n", target.file, target.args.Nodes, target.args.Endpoints, target.image)
 
-			fmt.Printf("- talm: file=%s, nodes=%s, endpoints=%s, image=%s\n", configFile, GlobalArgs.Nodes, GlobalArgs.Endpoints, image)
-
-			err = action.NewTracker(
-				&GlobalArgs,
-				action.MachineReadyEventFn,
-				func(ctx context.Context, c *client.Client) (string, error) {
-					return upgradeGetActorID(ctx, c, opts)
-				},
-				action.WithPostCheck(action.BootIDChangedPostCheckFn),
-				action.WithDebug(upgradeCmdFlags.debug),
-				action.WithTimeout(upgradeCmdFlags.timeout),
-			).Run()
-			if err != nil {
+			if err := trackUpgrade(target, true); err != nil {
 				return err
 			}
 		}
 		return nil
 	}
-	return nil
 }
 
-func runUpgradeNoWait(opts []client.UpgradeOption) error {
+// upgradeTarget is a node file rendered and prepared for the upgrade.
+type upgradeTarget struct {
+	file        string
+	args        global.Args
+	machineType machine.Type
+	image       string
+	opts        []client.UpgradeOption
+}
+
+// prepareUpgrade renders the config file and builds upgrade options for the nodes from its modeline.
+func prepareUpgrade(ctx context.Context, configFile string) (upgradeTarget, error) {
+	target := upgradeTarget{file: configFile}
+
+	rebootModeStr := strings.ToUpper(upgradeCmdFlags.rebootMode)
+	rebootMode, rebootModeOk := machineapi.UpgradeRequest_RebootMode_value[rebootModeStr]
+	if !rebootModeOk {
+		return target, fmt.Errorf("invalid reboot mode: %s", upgradeCmdFlags.rebootMode)
+	}
+
+	fileArgs, err := modelineArgs(configFile, upgradeCmdFlags.nodesFromArgs, upgradeCmdFlags.endpointsFromArgs)
+	if err != nil {
+		return target, err
+	}
+	target.args = fileArgs
+
+	eopts := engine.Options{
+		TalosVersion:      upgradeCmdFlags.talosVersion,
+		WithSecrets:       upgradeCmdFlags.withSecrets,
+		KubernetesVersion: upgradeCmdFlags.kubernetesVersion,
+	}
+
+	patches := []string{"@" + configFile}
+	configBundle, err := engine.FullConfigProcess(ctx, eopts, patches)
+	if err != nil {
+		return target, fmt.Errorf("full config processing error: %s", err)
+	}
+
+	target.machineType = configBundle.ControlPlaneCfg.Machine().Type()
+	result, err := engine.SerializeConfiguration(configBundle, target.machineType)
+	if err != nil {
+		return target, fmt.Errorf("error serializing configuration: %s", err)
+	}
+
+	config, err := configloader.NewFromBytes(result)
+	if err != nil {
+		return target, err
+	}
+
+	target.image = config.Machine().Install().Image()
+	if target.image == "" {
+		return target, fmt.Errorf("error getting image from config")
+	}
+
+	target.opts = []client.UpgradeOption{
+		client.WithUpgradeImage(target.image),
+		client.WithUpgradeRebootMode(machineapi.UpgradeRequest_RebootMode(rebootMode)),
+		client.WithUpgradePreserve(upgradeCmdFlags.preserve),
+		client.WithUpgradeStage(upgradeCmdFlags.stage),
+		client.WithUpgradeForce(upgradeCmdFlags.force),
+	}
+
+	return target, nil
+}
+
+// trackUpgrade upgrades the target nodes and waits for them to come back after the reboot.
+//
+// It runs concurrently in --rolling mode, so common.SuppressErrors is set by the caller beforehand.
+func trackUpgrade(target upgradeTarget, isTerminal bool) error {
+	opts := []action.TrackerOption{
+		action.WithPostCheck(action.BootIDChangedPostCheckFn),
+		action.WithDebug(upgradeCmdFlags.debug),
+		action.WithTimeout(upgradeCmdFlags.timeout),
+	}
+	if !isTerminal {
+		opts = append(opts, action.WithTerminalOverride(false))
+	}
+
+	return action.NewTracker(
+		&target.args,
+		action.MachineReadyEventFn,
+		func(ctx context.Context, c *client.Client) (string, error) {
+			return upgradeGetActorID(ctx, c, target.opts)
+		},
+		opts...,
+	).Run()
+}
+
+func runUpgradeNoWait(args *global.Args, opts []client.UpgradeOption) error {
 	upgradeFn := func(ctx context.Context, c *client.Client) error {
 		if err := helpers.ClientVersionCheck(ctx, c); err != nil {
 			return err
@@ -204,10 +265,10 @@ func runUpgradeNoWait(opts []client.UpgradeOption) error {
 	}
 
 	if upgradeCmdFlags.insecure {
-		return WithClientMaintenance(nil, upgradeFn)
+		return args.WithClientMaintenance(nil, upgradeFn)
 	}
 
-	return WithClient(upgradeFn)
+	return args.WithClient(upgradeFn)
 }
 
 func upgradeGetActorID(ctx context.Context, c *client.Client, opts []client.UpgradeOption) (string, error) {
@@ -224,21 +285,24 @@ func upgradeGetActorID(ctx context.Context, c *client.Client, opts []client.Upgr
 }
 
 func init() {
-	rebootModes := maps.Keys(machine.UpgradeRequest_RebootMode_value)
+	rebootModes := maps.Keys(machineapi.UpgradeRequest_RebootMode_value)
 	sort.Slice(rebootModes, func(i, j int) bool {
-		return machine.UpgradeRequest_RebootMode_value[rebootModes[i]] < machine.UpgradeRequest_RebootMode_value[rebootModes[j]]
+		return machineapi.UpgradeRequest_RebootMode_value[rebootModes[i]] < machineapi.UpgradeRequest_RebootMode_value[rebootModes[j]]
 	})
 
 	rebootModes = xslices.Map(rebootModes, strings.ToLower)
 
-	upgradeCmd.Flags().StringVarP(&upgradeCmdFlags.rebootMode, "reboot-mode", "m", strings.ToLower(machine.UpgradeRequest_DEFAULT.String()),
+	upgradeCmd.Flags().StringVarP(&upgradeCmdFlags.rebootMode, "reboot-mode", "m", strings.ToLower(machineapi.UpgradeRequest_DEFAULT.String()),
 		fmt.Sprintf("select the reboot mode during upgrade. Mode %q bypasses kexec. Valid values are: %q.",
-			strings.ToLower(machine.UpgradeRequest_POWERCYCLE.String()),
+			strings.ToLower(machineapi.UpgradeRequest_POWERCYCLE.String()),
 			rebootModes))
 	upgradeCmd.Flags().BoolVarP(&upgradeCmdFlags.preserve, "preserve", "p", false, "preserve data")
 	upgradeCmd.Flags().BoolVarP(&upgradeCmdFlags.stage, "stage", "", false, "stage the upgrade to perform it after a reboot")
 	upgradeCmd.Flags().BoolVarP(&upgradeCmdFlags.force, "force", "", false, "force the upgrade (skip checks on etcd health and members, might lead to data loss)")
 	upgradeCmdFlags.addTrackActionFlags(upgradeCmd)
+	upgradeCmd.Flags().BoolVar(&upgradeCmdFlags.rolling, "rolling", false, "upgrade control plane nodes one by one and workers in batches, waiting for the cluster to become healthy in between")
+	upgradeCmd.Flags().IntVar(&upgradeCmdFlags.maxUnavailable, "max-unavailable", 1, "maximum number of worker nodes upgraded at the same time in --rolling mode")
+	upgradeCmd.Flags().DurationVar(&upgradeCmdFlags.healthTimeout, "health-timeout", 20*time.Minute, "timeout to wait for the cluster to be healthy after each step in --rolling mode")
 
 	upgradeCmd.Flags().BoolVarP(&upgradeCmdFlags.insecure, "insecure", "i", false, "apply using the insecure (encrypted with no auth) maintenance service")
 	upgradeCmd.Flags().StringSliceVarP(&upgradeCmdFlags.configFiles, "file", "f", nil, "specify config files or patches in a YAML file (can specify multiple)")
@@ -0,0 +1,318 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/aenix-io/talm/pkg/engine"
+	"github.com/aenix-io/talm/pkg/yamltools"
+	k8supgrade "github.com/siderolabs/go-kubernetes/kubernetes/upgrade"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+
+	"github.com/siderolabs/talos/cmd/talosctl/pkg/talos/global"
+	"github.com/siderolabs/talos/cmd/talosctl/pkg/talos/helpers"
+	"github.com/siderolabs/talos/pkg/cli"
+	"github.com/siderolabs/talos/pkg/cluster"
+	k8s "github.com/siderolabs/talos/pkg/cluster/kubernetes"
+	"github.com/siderolabs/talos/pkg/machinery/client"
+	"github.com/siderolabs/talos/pkg/machinery/config/encoder"
+	"github.com/siderolabs/talos/pkg/machinery/config/machine"
+	"github.com/siderolabs/talos/pkg/machinery/constants"
+)
+
+var upgradeK8sCmdFlags struct {
+	fromVersion       string
+	toVersion         string
+	endpoint          string
+	dryRun            bool
+	prePullImages     bool
+	upgradeKubelet    bool
+	force             bool
+	configFiles       []string // -f/--files
+	talosVersion      string
+	withSecrets       string
+	nodesFromArgs     bool
+	endpointsFromArgs bool
+}
+
+var upgradeK8sCmd = &cobra.Command{
+	Use:   "upgrade-k8s",
+	Short: "Upgrade Kubernetes on the nodes described by node files",
+	Long: `Upgrade Kubernetes control plane components, kubelets and bootstrap manifests
+of the cluster described by the node files.
+
+Control plane and worker nodes are taken from the node files according to their
+machine type. After a successful upgrade templateOptions.kubernetesVersion in
+Chart.yaml is updated and the node files are re-rendered in place, so the
+repository matches the cluster.`,
+	Example: `  talm upgrade-k8s --to 1.32.0 -f nodes/*.yaml`,
+	Args:    cobra.NoArgs,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if !cmd.Flags().Changed("talos-version") {
+			upgradeK8sCmdFlags.talosVersion = Config.TemplateOptions.TalosVersion
+		}
+		if !cmd.Flags().Changed("with-secrets") {
+			upgradeK8sCmdFlags.withSecrets = Config.TemplateOptions.WithSecrets
+		}
+		upgradeK8sCmdFlags.nodesFromArgs = len(GlobalArgs.Nodes) > 0
+		upgradeK8sCmdFlags.endpointsFromArgs = len(GlobalArgs.Endpoints) > 0
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(upgradeK8sCmdFlags.configFiles) == 0 {
+			return fmt.Errorf("node files must be specified with --file")
+		}
+
+		state, clusterArgs, err := loadK8sNodeFiles(context.Background())
+		if err != nil {
+			return err
+		}
+
+		if err = clusterArgs.WithClient(upgradeKubernetes(state)); err != nil {
+			return err
+		}
+
+		if upgradeK8sCmdFlags.dryRun {
+			return nil
+		}
+
+		if err = updateKubernetesVersion(upgradeK8sCmdFlags.toVersion); err != nil {
+			return err
+		}
+
+		// Re-render node files with the new version using their own modelines
+		templateCmdFlags.configFiles = upgradeK8sCmdFlags.configFiles
+		templateCmdFlags.inplace = true
+		if err = templateCmd.PreRunE(templateCmd, nil); err != nil {
+			return err
+		}
+		return templateCmd.RunE(templateCmd, nil)
+	},
+}
+
+// loadK8sNodeFiles reads nodes of the node files grouped by machine type and returns client args
+// pointing to the first control plane node, GlobalArgs are left untouched.
+func loadK8sNodeFiles(ctx context.Context) (*clusterNodes, global.Args, error) {
+	var (
+		state       clusterNodes
+		clusterArgs global.Args
+	)
+
+	eopts := engine.Options{
+		TalosVersion:      upgradeK8sCmdFlags.talosVersion,
+		WithSecrets:       upgradeK8sCmdFlags.withSecrets,
+		KubernetesVersion: Config.TemplateOptions.KubernetesVersion,
+	}
+
+	first := true
+	for _, configFile := range upgradeK8sCmdFlags.configFiles {
+		fileArgs, err := modelineArgs(configFile, upgradeK8sCmdFlags.nodesFromArgs, upgradeK8sCmdFlags.endpointsFromArgs)
+		if err != nil {
+			return nil, clusterArgs, err
+		}
+
+		configBundle, err := engine.FullConfigProcess(ctx, eopts, []string{"@" + configFile})
+		if err != nil {
+			return nil, clusterArgs, fmt.Errorf("full config processing error: %s", err)
+		}
+
+		if !configBundle.ControlPlaneCfg.Machine().Type().IsControlPlane() {
+			state.WorkerNodes = append(state.WorkerNodes, fileArgs.Nodes...)
+			continue
+		}
+
+		state.ControlPlaneNodes = append(state.ControlPlaneNodes, fileArgs.Nodes...)
+		if first {
+			clusterArgs = fileArgs
+			clusterArgs.Nodes = fileArgs.Nodes[:1]
+			first = false
+		}
+	}
+
+	if len(state.ControlPlaneNodes) == 0 {
+		return nil, clusterArgs, fmt.Errorf("node files of control plane nodes must be specified")
+	}
+
+	return &state, clusterArgs, nil
+}
+
+func upgradeKubernetes(state *clusterNodes) func(ctx context.Context, c *client.Client) error {
+	return func(ctx context.Context, c *client.Client) error {
+		if err := helpers.ClientVersionCheck(ctx, c); err != nil {
+			return err
+		}
+
+		clientProvider := &cluster.ConfigClientProvider{
+			DefaultClient: c,
+		}
+		defer clientProvider.Close() //nolint:errcheck
+
+		provider := struct {
+			cluster.ClientProvider
+			cluster.K8sProvider
+		}{
+			ClientProvider: clientProvider,
+			K8sProvider: &cluster.KubernetesClient{
+				ClientProvider: clientProvider,
+				ForceEndpoint:  upgradeK8sCmdFlags.endpoint,
+			},
+		}
+
+		if err := checkK8sNodes(ctx, &provider, state); err != nil {
+			return err
+		}
+
+		options := k8s.UpgradeOptions{
+			ControlPlaneEndpoint:   upgradeK8sCmdFlags.endpoint,
+			PrePullImages:          upgradeK8sCmdFlags.prePullImages,
+			UpgradeKubelet:         upgradeK8sCmdFlags.upgradeKubelet,
+			DryRun:                 upgradeK8sCmdFlags.dryRun,
+			EncoderOpt:             encoder.WithComments(encoder.CommentsDisabled),
+			KubeletImage:           constants.KubeletImage,
+			APIServerImage:         constants.KubernetesAPIServerImage,
+			ControllerManagerImage: constants.KubernetesControllerManagerImage,
+			SchedulerImage:         constants.KubernetesSchedulerImage,
+			ProxyImage:             constants.KubeProxyImage,
+		}
+
+		fromVersion := upgradeK8sCmdFlags.fromVersion
+		if fromVersion == "" {
+			var err error
+			fromVersion, err = k8s.DetectLowestVersion(ctx, &provider, options)
+			if err != nil {
+				return fmt.Errorf("error detecting the lowest Kubernetes version: %w", err)
+			}
+
+			options.Log("automatically detected the lowest Kubernetes version %s", fromVersion)
+		}
+
+		path, err := k8supgrade.NewPath(fromVersion, upgradeK8sCmdFlags.toVersion)
+		if err != nil {
+			return fmt.Errorf("error creating upgrade path: %w", err)
+		}
+		options.Path = path
+
+		if err = k8s.Upgrade(ctx, &provider, options); err != nil {
+			return err
+		}
+
+		if upgradeK8sCmdFlags.dryRun {
+			fmt.Println("> Dry-run mode enabled, no changes were made to the cluster, re-run without `--dry-run` to apply the changes.")
+		}
+
+		return nil
+	}
+}
+
+// checkK8sNodes compares nodes from the node files with nodes registered in Kubernetes.
+// Cluster nodes which are not covered by the node files would be upgraded without
+// their files being updated, so they fail the check unless --force is set.
+func checkK8sNodes(ctx context.Context, provider cluster.K8sProvider, state *clusterNodes) error {
+	k8sClient, err := provider.K8sHelper(ctx)
+	if err != nil {
+		return fmt.Errorf("error building kubernetes client: %w", err)
+	}
+	defer k8sClient.Close() //nolint:errcheck
+
+	for _, check := range []struct {
+		machineType machine.Type
+		fileNodes   []string
+	}{
+		{machine.TypeControlPlane, state.ControlPlaneNodes},
+		{machine.TypeWorker, state.WorkerNodes},
+	} {
+		registered, err := k8sClient.NodeIPs(ctx, check.machineType)
+		if err != nil {
+			return fmt.Errorf("error fetching %s nodes: %w", check.machineType, err)
+		}
+
+		for _, node := range registered {
+			if slices.Contains(check.fileNodes, node) {
+				continue
+			}
+			if !upgradeK8sCmdFlags.force {
+				return fmt.Errorf("%s node %s is not described by the node files, pass its node file or use --force", check.machineType, node)
+			}
+			cli.Warning("%s node %s is not described by the node files", check.machineType, node)
+		}
+
+		for _, node := range check.fileNodes {
+			if !slices.Contains(registered, node) {
+				cli.Warning("%s node %s from the node files is not registered in Kubernetes", check.machineType, node)
+			}
+		}
+	}
+
+	return nil
+}
+
+// updateKubernetesVersion sets templateOptions.kubernetesVersion in Chart.yaml of the project.
+func updateKubernetesVersion(version string) error {
+	configFile := filepath.Join(Config.RootDir, "Chart.yaml")
+	data, err := os.ReadFile(configFile)
+	if err != nil {
+		return err
+	}
+
+	var doc yaml.Node
+	if err = yaml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("failed to unmarshal %s: %w", configFile, err)
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return fmt.Errorf("unexpected format of %s", configFile)
+	}
+
+	templateOptions := mappingValue(doc.Content[0], "templateOptions", yaml.MappingNode)
+	value := mappingValue(templateOptions, "kubernetesVersion", yaml.ScalarNode)
+	value.Value = version
+	value.Tag = "!!str"
+
+	out, err := yamltools.EncodeDocuments([]*yaml.Node{&doc})
+	if err != nil {
+		return err
+	}
+	if err = os.WriteFile(configFile, out, 0o644); err != nil {
+		return err
+	}
+
+	Config.TemplateOptions.KubernetesVersion = version
+	fmt.Printf("> Updated templateOptions.kubernetesVersion in %s to %s\n", configFile, version)
+
+	return nil
+}
+
+// mappingValue returns the value of the key in the mapping node, adding the key if it is missing.
+func mappingValue(node *yaml.Node, key string, kind yaml.Kind) *yaml.Node {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+
+	value := &yaml.Node{Kind: kind}
+	node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, value)
+	return value
+}
+
+func init() {
+	upgradeK8sCmd.Flags().StringVar(&upgradeK8sCmdFlags.fromVersion, "from", "", "the Kubernetes control plane version to upgrade from (detected automatically by default)")
+	upgradeK8sCmd.Flags().StringVar(&upgradeK8sCmdFlags.toVersion, "to", constants.DefaultKubernetesVersion, "the Kubernetes control plane version to upgrade to")
+	upgradeK8sCmd.Flags().StringVar(&upgradeK8sCmdFlags.endpoint, "endpoint", "", "the cluster control plane endpoint")
+	upgradeK8sCmd.Flags().BoolVar(&upgradeK8sCmdFlags.dryRun, "dry-run", false, "skip the actual upgrade and show the upgrade plan instead")
+	upgradeK8sCmd.Flags().BoolVar(&upgradeK8sCmdFlags.prePullImages, "pre-pull-images", true, "pre-pull images before upgrade")
+	upgradeK8sCmd.Flags().BoolVar(&upgradeK8sCmdFlags.upgradeKubelet, "upgrade-kubelet", true, "upgrade kubelet service")
+	upgradeK8sCmd.Flags().BoolVar(&upgradeK8sCmdFlags.force, "force", false, "upgrade even if some cluster nodes are not described by the node files")
+	upgradeK8sCmd.Flags().StringSliceVarP(&upgradeK8sCmdFlags.configFiles, "file", "f", nil, "specify node files of the cluster (can specify multiple)")
+	upgradeK8sCmd.Flags().StringVar(&upgradeK8sCmdFlags.talosVersion, "talos-version", "", "the desired Talos version to generate config for (backwards compatibility, e.g. v0.8)")
+	upgradeK8sCmd.Flags().StringVar(&upgradeK8sCmdFlags.withSecrets, "with-secrets", "", "use a secrets file generated using 'gen secrets'")
+
+	addCommand(upgradeK8sCmd)
+}
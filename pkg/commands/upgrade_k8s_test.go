@@ -0,0 +1,49 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoadK8sNodeFilesContexts(t *testing.T) {
+	root := t.TempDir()
+	writeTestFiles(t, root, map[string]string{
+		"nodes/worker.yaml": "# talm: nodes=[\"10.0.1.1\"], endpoints=[\"10.0.1.1\"], templates=[\"templates/worker.yaml\"], context=\"staging\"\nmachine:\n  type: worker\n",
+		"nodes/cp1.yaml":    "# talm: nodes=[\"10.0.0.1\",\"10.0.0.2\"], endpoints=[\"10.0.0.1\"], templates=[\"templates/controlplane.yaml\"], context=\"prod\"\nmachine:\n  type: controlplane\n",
+		"nodes/cp2.yaml":    "# talm: nodes=[\"10.0.0.3\"], endpoints=[\"10.0.0.3\"], templates=[\"templates/controlplane.yaml\"], context=\"other\"\nmachine:\n  type: controlplane\n",
+	})
+
+	globalArgs, configFiles := GlobalArgs, upgradeK8sCmdFlags.configFiles
+	t.Cleanup(func() { GlobalArgs, upgradeK8sCmdFlags.configFiles = globalArgs, configFiles })
+	GlobalArgs.Nodes, GlobalArgs.Endpoints, GlobalArgs.CmdContext = nil, nil, ""
+	upgradeK8sCmdFlags.configFiles = []string{
+		filepath.Join(root, "nodes", "worker.yaml"),
+		filepath.Join(root, "nodes", "cp1.yaml"),
+		filepath.Join(root, "nodes", "cp2.yaml"),
+	}
+
+	state, clusterArgs, err := loadK8sNodeFiles(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(state.ControlPlaneNodes, []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}) || !reflect.DeepEqual(state.WorkerNodes, []string{"10.0.1.1"}) {
+		t.Errorf("unexpected nodes %+v", state)
+	}
+
+	// The cluster is reached through the first control plane node with the context of its file
+	if clusterArgs.CmdContext != "prod" || !reflect.DeepEqual(clusterArgs.Nodes, []string{"10.0.0.1"}) || !reflect.DeepEqual(clusterArgs.Endpoints, []string{"10.0.0.1"}) {
+		t.Errorf("unexpected client args: context=%s, nodes=%v, endpoints=%v", clusterArgs.CmdContext, clusterArgs.Nodes, clusterArgs.Endpoints)
+	}
+
+	// Node files are re-rendered afterwards with contexts of their own modelines
+	if GlobalArgs.CmdContext != "" || len(GlobalArgs.Nodes) != 0 || len(GlobalArgs.Endpoints) != 0 {
+		t.Errorf("global args were modified: context=%s, nodes=%v, endpoints=%v", GlobalArgs.CmdContext, GlobalArgs.Nodes, GlobalArgs.Endpoints)
+	}
+}
@@ -0,0 +1,238 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/cosi-project/runtime/pkg/safe"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/siderolabs/talos/cmd/talosctl/pkg/talos/global"
+	"github.com/siderolabs/talos/pkg/cluster"
+	"github.com/siderolabs/talos/pkg/cluster/check"
+	"github.com/siderolabs/talos/pkg/machinery/client"
+	"github.com/siderolabs/talos/pkg/machinery/config/machine"
+	clusterres "github.com/siderolabs/talos/pkg/machinery/resources/cluster"
+)
+
+// upgradeRolling upgrades control plane nodes one at a time and then worker nodes in batches of
+// --max-unavailable, waiting for the cluster to become healthy after every step.
+//
+// It stops on the first failed upgrade or health check and prints the progress report.
+func upgradeRolling(targets []upgradeTarget) error {
+	var state clusterNodes
+
+	for _, target := range targets {
+		if target.machineType.IsControlPlane() {
+			state.ControlPlaneNodes = append(state.ControlPlaneNodes, target.args.Nodes...)
+		} else {
+			state.WorkerNodes = append(state.WorkerNodes, target.args.Nodes...)
+		}
+	}
+
+	if len(targets) == 0 {
+		return nil
+	}
+
+	if err := state.InitNodeInfos(); err != nil {
+		return err
+	}
+
+	ordered, batches := rollingBatches(targets, upgradeCmdFlags.maxUnavailable)
+
+	// Health checks are run through the control plane endpoints if there are any
+	healthArgs := ordered[0].args
+
+	status := make(map[string]string, len(ordered))
+	for _, target := range ordered {
+		status[target.key()] = "pending"
+	}
+
+	for _, batch := range batches {
+		if err := upgradeBatch(batch); err != nil {
+			for _, target := range batch {
+				status[target.key()] = "failed"
+			}
+			printRollingReport(ordered, status)
+
+			return fmt.Errorf("rolling upgrade stopped: %w", err)
+		}
+
+		if err := waitClusterHealthy(healthArgs, &state, upgradeCmdFlags.healthTimeout); err != nil {
+			for _, target := range batch {
+				status[target.key()] = "unhealthy"
+			}
+			printRollingReport(ordered, status)
+
+			return fmt.Errorf("rolling upgrade stopped, cluster is not healthy after upgrading %s: %w", batchFiles(batch), err)
+		}
+
+		for _, target := range batch {
+			status[target.key()] = "upgraded"
+		}
+	}
+
+	printRollingReport(ordered, status)
+
+	return nil
+}
+
+// rollingBatches splits targets by node and returns them with control plane nodes first, along
+// with the batches to upgrade: every control plane node alone and worker nodes by maxUnavailable.
+func rollingBatches(targets []upgradeTarget, maxUnavailable int) ([]upgradeTarget, [][]upgradeTarget) {
+	var controlPlanes, workers []upgradeTarget
+
+	for _, target := range targets {
+		for _, node := range target.args.Nodes {
+			nodeTarget := target
+			nodeTarget.args.Nodes = []string{node}
+
+			if target.machineType.IsControlPlane() {
+				controlPlanes = append(controlPlanes, nodeTarget)
+			} else {
+				workers = append(workers, nodeTarget)
+			}
+		}
+	}
+
+	var batches [][]upgradeTarget
+	for _, target := range controlPlanes {
+		batches = append(batches, []upgradeTarget{target})
+	}
+	for i := 0; i < len(workers); i += maxUnavailable {
+		batches = append(batches, workers[i:min(i+maxUnavailable, len(workers))])
+	}
+
+	return append(controlPlanes, workers...), batches
+}
+
+// key identifies the target in the progress report.
+func (target upgradeTarget) key() string {
+	return target.file + "@" + strings.Join(target.args.Nodes, ",")
+}
+
+// upgradeBatch upgrades all targets of the batch concurrently.
+func upgradeBatch(batch []upgradeTarget) error {
+	var eg errgroup.Group
+
+	for _, target := range batch {
+		fmt.Printf("- talm: file=%s, nodes=%s, endpoints=%s, image=%s\n", target.file, target.args.Nodes, target.args.Endpoints, target.image)
+
+		eg.Go(func() error {
+			if err := trackUpgrade(target, len(batch) == 1); err != nil {
+				return fmt.Errorf("upgrade of %s failed: %w", target.file, err)
+			}
+
+			return nil
+		})
+	}
+
+	return eg.Wait()
+}
+
+// waitClusterHealthy runs the same checks as `talm health` against all nodes of the cluster.
+//
+// The checks expect the exact list of Kubernetes and etcd members, so members found by discovery
+// are checked together with the nodes from the node files, which can be a subset of the cluster.
+func waitClusterHealthy(args global.Args, state *clusterNodes, timeout time.Duration) error {
+	return args.WithClientNoNodes(func(ctx context.Context, c *client.Client) error {
+		clientProvider := &cluster.ConfigClientProvider{
+			DefaultClient: c,
+		}
+		defer clientProvider.Close() //nolint:errcheck
+
+		var members []*clusterres.Member
+
+		items, err := safe.StateListAll[*clusterres.Member](ctx, c.COSI)
+		if err != nil {
+			return fmt.Errorf("error listing cluster members: %w", err)
+		}
+
+		items.ForEach(func(item *clusterres.Member) { members = append(members, item) })
+
+		clusterState := struct {
+			cluster.ClientProvider
+			cluster.K8sProvider
+			cluster.Info
+		}{
+			ClientProvider: clientProvider,
+			K8sProvider: &cluster.KubernetesClient{
+				ClientProvider: clientProvider,
+			},
+			Info: healthClusterInfo(members, state),
+		}
+
+		checkCtx, checkCtxCancel := context.WithTimeout(ctx, timeout)
+		defer checkCtxCancel()
+
+		return check.Wait(checkCtx, &clusterState, check.DefaultClusterChecks(), check.StderrReporter())
+	})
+}
+
+// healthClusterInfo returns discovered cluster members together with the nodes from the node files
+// which aren't discovered yet, e.g. while the cluster is coming up.
+func healthClusterInfo(members []*clusterres.Member, files *clusterNodes) *clusterNodes {
+	info := &clusterNodes{
+		nodesByType: map[machine.Type][]cluster.NodeInfo{},
+	}
+
+	add := func(machineType machine.Type, node cluster.NodeInfo) {
+		info.nodesByType[machineType] = append(info.nodesByType[machineType], node)
+		info.nodes = append(info.nodes, node)
+	}
+
+	for _, member := range members {
+		spec := member.TypedSpec()
+		if len(spec.Addresses) == 0 {
+			continue
+		}
+
+		add(spec.MachineType, cluster.NodeInfo{
+			InternalIP: spec.Addresses[0],
+			IPs:        slices.Clone(spec.Addresses),
+		})
+	}
+
+	for _, machineType := range []machine.Type{machine.TypeInit, machine.TypeControlPlane, machine.TypeWorker} {
+		for _, node := range files.NodesByType(machineType) {
+			discovered := slices.ContainsFunc(info.nodes, func(n cluster.NodeInfo) bool {
+				return slices.ContainsFunc(n.IPs, func(ip netip.Addr) bool { return slices.Contains(node.IPs, ip) })
+			})
+			if !discovered {
+				add(machineType, node)
+			}
+		}
+	}
+
+	return info
+}
+
+func printRollingReport(targets []upgradeTarget, status map[string]string) {
+	w := tabwriter.NewWriter(os.Stderr, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "FILE\tTYPE\tNODES\tSTATUS")
+
+	for _, target := range targets {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", target.file, target.machineType, strings.Join(target.args.Nodes, ","), status[target.key()])
+	}
+
+	w.Flush() //nolint:errcheck
+}
+
+func batchFiles(batch []upgradeTarget) string {
+	files := make([]string, 0, len(batch))
+	for _, target := range batch {
+		files = append(files, fmt.Sprintf("%s (%s)", target.file, strings.Join(target.args.Nodes, ", ")))
+	}
+
+	return strings.Join(files, ", ")
+}
@@ -0,0 +1,126 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/siderolabs/talos/pkg/cluster"
+	"github.com/siderolabs/talos/pkg/machinery/config/machine"
+	clusterres "github.com/siderolabs/talos/pkg/machinery/resources/cluster"
+)
+
+func testMember(id string, machineType machine.Type, addresses ...string) *clusterres.Member {
+	member := clusterres.NewMember(clusterres.NamespaceName, id)
+	member.TypedSpec().MachineType = machineType
+	for _, address := range addresses {
+		member.TypedSpec().Addresses = append(member.TypedSpec().Addresses, netip.MustParseAddr(address))
+	}
+
+	return member
+}
+
+func testNodeInfos(t *testing.T, ips ...string) []cluster.NodeInfo {
+	t.Helper()
+
+	nodes, err := cluster.IPsToNodeInfos(ips)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return nodes
+}
+
+func TestHealthClusterInfoPartialFiles(t *testing.T) {
+	members := []*clusterres.Member{
+		testMember("cp1", machine.TypeControlPlane, "10.0.0.1", "192.168.0.1"),
+		testMember("cp2", machine.TypeControlPlane, "10.0.0.2"),
+		testMember("w1", machine.TypeWorker, "10.0.0.11"),
+		testMember("w2", machine.TypeWorker, "10.0.0.12"),
+	}
+
+	// Only a single worker file is upgraded
+	files := &clusterNodes{WorkerNodes: []string{"10.0.0.12"}}
+	if err := files.InitNodeInfos(); err != nil {
+		t.Fatal(err)
+	}
+
+	info := healthClusterInfo(members, files)
+
+	// Nodes reported by Kubernetes and etcd must match the checked nodes exactly
+	if err := cluster.NodesMatch(info.Nodes(), testNodeInfos(t, "10.0.0.1", "10.0.0.2", "10.0.0.11", "10.0.0.12")); err != nil {
+		t.Errorf("all nodes: %s", err)
+	}
+	if err := cluster.NodesMatch(info.NodesByType(machine.TypeControlPlane), testNodeInfos(t, "10.0.0.1", "10.0.0.2")); err != nil {
+		t.Errorf("control plane nodes: %s", err)
+	}
+	if err := cluster.NodesMatch(info.NodesByType(machine.TypeWorker), testNodeInfos(t, "10.0.0.11", "10.0.0.12")); err != nil {
+		t.Errorf("worker nodes: %s", err)
+	}
+}
+
+func TestHealthClusterInfoUndiscoveredFiles(t *testing.T) {
+	members := []*clusterres.Member{
+		testMember("cp1", machine.TypeControlPlane, "10.0.0.1", "192.168.0.1"),
+	}
+
+	// A node file addresses the member by its second address, another node has not joined yet
+	files := &clusterNodes{
+		ControlPlaneNodes: []string{"192.168.0.1"},
+		WorkerNodes:       []string{"10.0.0.11"},
+	}
+	if err := files.InitNodeInfos(); err != nil {
+		t.Fatal(err)
+	}
+
+	info := healthClusterInfo(members, files)
+
+	if len(info.Nodes()) != 2 {
+		t.Fatalf("expected 2 nodes, got %v", info.Nodes())
+	}
+	if err := cluster.NodesMatch(info.NodesByType(machine.TypeWorker), testNodeInfos(t, "10.0.0.11")); err != nil {
+		t.Errorf("worker nodes: %s", err)
+	}
+}
+
+func TestRollingBatchesCountNodes(t *testing.T) {
+	target := func(file string, machineType machine.Type, nodes ...string) upgradeTarget {
+		target := upgradeTarget{file: file, machineType: machineType}
+		target.args.Nodes = nodes
+
+		return target
+	}
+
+	ordered, batches := rollingBatches([]upgradeTarget{
+		target("worker1.yaml", machine.TypeWorker, "10.0.0.11", "10.0.0.12", "10.0.0.13"),
+		target("cp.yaml", machine.TypeControlPlane, "10.0.0.1", "10.0.0.2"),
+		target("worker2.yaml", machine.TypeWorker, "10.0.0.14"),
+	}, 2)
+
+	var got []string
+	for _, batch := range batches {
+		var keys []string
+		for _, target := range batch {
+			keys = append(keys, target.key())
+		}
+		got = append(got, strings.Join(keys, " "))
+	}
+
+	expected := []string{
+		"cp.yaml@10.0.0.1",
+		"cp.yaml@10.0.0.2",
+		"worker1.yaml@10.0.0.11 worker1.yaml@10.0.0.12",
+		"worker1.yaml@10.0.0.13 worker2.yaml@10.0.0.14",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("unexpected batches %q, expected %q", got, expected)
+	}
+	if len(ordered) != 6 || ordered[0].key() != "cp.yaml@10.0.0.1" {
+		t.Errorf("unexpected order of nodes %v", ordered)
+	}
+}
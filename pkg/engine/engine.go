@@ -1,15 +1,16 @@
 package engine
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"reflect"
 	"strconv"
 	"strings"
+	"sync"
 	"unsafe"
 
 	"gopkg.in/yaml.v3"
@@ -18,7 +19,6 @@ import (
 	"github.com/aenix-io/talm/pkg/yamltools"
 	"github.com/cosi-project/runtime/pkg/resource"
 	"github.com/cosi-project/runtime/pkg/resource/meta"
-	"github.com/hashicorp/go-multierror"
 	"helm.sh/helm/v3/pkg/chart"
 	"helm.sh/helm/v3/pkg/chart/loader"
 	"helm.sh/helm/v3/pkg/strvals"
@@ -35,6 +35,15 @@ import (
 	"github.com/siderolabs/talos/pkg/machinery/config/machine"
 )
 
+// Formats of rendered patches.
+const (
+	PatchFormatStrategic = "strategic"
+	PatchFormatJSONPatch = "jsonpatch"
+)
+
+// PatchFormats is a list of supported formats of rendered patches.
+var PatchFormats = []string{PatchFormatStrategic, PatchFormatJSONPatch}
+
 // Options encapsulates all parameters necessary for rendering.
 type Options struct {
 	Insecure          bool
@@ -44,12 +53,23 @@ type Options struct {
 	FileValues        []string
 	JsonValues        []string
 	LiteralValues     []string
+	NodeValueFiles    []string               // per-node value files from the modeline
+	NodeValues        map[string]interface{} // per-node values from the modeline
 	TalosVersion      string
 	WithSecrets       string
+	SecretsBundle     *secrets.Bundle // overrides WithSecrets
 	Full              bool
+	PatchFormat       string
 	Debug             bool
 	Root              string
 	Offline           bool
+	Facts             string
+	Strict            bool
+	LockFile          string
+	Locked            bool
+	ExplainLookups    io.Writer // reports every lookup, its latency and errors if set
+	K8sLookup         bool      // enables the k8sLookup template function
+	Kubeconfig        string    // kubeconfig for k8sLookup, fetched from the node if empty
 	KubernetesVersion string
 	TemplateFiles     []string
 	ClusterName       string
@@ -134,6 +154,7 @@ func FullConfigProcess(ctx context.Context, opts Options, patches []string) (*bu
 	updatedOpts := Options{
 		TalosVersion:      opts.TalosVersion,
 		WithSecrets:       opts.WithSecrets,
+		SecretsBundle:     opts.SecretsBundle,
 		KubernetesVersion: opts.KubernetesVersion,
 		ClusterName:       clusterName,
 		Endpoint:          clusterEndpoint.String(),
@@ -143,8 +164,9 @@ func FullConfigProcess(ctx context.Context, opts Options, patches []string) (*bu
 		return nil, fmt.Errorf("reinit config bundle error: %w", err)
 	}
 
-	// Applying updated patches
-	err = configBundle.ApplyPatches(loadedPatches, (machineType == machine.TypeControlPlane), (machineType == machine.TypeWorker))
+	// Applying updated patches, the control plane config is always patched,
+	// so callers can read the machine type of the file from it
+	err = configBundle.ApplyPatches(loadedPatches, true, (machineType == machine.TypeWorker))
 	if err != nil {
 		return nil, fmt.Errorf("apply updated patches error: %w", err)
 	}
@@ -164,8 +186,10 @@ func InitializeConfigBundle(opts Options) (*bundle.Bundle, error) {
 		genOptions = append(genOptions, generate.WithVersionContract(versionContract))
 	}
 
-	if opts.WithSecrets != "" {
-		secretsBundle, err := secrets.LoadBundle(opts.WithSecrets)
+	if opts.SecretsBundle != nil {
+		genOptions = append(genOptions, generate.WithSecretsBundle(opts.SecretsBundle))
+	} else if opts.WithSecrets != "" {
+		secretsBundle, err := LoadSecretsBundle(opts.WithSecrets)
 		if err != nil {
 			return nil, fmt.Errorf("failed to load secrets bundle: %w", err)
 		}
@@ -192,16 +216,73 @@ func SerializeConfiguration(configBundle *bundle.Bundle, machineType machine.Typ
 	return configBundle.Serialize(encoder.CommentsDisabled, machineType)
 }
 
+// renderMu serializes renders, as the lookup function is shared by all of them.
+var renderMu sync.Mutex
+
 // Render executes the rendering of templates based on the provided options.
 func Render(ctx context.Context, c *client.Client, opts Options) ([]byte, error) {
+	renderMu.Lock()
+	defer renderMu.Unlock()
 
-	// Gather facts and enable lookup options
-	if !opts.Offline {
+	// Kubernetes objects are neither in facts nor in lock files, so renders using them can't be reproduced
+	if opts.K8sLookup && (opts.Offline || opts.Facts != "" || opts.LockFile != "") {
+		return nil, fmt.Errorf("k8sLookup can't be used with --offline, --facts, --lock or --locked, as Kubernetes objects are not recorded")
+	}
+
+	// Gather facts and enable lookup options, lookups are cached for the duration of the render
+	var lookup LookupFunc
+	cache := newLookupCache(opts.ExplainLookups)
+	defer cache.summary()
+	if opts.Facts != "" {
+		facts, err := LoadFacts(opts.Facts)
+		if err != nil {
+			return nil, err
+		}
+		lookup = cache.wrap(facts.Lookup)
+	} else if !opts.Offline {
 		if err := helpers.FailIfMultiNodes(ctx, "talm template"); err != nil {
 			return nil, err
 		}
-		helmEngine.LookupFunc = newLookupFunction(ctx, c)
+		lookup = cache.wrap(newLookupFunction(ctx, c, cache.callError))
+	}
+	// Offline rendering finds nothing by design, so empty lookups don't fail it in strict mode
+	served := lookup != nil || opts.Locked
+
+	// Record or replay lookups using the lock file
+	var lock *Lock
+	switch {
+	case opts.Locked:
+		locked, err := LoadLock(opts.LockFile)
+		if err != nil {
+			return nil, err
+		}
+		lookup = locked.locked(lookup)
+	case opts.LockFile != "":
+		if lookup == nil {
+			lookup = emptyLookup
+		}
+		lock = &Lock{}
+		lookup = lock.recorder(lookup)
+	}
+
+	if lookup == nil {
+		lookup = emptyLookup
+	}
+	if opts.Strict && served {
+		lookup = strictLookup(lookup)
+	}
+	helmEngine.LookupFunc = lookup
+	helmEngine.LookupListFunc = listLookup(lookup)
+
+	// Kubernetes lookups must be enabled explicitly, as they reach the cluster API
+	k8sLookup := K8sLookupFunc(disabledK8sLookup)
+	if opts.K8sLookup {
+		k8sLookup = cache.wrapK8s(newK8sLookupFunction(ctx, c, opts.Kubeconfig))
+		if opts.Strict {
+			k8sLookup = strictK8sLookup(k8sLookup)
+		}
 	}
+	helmEngine.K8sLookupFunc = k8sLookup
 
 	chartPath, err := os.Getwd()
 	if err != nil {
@@ -225,7 +306,9 @@ func Render(ctx context.Context, c *client.Client, opts Options) ([]byte, error)
 		"Values": mergeMaps(chrt.Values, values),
 	}
 
-	eng := helmEngine.Engine{}
+	eng := helmEngine.Engine{
+		Strict: opts.Strict,
+	}
 	out, err := eng.Render(chrt, rootValues)
 	if err != nil {
 		return nil, err
@@ -247,6 +330,12 @@ func Render(ctx context.Context, c *client.Client, opts Options) ([]byte, error)
 		return nil, err
 	}
 
+	if lock != nil {
+		if err := lock.Save(opts.LockFile); err != nil {
+			return nil, fmt.Errorf("failed to write lock file %s: %w", opts.LockFile, err)
+		}
+	}
+
 	return finalConfig, nil
 }
 
@@ -269,6 +358,22 @@ func loadValues(opts Options) (map[string]interface{}, error) {
 		base = mergeMaps(base, currentMap)
 	}
 
+	// Per-node values from the modeline override value files, but not values from the command line
+	for _, filePath := range opts.NodeValueFiles {
+		currentMap := make(map[string]interface{})
+		bytes, err := os.ReadFile(filePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read node values file %s: %w", filePath, err)
+		}
+		if err := yaml.Unmarshal(bytes, &currentMap); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal values from node values file %s: %w", filePath, err)
+		}
+		base = mergeMaps(base, currentMap)
+	}
+	if len(opts.NodeValues) > 0 {
+		base = mergeMaps(base, opts.NodeValues)
+	}
+
 	// Parse and merge values from --set-json
 	for _, value := range opts.JsonValues {
 		currentMap := make(map[string]interface{})
@@ -346,8 +451,10 @@ func applyPatchesAndRenderConfig(ctx context.Context, opts Options, configPatche
 		genOptions = append(genOptions, generate.WithVersionContract(versionContract))
 	}
 
-	if opts.WithSecrets != "" {
-		secretsBundle, err := secrets.LoadBundle(opts.WithSecrets)
+	if opts.SecretsBundle != nil {
+		genOptions = append(genOptions, generate.WithSecretsBundle(opts.SecretsBundle))
+	} else if opts.WithSecrets != "" {
+		secretsBundle, err := LoadSecretsBundle(opts.WithSecrets)
 		if err != nil {
 			return nil, fmt.Errorf("failed to load secrets bundle: %w", err)
 		}
@@ -420,24 +527,7 @@ func applyPatchesAndRenderConfig(ctx context.Context, opts Options, configPatche
 			return nil, err
 		}
 
-		// Overwrite some fields to preserve them for diff
-		var config map[string]interface{}
-		if err := yaml.Unmarshal(configOrigin, &config); err != nil {
-			return nil, err
-		}
-		if machine, ok := config["machine"].(map[string]interface{}); ok {
-			machine["type"] = "unknown"
-		}
-		if cluster, ok := config["cluster"].(map[string]interface{}); ok {
-			cluster["clusterName"] = ""
-			controlPlane, ok := cluster["controlPlane"].(map[string]interface{})
-			if !ok {
-				controlPlane = map[string]interface{}{}
-				cluster["controlPlane"] = controlPlane
-			}
-			controlPlane["endpoint"] = ""
-		}
-		configOrigin, err = yaml.Marshal(&config)
+		configOrigin, err = resetPreservedFields(configOrigin)
 		if err != nil {
 			return nil, err
 		}
@@ -456,6 +546,9 @@ func applyPatchesAndRenderConfig(ctx context.Context, opts Options, configPatche
 	var target []byte
 	if opts.Full {
 		target = configFull
+	} else if opts.PatchFormat == PatchFormatJSONPatch {
+		// Comments of the source templates can't be mapped to JSON Patch operations
+		return yamltools.JSONPatch(configOrigin, configFull)
 	} else {
 		target, err = yamltools.DiffYAMLs(configOrigin, configFull)
 		if err != nil {
@@ -463,31 +556,58 @@ func applyPatchesAndRenderConfig(ctx context.Context, opts Options, configPatche
 		}
 	}
 
-	var targetNode yaml.Node
-	if err := yaml.Unmarshal(target, &targetNode); err != nil {
+	targetDocs, err := yamltools.Documents(target)
+	if err != nil {
 		return nil, err
 	}
 
 	// Copy comments from source configuration to the final output
 	for _, configPatch := range configPatches {
-		var sourceNode yaml.Node
-		if err := yaml.Unmarshal([]byte(configPatch), &sourceNode); err != nil {
+		sourceDocs, err := yamltools.Documents([]byte(configPatch))
+		if err != nil {
 			return nil, err
 		}
-		dstPaths := make(map[string]*yaml.Node)
-		yamltools.CopyComments(&sourceNode, &targetNode, "", dstPaths)
-		yamltools.ApplyComments(&targetNode, "", dstPaths)
+		yamltools.CopyDocumentsComments(sourceDocs, targetDocs)
 	}
 
-	buf := &bytes.Buffer{}
-	encoder := yaml.NewEncoder(buf)
-	encoder.SetIndent(2)
-	if err := encoder.Encode(&targetNode); err != nil {
+	return yamltools.EncodeDocuments(targetDocs)
+}
+
+// resetPreservedFields overwrites machine type, cluster name and endpoint in the
+// v1alpha1 document of the config, so they are always preserved in the diff.
+func resetPreservedFields(configOrigin []byte) ([]byte, error) {
+	docs, err := yamltools.Documents(configOrigin)
+	if err != nil {
 		return nil, err
 	}
-	encoder.Close()
 
-	return buf.Bytes(), nil
+	for _, doc := range docs {
+		if yamltools.DocumentID(doc) != yamltools.LegacyDocumentID {
+			continue
+		}
+
+		var config map[string]interface{}
+		if err := doc.Decode(&config); err != nil {
+			return nil, err
+		}
+		if machine, ok := config["machine"].(map[string]interface{}); ok {
+			machine["type"] = "unknown"
+		}
+		if cluster, ok := config["cluster"].(map[string]interface{}); ok {
+			cluster["clusterName"] = ""
+			controlPlane, ok := cluster["controlPlane"].(map[string]interface{})
+			if !ok {
+				controlPlane = map[string]interface{}{}
+				cluster["controlPlane"] = controlPlane
+			}
+			controlPlane["endpoint"] = ""
+		}
+		if err := doc.Encode(&config); err != nil {
+			return nil, err
+		}
+	}
+
+	return yamltools.EncodeDocuments(docs)
 }
 
 func readUnexportedField(field reflect.Value) any {
@@ -525,48 +645,85 @@ func extractResourceData(r resource.Resource) (map[string]interface{}, error) {
 	return res, nil
 }
 
-func newLookupFunction(ctx context.Context, c *client.Client) func(resource string, namespace string, id string) (map[string]interface{}, error) {
-	return func(kind string, namespace string, id string) (map[string]interface{}, error) {
-		var multiErr *multierror.Error
-
-		var resources []map[string]interface{}
-
-		callbackResource := func(parentCtx context.Context, hostname string, r resource.Resource, callError error) error {
-			if callError != nil {
-				multiErr = multierror.Append(multiErr, callError)
-				return nil
-			}
+// listResources fetches resources of the given kind from the node, returning all of them if id is empty.
+//
+// Errors of calls to the node don't fail the listing, they are passed to onCallError if it is set.
+func listResources(ctx context.Context, c *client.Client, kind string, namespace string, id string, onCallError func(error)) ([]map[string]interface{}, error) {
+	var resources []map[string]interface{}
 
-			res, err := extractResourceData(r)
-			if err != nil {
-				return nil
+	callbackResource := func(parentCtx context.Context, hostname string, r resource.Resource, callError error) error {
+		if callError != nil {
+			if onCallError != nil {
+				onCallError(callError)
 			}
-
-			resources = append(resources, res)
 			return nil
 		}
-		callbackRD := func(definition *meta.ResourceDefinition) error {
+
+		res, err := extractResourceData(r)
+		if err != nil {
 			return nil
 		}
 
-		helperErr := helpers.ForEachResource(ctx, c, callbackRD, callbackResource, namespace, kind, id)
-		if helperErr != nil {
-			return map[string]interface{}{}, helperErr
-		}
-		if len(resources) == 0 {
-			return map[string]interface{}{}, nil
+		resources = append(resources, res)
+		return nil
+	}
+	callbackRD := func(definition *meta.ResourceDefinition) error {
+		return nil
+	}
+
+	if err := helpers.ForEachResource(ctx, c, callbackRD, callbackResource, namespace, kind, id); err != nil {
+		return nil, err
+	}
+
+	return resources, nil
+}
+
+// lookupResult shapes found resources the same way Helm's lookup does: a single object
+// when it was requested by id, and a List otherwise.
+func lookupResult(resources []map[string]interface{}, id string) map[string]interface{} {
+	if len(resources) == 0 {
+		return map[string]interface{}{}
+	}
+	if id != "" && len(resources) == 1 {
+		return resources[0]
+	}
+	items := map[string]interface{}{}
+	for i, res := range resources {
+		items["_"+strconv.Itoa(i)] = res
+	}
+	return map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "List",
+		"items":      items,
+	}
+}
+
+func emptyLookup(string, string, string) (map[string]interface{}, error) {
+	return map[string]interface{}{}, nil
+}
+
+// strictLookup makes lookups which found nothing fail the rendering.
+func strictLookup(lookup LookupFunc) LookupFunc {
+	return func(kind string, namespace string, id string) (map[string]interface{}, error) {
+		result, err := lookup(kind, namespace, id)
+		if err != nil {
+			return result, err
 		}
-		if id != "" && len(resources) == 1 {
-			return resources[0], nil
+		if len(result) == 0 {
+			return result, fmt.Errorf("lookup %s returned no resources", lookupName(kind, namespace, id))
 		}
-		items := map[string]interface{}{}
-		for i, res := range resources {
-			items["_"+strconv.Itoa(i)] = res
+
+		return result, nil
+	}
+}
+
+func newLookupFunction(ctx context.Context, c *client.Client, onCallError func(error)) func(resource string, namespace string, id string) (map[string]interface{}, error) {
+	return func(kind string, namespace string, id string) (map[string]interface{}, error) {
+		resources, err := listResources(ctx, c, kind, namespace, id, onCallError)
+		if err != nil {
+			return map[string]interface{}{}, err
 		}
-		return map[string]interface{}{
-			"apiVersion": "v1",
-			"kind":       "List",
-			"items":      items,
-		}, nil
+
+		return lookupResult(resources, id), nil
 	}
 }
@@ -0,0 +1,82 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/siderolabs/talos/pkg/machinery/config"
+	"github.com/siderolabs/talos/pkg/machinery/config/generate/secrets"
+	"github.com/siderolabs/talos/pkg/machinery/config/machine"
+)
+
+const renderedTemplate = `machine:
+  type: controlplane
+  network:
+    hostname: node1
+  install:
+    disk: /dev/nvme0n1
+  certSANs:
+    - 10.0.0.10
+cluster:
+  clusterName: test
+  controlPlane:
+    endpoint: https://10.0.0.10:6443
+`
+
+func TestJSONPatchNodeFile(t *testing.T) {
+	secretsBundle, err := secrets.NewBundle(secrets.NewFixedClock(time.Now()), config.TalosVersionCurrent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := Options{SecretsBundle: secretsBundle, PatchFormat: PatchFormatJSONPatch}
+
+	patch, err := applyPatchesAndRenderConfig(context.Background(), opts, []string{renderedTemplate}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(patch, []byte("- op: ")) {
+		t.Fatalf("expected JSON Patch operations, got:\n%s", patch)
+	}
+
+	configFile := filepath.Join(t.TempDir(), "node1.yaml")
+	nodeFile := append([]byte("# talm: nodes=[\"10.0.0.10\"], templates=[\"templates/controlplane.yaml\"]\n"), patch...)
+	if err := os.WriteFile(configFile, nodeFile, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	configBundle, err := FullConfigProcess(context.Background(), opts, []string{"@" + configFile})
+	if err != nil {
+		t.Fatalf("failed to apply JSON Patch node file: %s", err)
+	}
+	cfg := configBundle.ControlPlaneCfg
+	if cfg.Machine().Type() != machine.TypeControlPlane {
+		t.Errorf("expected controlplane machine type, got %s", cfg.Machine().Type())
+	}
+	if cfg.Cluster().Name() != "test" || cfg.Cluster().Endpoint().String() != "https://10.0.0.10:6443" {
+		t.Errorf("unexpected cluster %s with endpoint %s", cfg.Cluster().Name(), cfg.Cluster().Endpoint())
+	}
+	if hostname := cfg.Machine().Network().Hostname(); hostname != "node1" {
+		t.Errorf("expected hostname node1, got %q", hostname)
+	}
+
+	// The result is the same as applying the rendered template itself
+	applied, err := SerializeConfiguration(configBundle, machine.TypeControlPlane)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedBundle, err := FullConfigProcess(context.Background(), opts, []string{renderedTemplate})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := SerializeConfiguration(expectedBundle, machine.TypeControlPlane)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(applied, expected) {
+		t.Errorf("config applied from JSON Patch differs from the rendered template:\n%s\nexpected:\n%s", applied, expected)
+	}
+}
@@ -0,0 +1,109 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/siderolabs/talos/pkg/machinery/client"
+)
+
+// DefaultFactKinds is the list of resource kinds looked up by the talm library chart helpers.
+var DefaultFactKinds = []string{
+	"addresses",
+	"disks",
+	"hostname",
+	"links",
+	"machinetype",
+	"nodeaddress",
+	"resolvers",
+	"routes",
+	"systemdisk",
+}
+
+// Facts is a snapshot of node resources used to serve lookups without access to the node.
+type Facts struct {
+	Node      string                              `yaml:"node,omitempty"`
+	Resources map[string][]map[string]interface{} `yaml:"resources"`
+}
+
+// CollectFacts fetches all resources of the given kinds from the node.
+func CollectFacts(ctx context.Context, c *client.Client, node string, kinds []string) (*Facts, error) {
+	return collectFacts(node, kinds, func(kind string) ([]map[string]interface{}, error) {
+		return listResources(ctx, c, kind, "", "", nil)
+	})
+}
+
+// collectFacts builds the snapshot from all resources of every kind returned by list.
+func collectFacts(node string, kinds []string, list func(kind string) ([]map[string]interface{}, error)) (*Facts, error) {
+	facts := &Facts{
+		Node:      node,
+		Resources: make(map[string][]map[string]interface{}, len(kinds)),
+	}
+
+	for _, kind := range kinds {
+		resources, err := list(kind)
+		if err != nil {
+			return nil, fmt.Errorf("failed to collect %s: %w", kind, err)
+		}
+		if resources == nil {
+			resources = []map[string]interface{}{}
+		}
+		facts.Resources[kind] = resources
+	}
+
+	return facts, nil
+}
+
+// Write encodes the snapshot in the format read by LoadFacts.
+func (f *Facts) Write(w io.Writer) error {
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(f); err != nil {
+		return err
+	}
+
+	return encoder.Close()
+}
+
+// LoadFacts reads facts snapshot from the file.
+func LoadFacts(path string) (*Facts, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read facts file %s: %w", path, err)
+	}
+
+	facts := &Facts{}
+	if err := yaml.Unmarshal(data, facts); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal facts file %s: %w", path, err)
+	}
+
+	return facts, nil
+}
+
+// Lookup implements the lookup template function on top of the snapshot.
+//
+// Kinds missing in the snapshot produce an error, so incomplete snapshots don't silently render garbage.
+func (f *Facts) Lookup(kind string, namespace string, id string) (map[string]interface{}, error) {
+	all, ok := f.Resources[kind]
+	if !ok {
+		return map[string]interface{}{}, fmt.Errorf("resource kind %q is not present in the facts snapshot", kind)
+	}
+
+	var resources []map[string]interface{}
+	for _, res := range all {
+		metadata, _ := res["metadata"].(map[string]interface{})
+		if namespace != "" && metadata["namespace"] != namespace {
+			continue
+		}
+		if id != "" && fmt.Sprint(metadata["id"]) != id {
+			continue
+		}
+		resources = append(resources, res)
+	}
+
+	return lookupResult(resources, id), nil
+}
@@ -0,0 +1,120 @@
+package engine
+
+import (
+	"bytes"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/cosi-project/runtime/pkg/resource"
+	"github.com/cosi-project/runtime/pkg/resource/protobuf"
+
+	"github.com/siderolabs/talos/pkg/machinery/resources/block"
+	"github.com/siderolabs/talos/pkg/machinery/resources/network"
+)
+
+// testNodeResources returns resources the way they are received from the node by listResources.
+func testNodeResources(t *testing.T) map[string][]map[string]interface{} {
+	t.Helper()
+
+	eth0 := network.NewAddressStatus(network.NamespaceName, "eth0/10.0.0.1/24")
+	eth0.TypedSpec().Address = netip.MustParsePrefix("10.0.0.1/24")
+	eth0.TypedSpec().LinkName = "eth0"
+	eth1 := network.NewAddressStatus(network.NamespaceName, "eth1/192.168.0.1/24")
+	eth1.TypedSpec().Address = netip.MustParsePrefix("192.168.0.1/24")
+	eth1.TypedSpec().LinkName = "eth1"
+
+	hostname := network.NewHostnameStatus(network.NamespaceName, network.HostnameID)
+	hostname.TypedSpec().Hostname = "node1"
+
+	disk := block.NewDisk(block.NamespaceName, "sda")
+	disk.TypedSpec().Size = 10737418240
+	disk.TypedSpec().Model = "QEMU HARDDISK"
+
+	resources := map[string][]map[string]interface{}{}
+	for kind, items := range map[string][]resource.Resource{
+		"addresses": {eth0, eth1},
+		"hostname":  {hostname},
+		"disks":     {disk},
+	} {
+		for _, item := range items {
+			received, err := protobuf.FromResource(item)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res, err := extractResourceData(received)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resources[kind] = append(resources[kind], res)
+		}
+	}
+
+	return resources
+}
+
+func TestFactsLookup(t *testing.T) {
+	resources := testNodeResources(t)
+
+	facts, err := collectFacts("10.0.0.1", []string{"addresses", "hostname", "disks", "routes"}, func(kind string) ([]map[string]interface{}, error) {
+		return resources[kind], nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Snapshots are used after they are written by 'talm facts collect'
+	var buf bytes.Buffer
+	if err = facts.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "facts.yaml")
+	if err = os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadFacts(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Node != "10.0.0.1" {
+		t.Errorf("unexpected node %q", loaded.Node)
+	}
+
+	testCases := []struct {
+		kind, namespace, id string
+		want                map[string]interface{}
+	}{
+		// A single resource requested by id is returned as is, as by the live lookup
+		{"hostname", "", network.HostnameID, resources["hostname"][0]},
+		{"addresses", network.NamespaceName, "eth1/192.168.0.1/24", resources["addresses"][1]},
+		// Other lookups return a List
+		{"addresses", "", "", lookupResult(resources["addresses"], "")},
+		{"addresses", network.NamespaceName, "", lookupResult(resources["addresses"], "")},
+		{"disks", "", "", lookupResult(resources["disks"], "")},
+		// Nothing found
+		{"addresses", block.NamespaceName, "", map[string]interface{}{}},
+		{"addresses", "", "eth2/10.0.0.2/24", map[string]interface{}{}},
+		{"routes", "", "", map[string]interface{}{}},
+	}
+
+	for _, tc := range testCases {
+		got, err := loaded.Lookup(tc.kind, tc.namespace, tc.id)
+		if err != nil {
+			t.Errorf("Lookup(%q, %q, %q): %s", tc.kind, tc.namespace, tc.id, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("Lookup(%q, %q, %q): got %v, want %v", tc.kind, tc.namespace, tc.id, got, tc.want)
+		}
+	}
+
+	if items, ok := lookupResult(resources["addresses"], "")["items"].(map[string]interface{}); !ok || len(items) != 2 {
+		t.Errorf("unexpected list shape %v", items)
+	}
+
+	if _, err = loaded.Lookup("links", "", ""); err == nil {
+		t.Error("expected an error for a kind missing in the snapshot")
+	}
+}
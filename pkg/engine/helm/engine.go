@@ -36,6 +36,12 @@ var Disks map[string]interface{} = map[string]interface{}{}
 var LookupFunc func(resource string, namespace string, name string) (map[string]interface{}, error) = func(string, string, string) (map[string]interface{}, error) {
 	return map[string]interface{}{}, nil
 }
+var LookupListFunc func(resource string, namespace string, selectors ...string) ([]interface{}, error) = func(string, string, ...string) ([]interface{}, error) {
+	return []interface{}{}, nil
+}
+var K8sLookupFunc func(apiVersion string, kind string, namespace string, name string) (map[string]interface{}, error) = func(string, string, string, string) (map[string]interface{}, error) {
+	return map[string]interface{}{}, nil
+}
 
 // Engine is an implementation of the Helm rendering implementation for templates.
 type Engine struct {
@@ -44,6 +50,9 @@ type Engine struct {
 	Strict bool
 	// In LintMode, some 'required' template values may be missing, so don't fail
 	LintMode bool
+	// LintReporter receives problems ignored in LintMode together with the name of
+	// the template being rendered. If it is not set, problems are logged.
+	LintReporter func(template string, msg string)
 	// EnableDNS tells the engine to allow DNS lookups when rendering templates
 	EnableDNS bool
 }
@@ -164,7 +173,7 @@ func tplFun(parent *template.Template, includedNames map[string]int, strict bool
 }
 
 // initFunMap creates the Engine's FuncMap and adds context-specific functions.
-func (e Engine) initFunMap(t *template.Template) {
+func (e Engine) initFunMap(t *template.Template, current *string) {
 	funcMap := funcMap()
 	includedNames := make(map[string]int)
 
@@ -172,12 +181,20 @@ func (e Engine) initFunMap(t *template.Template) {
 	funcMap["include"] = includeFun(t, includedNames)
 	funcMap["tpl"] = tplFun(t, includedNames, e.Strict)
 
+	lintReport := func(msg string) {
+		if e.LintReporter != nil {
+			e.LintReporter(*current, msg)
+			return
+		}
+		log.Printf("[INFO] %s", msg)
+	}
+
 	// Add the `required` function here so we can use lintMode
 	funcMap["required"] = func(warn string, val interface{}) (interface{}, error) {
 		if val == nil {
 			if e.LintMode {
 				// Don't fail on missing required values when linting
-				log.Printf("[INFO] Missing required value: %s", warn)
+				lintReport("Missing required value: " + warn)
 				return "", nil
 			}
 			return val, errors.Errorf(warnWrap(warn))
@@ -185,7 +202,7 @@ func (e Engine) initFunMap(t *template.Template) {
 			if val == "" {
 				if e.LintMode {
 					// Don't fail on missing required values when linting
-					log.Printf("[INFO] Missing required value: %s", warn)
+					lintReport("Missing required value: " + warn)
 					return "", nil
 				}
 				return val, errors.Errorf(warnWrap(warn))
@@ -198,17 +215,24 @@ func (e Engine) initFunMap(t *template.Template) {
 	funcMap["fail"] = func(msg string) (string, error) {
 		if e.LintMode {
 			// Don't fail when linting
-			log.Printf("[INFO] Fail: %s", msg)
+			lintReport("Fail: " + msg)
 			return "", nil
 		}
 		return "", errors.New(warnWrap(msg))
 	}
 
-	// If we are not linting and have a cluster connection, provide a Kubernetes-backed
-	// implementation.
-	if !e.LintMode {
-		funcMap["lookup"] = LookupFunc
+	// Lookups are served by the caller, e.g. from a facts snapshot when linting.
+	// Kubernetes objects are never looked up when linting.
+	funcMap["lookup"] = LookupFunc
+	funcMap["lookupList"] = LookupListFunc
+	if e.LintMode {
+		funcMap["k8sLookup"] = func(string, string, string, string) (map[string]interface{}, error) {
+			return map[string]interface{}{}, nil
+		}
+	} else {
+		funcMap["k8sLookup"] = K8sLookupFunc
 	}
+	funcMap["lookupAll"] = funcMap["lookupList"]
 
 	// When DNS lookups are not enabled override the sprig function and return
 	// an empty string.
@@ -244,7 +268,8 @@ func (e Engine) render(tpls map[string]renderable) (rendered map[string]string,
 		t.Option("missingkey=zero")
 	}
 
-	e.initFunMap(t)
+	var current string
+	e.initFunMap(t, &current)
 
 	// We want to parse the templates in a predictable order. The order favors
 	// higher-level (in file system) templates over deeply nested templates.
@@ -265,6 +290,7 @@ func (e Engine) render(tpls map[string]renderable) (rendered map[string]string,
 			continue
 		}
 		// At render time, add information about the template that is being rendered.
+		current = filename
 		vals := tpls[filename].vals
 		vals["Template"] = chartutil.Values{"Name": filename, "BasePath": tpls[filename].basePath}
 		var buf strings.Builder
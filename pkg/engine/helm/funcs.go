@@ -66,6 +66,15 @@ func funcMap() template.FuncMap {
 		"lookup": func(string, string, string, string) (map[string]interface{}, error) {
 			return map[string]interface{}{}, nil
 		},
+		"lookupList": func(string, string, ...string) ([]interface{}, error) {
+			return []interface{}{}, nil
+		},
+		"lookupAll": func(string, string, ...string) ([]interface{}, error) {
+			return []interface{}{}, nil
+		},
+		"k8sLookup": func(string, string, string, string) (map[string]interface{}, error) {
+			return map[string]interface{}{}, nil
+		},
 	}
 
 	for k, v := range extra {
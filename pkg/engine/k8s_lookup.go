@@ -0,0 +1,104 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	helmlookup "helm.sh/helm/v3/pkg/engine"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/siderolabs/talos/pkg/machinery/client"
+)
+
+// K8sLookupFunc is the signature of the k8sLookup template function, the same as of Helm's lookup.
+type K8sLookupFunc func(apiVersion string, kind string, namespace string, name string) (map[string]interface{}, error)
+
+// newK8sLookupFunction returns Helm's lookup function querying the Kubernetes API of the cluster.
+//
+// The kubeconfig is read from kubeconfigPath or, if it's empty, fetched from the node through the Talos API.
+// The client is created on the first lookup, so templates which don't use k8sLookup don't need the cluster.
+func newK8sLookupFunction(ctx context.Context, c *client.Client, kubeconfigPath string) K8sLookupFunc {
+	var (
+		once    sync.Once
+		lookup  K8sLookupFunc
+		initErr error
+	)
+
+	return func(apiVersion string, kind string, namespace string, name string) (map[string]interface{}, error) {
+		once.Do(func() {
+			var restConfig *rest.Config
+			restConfig, initErr = k8sRESTConfig(ctx, c, kubeconfigPath)
+			if initErr == nil {
+				lookup = helmlookup.NewLookupFunction(restConfig)
+			}
+		})
+		if initErr != nil {
+			return map[string]interface{}{}, initErr
+		}
+
+		result, err := lookup(apiVersion, kind, namespace, name)
+		if err != nil {
+			return result, fmt.Errorf("k8sLookup %s %s: %w", apiVersion, kind, err)
+		}
+		return result, nil
+	}
+}
+
+func k8sRESTConfig(ctx context.Context, c *client.Client, kubeconfigPath string) (*rest.Config, error) {
+	if kubeconfigPath != "" {
+		restConfig, err := clientcmd.BuildConfigFromFlags("", kubeconfigPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load kubeconfig %s: %w", kubeconfigPath, err)
+		}
+		return restConfig, nil
+	}
+
+	if c == nil {
+		return nil, fmt.Errorf("k8sLookup requires a kubeconfig file when rendering without access to the node")
+	}
+
+	data, err := c.Kubeconfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error getting kubeconfig from the node: %w", err)
+	}
+
+	restConfig, err := clientcmd.RESTConfigFromKubeConfig(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse kubeconfig from the node: %w", err)
+	}
+	return restConfig, nil
+}
+
+// wrapK8s serves repeated Kubernetes lookups from the cache, the same way as lookups of the node.
+func (lc *lookupCache) wrapK8s(lookup K8sLookupFunc) K8sLookupFunc {
+	cached := lc.wrap(func(kind string, namespace string, name string) (map[string]interface{}, error) {
+		apiVersion, kind, _ := strings.Cut(kind, " ")
+		return lookup(apiVersion, kind, namespace, name)
+	})
+
+	return func(apiVersion string, kind string, namespace string, name string) (map[string]interface{}, error) {
+		return cached(apiVersion+" "+kind, namespace, name)
+	}
+}
+
+func disabledK8sLookup(string, string, string, string) (map[string]interface{}, error) {
+	return map[string]interface{}{}, fmt.Errorf("k8sLookup is disabled, enable it with --k8s-lookup")
+}
+
+// strictK8sLookup makes Kubernetes lookups which found nothing fail the rendering.
+func strictK8sLookup(lookup K8sLookupFunc) K8sLookupFunc {
+	return func(apiVersion string, kind string, namespace string, name string) (map[string]interface{}, error) {
+		result, err := lookup(apiVersion, kind, namespace, name)
+		if err != nil {
+			return result, err
+		}
+		if len(result) == 0 {
+			return result, fmt.Errorf("k8sLookup %s %s (namespace=%s, name=%s) returned no objects", apiVersion, kind, namespace, name)
+		}
+
+		return result, nil
+	}
+}
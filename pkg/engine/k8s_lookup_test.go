@@ -0,0 +1,45 @@
+package engine
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestK8sLookupCache(t *testing.T) {
+	var calls []string
+	lookup := func(apiVersion string, kind string, namespace string, name string) (map[string]interface{}, error) {
+		calls = append(calls, apiVersion+" "+kind+" "+namespace+"/"+name)
+		return map[string]interface{}{"metadata": map[string]interface{}{"name": name}}, nil
+	}
+
+	cached := newLookupCache(nil).wrapK8s(lookup)
+	for range 2 {
+		for _, kind := range []string{"Deployment", "StatefulSet"} {
+			result, err := cached("apps/v1", kind, "kube-system", "coredns")
+			if err != nil {
+				t.Fatal(err)
+			}
+			result["metadata"] = nil
+		}
+	}
+
+	expected := []string{"apps/v1 Deployment kube-system/coredns", "apps/v1 StatefulSet kube-system/coredns"}
+	if strings.Join(calls, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected calls %v, got %v", expected, calls)
+	}
+}
+
+func TestK8sLookupNotRecorded(t *testing.T) {
+	for _, opts := range []Options{
+		{K8sLookup: true, Offline: true},
+		{K8sLookup: true, Facts: "facts/node1.yaml"},
+		{K8sLookup: true, LockFile: "node1.lock.yaml"},
+		{K8sLookup: true, LockFile: "node1.lock.yaml", Locked: true},
+	} {
+		_, err := Render(context.Background(), nil, opts)
+		if err == nil || !strings.Contains(err.Error(), "k8sLookup") {
+			t.Errorf("expected k8sLookup to be rejected with %+v, got %v", opts, err)
+		}
+	}
+}
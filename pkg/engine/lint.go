@@ -0,0 +1,373 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/go-multierror"
+	"gopkg.in/yaml.v3"
+	"helm.sh/helm/v3/pkg/chart/loader"
+
+	helmEngine "github.com/aenix-io/talm/pkg/engine/helm"
+	"github.com/aenix-io/talm/pkg/yamltools"
+
+	"github.com/siderolabs/talos/pkg/machinery/config"
+	"github.com/siderolabs/talos/pkg/machinery/config/configpatcher"
+	"github.com/siderolabs/talos/pkg/machinery/config/machine"
+	"github.com/siderolabs/talos/pkg/machinery/config/validation"
+)
+
+// Lint message severities.
+const (
+	LintError   = "ERROR"
+	LintWarning = "WARNING"
+)
+
+// LintMessage is a single problem found by Lint.
+type LintMessage struct {
+	Severity string
+	File     string
+	Line     int // 0 if the line is unknown
+	Message  string
+}
+
+func (m LintMessage) String() string {
+	location := m.File
+	if m.Line > 0 {
+		location = fmt.Sprintf("%s:%d", m.File, m.Line)
+	}
+	return fmt.Sprintf("[%s] %s: %s", m.Severity, location, m.Message)
+}
+
+// LintModes lists runtime modes supported by the Talos config validator.
+var LintModes = []string{"metal", "cloud", "container"}
+
+// lintMode implements validation.RuntimeMode, Talos keeps its own implementation internal.
+type lintMode string
+
+func (m lintMode) String() string {
+	return string(m)
+}
+
+func (m lintMode) RequiresInstall() bool {
+	return m == "metal"
+}
+
+func (m lintMode) InContainer() bool {
+	return m == "container"
+}
+
+// Lint renders every template of the chart in lint mode and validates the result
+// with the Talos config validator, so missing values, unknown fields and
+// deprecated options are reported without access to the nodes.
+//
+// Lookups are served from opts.Facts if it's set. Without facts they find nothing,
+// so errors about settings the templates discover on the node are reported as warnings.
+//
+// Node files are validated the same way as rendered templates.
+func Lint(opts Options, mode string, nodeFiles []string) ([]LintMessage, error) {
+	var runtimeMode validation.RuntimeMode
+	for _, m := range LintModes {
+		if m == mode {
+			runtimeMode = lintMode(mode)
+		}
+	}
+	if runtimeMode == nil {
+		return nil, fmt.Errorf("unknown mode %q, valid values are %s", mode, strings.Join(LintModes, ", "))
+	}
+
+	chartPath, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	if opts.Root != "" {
+		chartPath = opts.Root
+	}
+
+	chrt, err := loader.LoadDir(chartPath)
+	if err != nil {
+		return nil, err
+	}
+
+	values, err := loadValues(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	rootValues := map[string]interface{}{
+		"Values": mergeMaps(chrt.Values, values),
+	}
+
+	// Lookups find nothing unless they are served from a facts snapshot
+	lookup := LookupFunc(emptyLookup)
+	if opts.Facts != "" {
+		facts, err := LoadFacts(opts.Facts)
+		if err != nil {
+			return nil, err
+		}
+		lookup = facts.Lookup
+		if opts.Strict {
+			lookup = strictLookup(lookup)
+		}
+	}
+
+	renderMu.Lock()
+	defer renderMu.Unlock()
+	helmEngine.LookupFunc = lookup
+	helmEngine.LookupListFunc = listLookup(lookup)
+
+	var messages []LintMessage
+
+	eng := helmEngine.Engine{
+		LintMode: true,
+		Strict:   opts.Strict,
+		LintReporter: func(template string, msg string) {
+			messages = append(messages, LintMessage{Severity: LintError, File: template, Message: msg})
+		},
+	}
+	out, err := eng.Render(chrt, rootValues)
+	if err != nil {
+		file, line := templateErrorLocation(err.Error())
+		if file == "" {
+			file = chrt.Name()
+		}
+		return append(messages, LintMessage{Severity: LintError, File: file, Line: line, Message: err.Error()}), nil
+	}
+
+	templates := make([]string, 0, len(out))
+	if len(opts.TemplateFiles) > 0 {
+		for _, templateFile := range opts.TemplateFiles {
+			requestedTemplate := path.Join(chrt.Name(), templateFile)
+			if _, ok := out[requestedTemplate]; !ok {
+				return nil, fmt.Errorf("template %s not found", templateFile)
+			}
+			templates = append(templates, requestedTemplate)
+		}
+	} else {
+		for name := range out {
+			templates = append(templates, name)
+		}
+		sort.Strings(templates)
+	}
+
+	for _, name := range templates {
+		if strings.TrimSpace(out[name]) == "" {
+			continue
+		}
+		messages = append(messages, lintConfigPatch(opts, runtimeMode, name, out[name])...)
+	}
+
+	for _, nodeFile := range nodeFiles {
+		data, err := os.ReadFile(nodeFile)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, lintConfigPatch(opts, runtimeMode, nodeFile, string(data))...)
+	}
+
+	return messages, nil
+}
+
+// lintConfigPatch applies the patch to the generated config and validates the result.
+//
+// Patches which don't set machine.type are validated both as controlplane and as worker.
+func lintConfigPatch(opts Options, mode validation.RuntimeMode, file string, patch string) []LintMessage {
+	docs, err := yamltools.Documents([]byte(patch))
+	if err != nil {
+		line := 0
+		if m := yamlErrorLineRegexp.FindStringSubmatch(err.Error()); m != nil {
+			line, _ = strconv.Atoi(m[1])
+		}
+		return []LintMessage{{Severity: LintError, File: file, Line: line, Message: err.Error()}}
+	}
+
+	message := func(severity string, msg string) LintMessage {
+		return LintMessage{Severity: severity, File: file, Line: patchLine(docs, msg), Message: msg}
+	}
+	lintErr := func(err error) []LintMessage {
+		return []LintMessage{message(LintError, err.Error())}
+	}
+
+	// Machine type is taken from the v1alpha1 document, JSON patches don't have it
+	var document map[string]interface{}
+	for _, doc := range docs {
+		if yamltools.DocumentID(doc) == yamltools.LegacyDocumentID && doc.Content[0].Kind == yaml.MappingNode {
+			if err := doc.Decode(&document); err != nil {
+				return lintErr(err)
+			}
+		}
+	}
+
+	machineTypes := []machine.Type{machine.TypeControlPlane, machine.TypeWorker}
+	if m, ok := document["machine"].(map[string]interface{}); ok {
+		if t, ok := m["type"].(string); ok && t != "" {
+			machineType, err := machine.ParseType(t)
+			if err != nil {
+				return lintErr(err)
+			}
+			switch {
+			case machineType.IsControlPlane():
+				machineTypes = []machine.Type{machine.TypeControlPlane}
+			case machineType == machine.TypeWorker:
+				machineTypes = []machine.Type{machine.TypeWorker}
+			}
+		}
+	}
+
+	patches, err := configpatcher.LoadPatches([]string{patch})
+	if err != nil {
+		return lintErr(err)
+	}
+
+	configBundle, err := InitializeConfigBundle(Options{
+		TalosVersion:      opts.TalosVersion,
+		WithSecrets:       opts.WithSecrets,
+		KubernetesVersion: opts.KubernetesVersion,
+		ClusterName:       "talm-lint",
+		Endpoint:          "https://127.0.0.1:6443",
+	})
+	if err != nil {
+		return lintErr(err)
+	}
+
+	// Patches can be valid only for the machine type they are written for, e.g. delete a controlplane-only field
+	patchControlPlane := slices.Contains(machineTypes, machine.TypeControlPlane)
+	patchWorker := slices.Contains(machineTypes, machine.TypeWorker)
+	if err := configBundle.ApplyPatches(patches, patchControlPlane, patchWorker); err != nil {
+		return lintErr(err)
+	}
+
+	var messages []LintMessage
+	for _, machineType := range machineTypes {
+		var cfg config.Provider
+		if machineType == machine.TypeControlPlane {
+			cfg = configBundle.ControlPlaneCfg
+		} else {
+			cfg = configBundle.WorkerCfg
+		}
+
+		warnings, err := cfg.Validate(mode, validation.WithLocal())
+		for _, warning := range warnings {
+			messages = append(messages, message(LintWarning, fmt.Sprintf("%s: %s", machineType, warning)))
+		}
+		if err == nil {
+			continue
+		}
+
+		errs := []error{err}
+		var merr *multierror.Error
+		if errors.As(err, &merr) {
+			errs = merr.Errors
+		}
+		for _, err := range errs {
+			severity := LintError
+			if opts.Facts == "" && discoveredSettingError(err) {
+				severity = LintWarning
+			}
+			messages = append(messages, message(severity, fmt.Sprintf("%s: %s", machineType, err)))
+		}
+	}
+
+	return messages
+}
+
+// discoveredSettingErrors are validation errors about settings the library chart discovers on the node:
+// the install disk and the network interfaces.
+var discoveredSettingErrors = []string{
+	"either install disk or diskSelector should be defined",
+	"[networking.os.device.interface], [networking.os.device.deviceSelector]: required either config section to be set",
+}
+
+// discoveredSettingError reports whether the error can be caused by lookups which found nothing.
+func discoveredSettingError(err error) bool {
+	for _, msg := range discoveredSettingErrors {
+		if strings.Contains(err.Error(), msg) {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	// templateErrorRegexp matches locations of template errors, e.g. "template: talm/templates/worker.yaml:12:5:"
+	// or "parse error at (talm/templates/worker.yaml:12):".
+	templateErrorRegexp = regexp.MustCompile(`(?:template: |at \()([^\s():]+):(\d+)`)
+	yamlErrorLineRegexp = regexp.MustCompile(`\byaml: line (\d+):`)
+	// configPathRegexp matches paths of config fields in validator messages, e.g. ".machine.ca.key" or "machine.disks[0]".
+	configPathRegexp  = regexp.MustCompile(`\b(?:machine|cluster)(?:\.[A-Za-z0-9]+|\[[^\]]+\])+`)
+	pathElementRegexp = regexp.MustCompile(`[^.\[\]]+|\[[^\]]+\]`)
+)
+
+const unknownKeysPrefix = "unknown keys found during decoding:\n"
+
+// templateErrorLocation returns the template and the line of the template error.
+func templateErrorLocation(msg string) (string, int) {
+	m := templateErrorRegexp.FindStringSubmatch(msg)
+	if m == nil {
+		return "", 0
+	}
+	line, _ := strconv.Atoi(m[2])
+	return m[1], line
+}
+
+// patchLine returns the line of the field of the v1alpha1 document the message refers to,
+// either by its path or by the unknown keys reported by the config decoder.
+func patchLine(docs []*yaml.Node, msg string) int {
+	var path []string
+	if i := strings.Index(msg, unknownKeysPrefix); i >= 0 {
+		var unknown yaml.Node
+		if err := yaml.Unmarshal([]byte(msg[i+len(unknownKeysPrefix):]), &unknown); err == nil && len(unknown.Content) > 0 {
+			for node := unknown.Content[0]; node.Kind == yaml.MappingNode && len(node.Content) > 1; node = node.Content[1] {
+				path = append(path, node.Content[0].Value)
+			}
+		}
+	} else if m := configPathRegexp.FindString(msg); m != "" {
+		path = pathElementRegexp.FindAllString(m, -1)
+	}
+	if len(path) == 0 {
+		return 0
+	}
+
+	for _, doc := range docs {
+		if yamltools.DocumentID(doc) == yamltools.LegacyDocumentID {
+			return nodeLine(doc.Content[0], path)
+		}
+	}
+	return 0
+}
+
+// nodeLine returns the line of the deepest element of the path found in the node.
+func nodeLine(node *yaml.Node, path []string) int {
+	line := 0
+	for _, elem := range path {
+		var next *yaml.Node
+		switch {
+		case node.Kind == yaml.MappingNode:
+			key := strings.Trim(elem, `[]"`)
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				if node.Content[i].Value == key {
+					line = node.Content[i].Line
+					next = node.Content[i+1]
+					break
+				}
+			}
+		case node.Kind == yaml.SequenceNode && strings.HasPrefix(elem, "["):
+			if i, err := strconv.Atoi(strings.Trim(elem, "[]")); err == nil && i >= 0 && i < len(node.Content) {
+				next = node.Content[i]
+				line = next.Line
+			}
+		}
+		if next == nil {
+			break
+		}
+		node = next
+	}
+	return line
+}
@@ -0,0 +1,76 @@
+package engine
+
+import (
+	"testing"
+
+	"github.com/aenix-io/talm/pkg/yamltools"
+)
+
+const lintPatch = `machine:
+  type: worker
+  network:
+    hostname: node1
+    foo: bar
+  disks:
+    - device: /dev/sdb
+    - device: /dev/sdc
+cluster:
+  network:
+    dnsDomain: "-invalid"
+`
+
+func TestPatchLine(t *testing.T) {
+	docs, err := yamltools.Documents([]byte(lintPatch))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		msg  string
+		line int
+	}{
+		{"worker: machine.disks[1] is null", 8},
+		{"worker: issuing CA key is not allowed on non-controlplane nodes (.machine.ca)", 1},
+		{"controlplane: \".cluster.network.dnsDomain\" is not a valid DNS name", 11},
+		{"unknown keys found during decoding:\nmachine:\n    network:\n        foo: bar\n", 5},
+		{"cluster controlplane endpoint is required", 0},
+	}
+
+	for _, tc := range testCases {
+		if line := patchLine(docs, tc.msg); line != tc.line {
+			t.Errorf("patchLine(%q): expected line %d, got %d", tc.msg, tc.line, line)
+		}
+	}
+}
+
+func TestTemplateErrorLocation(t *testing.T) {
+	testCases := []struct {
+		msg  string
+		file string
+		line int
+	}{
+		{`template: talm/templates/worker.yaml:12:5: executing "talm/templates/worker.yaml" at <.Values.foo>: nil pointer`, "talm/templates/worker.yaml", 12},
+		{`parse error at (talm/templates/_helpers.tpl:3): unexpected "}" in operand`, "talm/templates/_helpers.tpl", 3},
+		{`execution error at (talm/templates/controlplane.yaml:7:4): endpoint is required`, "talm/templates/controlplane.yaml", 7},
+		{`chart metadata is missing`, "", 0},
+	}
+
+	for _, tc := range testCases {
+		file, line := templateErrorLocation(tc.msg)
+		if file != tc.file || line != tc.line {
+			t.Errorf("templateErrorLocation(%q): expected %s:%d, got %s:%d", tc.msg, tc.file, tc.line, file, line)
+		}
+	}
+}
+
+func TestLintMessageString(t *testing.T) {
+	m := LintMessage{Severity: LintError, File: "nodes/node1.yaml", Line: 5, Message: "invalid"}
+	if s := m.String(); s != "[ERROR] nodes/node1.yaml:5: invalid" {
+		t.Errorf("unexpected message %q", s)
+	}
+
+	m.Line = 0
+	if s := m.String(); s != "[ERROR] nodes/node1.yaml: invalid" {
+		t.Errorf("unexpected message %q", s)
+	}
+}
@@ -0,0 +1,198 @@
+package engine
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// LookupFunc is the signature of the lookup template function.
+type LookupFunc func(kind string, namespace string, id string) (map[string]interface{}, error)
+
+// LockEntry is a single lookup recorded during rendering.
+type LockEntry struct {
+	Kind      string                 `yaml:"kind"`
+	Namespace string                 `yaml:"namespace,omitempty"`
+	ID        string                 `yaml:"id,omitempty"`
+	Hash      string                 `yaml:"hash"`
+	Result    map[string]interface{} `yaml:"result"`
+}
+
+// Lock holds all lookups resolved while rendering a node file.
+type Lock struct {
+	Lookups []LockEntry `yaml:"lookups"`
+}
+
+// lockDir keeps lock files out of nodes/, so globs over node files don't pick them up.
+var lockDir = filepath.Join(".talm", "locks")
+
+// LockFilePath returns path of the lock file for the node file, e.g. .talm/locks/nodes/node1.yaml.
+func LockFilePath(root string, configFile string) string {
+	rel := configFile
+	if abs, err := filepath.Abs(configFile); err == nil {
+		if absRoot, err := filepath.Abs(root); err == nil {
+			if r, err := filepath.Rel(absRoot, abs); err == nil {
+				rel = r
+			}
+		}
+	}
+	if filepath.IsAbs(rel) || strings.HasPrefix(rel, "..") {
+		rel = filepath.Base(rel)
+	}
+	return filepath.Join(root, lockDir, rel)
+}
+
+// LoadLock reads the lock file.
+func LoadLock(path string) (*Lock, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read lock file %s: %w", path, err)
+	}
+
+	lock := &Lock{}
+	if err := yaml.Unmarshal(data, lock); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal lock file %s: %w", path, err)
+	}
+
+	return lock, nil
+}
+
+// Save writes the lock file.
+func (l *Lock) Save(path string) error {
+	data, err := yaml.Marshal(l)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+func (l *Lock) find(kind string, namespace string, id string) *LockEntry {
+	for i := range l.Lookups {
+		entry := &l.Lookups[i]
+		if entry.Kind == kind && entry.Namespace == namespace && entry.ID == id {
+			return entry
+		}
+	}
+	return nil
+}
+
+// recorder wraps lookup function and records every resolved lookup into the lock.
+func (l *Lock) recorder(lookup LookupFunc) LookupFunc {
+	return func(kind string, namespace string, id string) (map[string]interface{}, error) {
+		result, err := lookup(kind, namespace, id)
+		if err != nil {
+			return result, err
+		}
+
+		if l.find(kind, namespace, id) == nil {
+			hash, err := hashLookupResult(result)
+			if err != nil {
+				return result, err
+			}
+			l.Lookups = append(l.Lookups, LockEntry{
+				Kind:      kind,
+				Namespace: namespace,
+				ID:        id,
+				Hash:      hash,
+				Result:    result,
+			})
+		}
+
+		return result, nil
+	}
+}
+
+// locked serves lookups from the recorded answers.
+//
+// If the live lookup function is given, every answer is verified against the node
+// and rendering fails when the node doesn't match the lock anymore.
+func (l *Lock) locked(live LookupFunc) LookupFunc {
+	return func(kind string, namespace string, id string) (map[string]interface{}, error) {
+		entry := l.find(kind, namespace, id)
+		if entry == nil {
+			return map[string]interface{}{}, fmt.Errorf("lookup %s is not recorded in the lock file, re-render with --lock to update it", lookupName(kind, namespace, id))
+		}
+
+		if live != nil {
+			result, err := live(kind, namespace, id)
+			if err != nil {
+				return map[string]interface{}{}, err
+			}
+
+			hash, err := hashLookupResult(result)
+			if err != nil {
+				return map[string]interface{}{}, err
+			}
+
+			if hash != entry.Hash {
+				return map[string]interface{}{}, fmt.Errorf("lookup %s differs from the lock file (locked %s, node %s), re-render with --lock to accept the change", lookupName(kind, namespace, id), entry.Hash, hash)
+			}
+		}
+
+		if entry.Result == nil {
+			return map[string]interface{}{}, nil
+		}
+
+		return entry.Result, nil
+	}
+}
+
+// volatileMetadataFields are changed by the node on every resource update, so they are ignored in hashes.
+var volatileMetadataFields = []string{"version", "created", "updated"}
+
+// hashLookupResult calculates content hash of the lookup result ignoring volatile metadata.
+func hashLookupResult(result map[string]interface{}) (string, error) {
+	// Round-trip through JSON to get a deep copy which is safe to modify
+	data, err := json.Marshal(result)
+	if err != nil {
+		return "", err
+	}
+
+	var normalized map[string]interface{}
+	if err := json.Unmarshal(data, &normalized); err != nil {
+		return "", err
+	}
+
+	stripVolatileMetadata(normalized)
+	if items, ok := normalized["items"].(map[string]interface{}); ok {
+		for _, item := range items {
+			if res, ok := item.(map[string]interface{}); ok {
+				stripVolatileMetadata(res)
+			}
+		}
+	}
+
+	data, err = json.Marshal(normalized)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(data)
+
+	return "sha256:" + hex.EncodeToString(sum[:]), nil
+}
+
+func stripVolatileMetadata(res map[string]interface{}) {
+	metadata, ok := res["metadata"].(map[string]interface{})
+	if !ok {
+		return
+	}
+
+	for _, field := range volatileMetadataFields {
+		delete(metadata, field)
+	}
+}
+
+func lookupName(kind string, namespace string, id string) string {
+	return fmt.Sprintf("(kind=%q, namespace=%q, id=%q)", kind, namespace, id)
+}
@@ -0,0 +1,65 @@
+package engine
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLockFilePath(t *testing.T) {
+	root := t.TempDir()
+
+	testCases := []struct {
+		configFile string
+		expected   string
+	}{
+		{filepath.Join(root, "nodes", "node1.yaml"), filepath.Join(root, ".talm", "locks", "nodes", "node1.yaml")},
+		{filepath.Join(root, "nodes", "rack1", "node1.yaml"), filepath.Join(root, ".talm", "locks", "nodes", "rack1", "node1.yaml")},
+		{filepath.Join(t.TempDir(), "node1.yaml"), filepath.Join(root, ".talm", "locks", "node1.yaml")},
+	}
+
+	for _, tc := range testCases {
+		if got := LockFilePath(root, tc.configFile); got != tc.expected {
+			t.Errorf("LockFilePath(%q): expected %q, got %q", tc.configFile, tc.expected, got)
+		}
+	}
+}
+
+func TestLockFilesOutsideOfNodes(t *testing.T) {
+	root := t.TempDir()
+	nodesDir := filepath.Join(root, "nodes")
+	if err := os.MkdirAll(nodesDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	var nodeFiles []string
+	for _, name := range []string{"node1.yaml", "node2.yaml"} {
+		configFile := filepath.Join(nodesDir, name)
+		if err := os.WriteFile(configFile, []byte("# talm: nodes=[\"1.2.3.4\"]\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		nodeFiles = append(nodeFiles, configFile)
+
+		lock := &Lock{Lookups: []LockEntry{{Kind: "hostname", Hash: "0", Result: map[string]interface{}{}}}}
+		if err := lock.Save(LockFilePath(root, configFile)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	found, err := filepath.Glob(filepath.Join(nodesDir, "*.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(found, nodeFiles) {
+		t.Errorf("expected only node files %v, got %v", nodeFiles, found)
+	}
+
+	lock, err := LoadLock(LockFilePath(root, nodeFiles[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lock.Lookups) != 1 || lock.Lookups[0].Kind != "hostname" {
+		t.Errorf("unexpected lock %+v", lock)
+	}
+}
@@ -0,0 +1,306 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LookupListFunc is the signature of the lookupList template function.
+type LookupListFunc func(kind string, namespace string, selectors ...string) ([]interface{}, error)
+
+// listLookup builds the lookupList function on top of the lookup function, so lookups served
+// from facts or lock files and strict mode work the same way for both of them.
+//
+// Resources are returned as a list in the order of the node, optionally filtered by selectors.
+func listLookup(lookup LookupFunc) LookupListFunc {
+	return func(kind string, namespace string, selectors ...string) ([]interface{}, error) {
+		var requirements []selectorRequirement
+		for _, selector := range selectors {
+			parsed, err := parseResourceSelector(selector)
+			if err != nil {
+				return []interface{}{}, err
+			}
+			requirements = append(requirements, parsed...)
+		}
+
+		result, err := lookup(kind, namespace, "")
+		if err != nil {
+			return []interface{}{}, err
+		}
+
+		resources := []interface{}{}
+		for _, res := range lookupItems(result) {
+			if matchResource(res, requirements) {
+				resources = append(resources, res)
+			}
+		}
+		return resources, nil
+	}
+}
+
+// lookupItems converts the result of the lookup function back to the list of resources,
+// items of List results are ordered by their index.
+func lookupItems(result map[string]interface{}) []map[string]interface{} {
+	if len(result) == 0 {
+		return nil
+	}
+
+	items, ok := result["items"].(map[string]interface{})
+	if !ok || result["kind"] != "List" {
+		return []map[string]interface{}{result}
+	}
+
+	keys := make([]string, 0, len(items))
+	for key := range items {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, errA := strconv.Atoi(strings.TrimPrefix(keys[i], "_"))
+		b, errB := strconv.Atoi(strings.TrimPrefix(keys[j], "_"))
+		if errA != nil || errB != nil {
+			return keys[i] < keys[j]
+		}
+		return a < b
+	})
+
+	resources := make([]map[string]interface{}, 0, len(keys))
+	for _, key := range keys {
+		if res, ok := items[key].(map[string]interface{}); ok {
+			resources = append(resources, res)
+		}
+	}
+	return resources
+}
+
+// fieldSelectorPrefix marks selector keys which are field paths of the resource.
+const fieldSelectorPrefix = "field:"
+
+// selectorRequirement is a single expression of a resource selector.
+type selectorRequirement struct {
+	path     []string
+	operator string
+	value    string
+}
+
+// parseResourceSelector parses comma-separated expressions: "key=value", "key!=value", "key" and "!key".
+//
+// Keys refer to resource labels, like in `talosctl get -l`, including labels with dots such as
+// "node-role.kubernetes.io/control-plane". Field paths of the resource start with "spec." or "metadata.",
+// e.g. "spec.linkName=eth0" or "metadata.id=eth0", other fields are selected with the "field:" prefix.
+func parseResourceSelector(selector string) ([]selectorRequirement, error) {
+	var requirements []selectorRequirement
+	for _, expr := range strings.Split(selector, ",") {
+		expr = strings.TrimSpace(expr)
+		if expr == "" {
+			continue
+		}
+
+		var req selectorRequirement
+		var key string
+		switch {
+		case strings.Contains(expr, "!="):
+			key, req.value, _ = strings.Cut(expr, "!=")
+			req.operator = "!="
+		case strings.Contains(expr, "=="):
+			key, req.value, _ = strings.Cut(expr, "==")
+			req.operator = "="
+		case strings.Contains(expr, "="):
+			key, req.value, _ = strings.Cut(expr, "=")
+			req.operator = "="
+		case strings.HasPrefix(expr, "!"):
+			key = strings.TrimPrefix(expr, "!")
+			req.operator = "!"
+		default:
+			key = expr
+			req.operator = "exists"
+		}
+
+		key = strings.TrimSpace(key)
+		req.value = strings.TrimSpace(req.value)
+		if key == "" {
+			return nil, fmt.Errorf("invalid selector %q", expr)
+		}
+
+		switch {
+		case strings.HasPrefix(key, fieldSelectorPrefix):
+			req.path = strings.Split(strings.TrimPrefix(key, fieldSelectorPrefix), ".")
+		case strings.HasPrefix(key, "spec.") || strings.HasPrefix(key, "metadata."):
+			req.path = strings.Split(key, ".")
+		default:
+			req.path = []string{"metadata", "labels", key}
+		}
+		if slices.Contains(req.path, "") {
+			return nil, fmt.Errorf("invalid field path in selector %q", expr)
+		}
+		requirements = append(requirements, req)
+	}
+	return requirements, nil
+}
+
+// matchResource reports whether the resource satisfies all requirements.
+func matchResource(res map[string]interface{}, requirements []selectorRequirement) bool {
+	for _, req := range requirements {
+		value, found := resourceField(res, req.path)
+		switch req.operator {
+		case "exists":
+			if !found {
+				return false
+			}
+		case "!":
+			if found {
+				return false
+			}
+		case "=":
+			if !found || fmt.Sprint(value) != req.value {
+				return false
+			}
+		case "!=":
+			if found && fmt.Sprint(value) == req.value {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func resourceField(res map[string]interface{}, path []string) (interface{}, bool) {
+	var current interface{} = res
+	for _, key := range path {
+		fields, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if current, ok = fields[key]; !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// lookupCache caches lookups by kind, namespace and id for the duration of a single render,
+// as library helpers look up the same resources over and over.
+//
+// If explain is set, every lookup is reported with its latency, cache usage and errors,
+// otherwise errors of calls which didn't fail the lookup are written to warnings.
+type lookupCache struct {
+	entries    map[string]lookupCacheEntry
+	explain    io.Writer
+	warnings   io.Writer
+	callErrors []error
+	lookups    int
+	hits       int
+	elapsed    time.Duration
+}
+
+type lookupCacheEntry struct {
+	result  map[string]interface{}
+	err     error
+	explain error // err or errors of calls which didn't fail the lookup
+}
+
+func newLookupCache(explain io.Writer) *lookupCache {
+	return &lookupCache{
+		entries:  map[string]lookupCacheEntry{},
+		explain:  explain,
+		warnings: os.Stderr,
+	}
+}
+
+// callError records an error of a call to the node which didn't fail the lookup.
+func (lc *lookupCache) callError(err error) {
+	lc.callErrors = append(lc.callErrors, err)
+}
+
+// wrap returns the lookup function serving repeated lookups from the cache.
+//
+// Every call gets its own copy of the result, so templates modifying it don't affect other lookups.
+func (lc *lookupCache) wrap(lookup LookupFunc) LookupFunc {
+	return func(kind string, namespace string, id string) (map[string]interface{}, error) {
+		lc.lookups++
+		key := kind + "/" + namespace + "/" + id
+
+		if entry, ok := lc.entries[key]; ok {
+			lc.hits++
+			lc.report(kind, namespace, id, true, 0, entry.result, entry.explain)
+			return copyResult(entry.result), entry.err
+		}
+
+		lc.callErrors = nil
+		start := time.Now()
+		result, err := lookup(kind, namespace, id)
+		elapsed := time.Since(start)
+		lc.elapsed += elapsed
+
+		reportErr := err
+		if reportErr == nil && len(lc.callErrors) > 0 {
+			reportErr = errors.Join(lc.callErrors...)
+		}
+
+		lc.entries[key] = lookupCacheEntry{result: copyResult(result), err: err, explain: reportErr}
+		lc.report(kind, namespace, id, false, elapsed, result, reportErr)
+		if lc.explain == nil && err == nil && reportErr != nil && lc.warnings != nil {
+			fmt.Fprintf(lc.warnings, "WARNING: lookup %s may be incomplete: %s\n", lookupName(kind, namespace, id), reportErr)
+		}
+
+		return result, err
+	}
+}
+
+// copyResult returns a deep copy of the lookup result.
+func copyResult(result map[string]interface{}) map[string]interface{} {
+	if result == nil {
+		return nil
+	}
+	return copyValue(result).(map[string]interface{})
+}
+
+func copyValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			copied[key] = copyValue(item)
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(v))
+		for i, item := range v {
+			copied[i] = copyValue(item)
+		}
+		return copied
+	default:
+		return v
+	}
+}
+
+func (lc *lookupCache) report(kind, namespace, id string, hit bool, elapsed time.Duration, result map[string]interface{}, err error) {
+	if lc.explain == nil {
+		return
+	}
+
+	cache := "miss"
+	if hit {
+		cache = "hit"
+	}
+	fmt.Fprintf(lc.explain, "lookup %s: cache=%s, time=%s, resources=%d", lookupName(kind, namespace, id), cache, elapsed.Round(time.Microsecond), len(lookupItems(result)))
+	if err != nil {
+		fmt.Fprintf(lc.explain, ", error=%q", err.Error())
+	}
+	fmt.Fprintln(lc.explain)
+}
+
+// summary reports totals of the render.
+func (lc *lookupCache) summary() {
+	if lc.explain == nil {
+		return
+	}
+
+	fmt.Fprintf(lc.explain, "lookups: total=%d, cached=%d, time=%s\n", lc.lookups, lc.hits, lc.elapsed.Round(time.Microsecond))
+}
@@ -0,0 +1,156 @@
+package engine
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseResourceSelector(t *testing.T) {
+	testCases := []struct {
+		selector string
+		want     []selectorRequirement
+	}{
+		{
+			selector: "node-role.kubernetes.io/control-plane",
+			want:     []selectorRequirement{{path: []string{"metadata", "labels", "node-role.kubernetes.io/control-plane"}, operator: "exists"}},
+		},
+		{
+			selector: "!node-role.kubernetes.io/control-plane, topology.kubernetes.io/zone=a",
+			want: []selectorRequirement{
+				{path: []string{"metadata", "labels", "node-role.kubernetes.io/control-plane"}, operator: "!"},
+				{path: []string{"metadata", "labels", "topology.kubernetes.io/zone"}, operator: "=", value: "a"},
+			},
+		},
+		{
+			selector: "spec.linkName=eth0,metadata.id!=lo",
+			want: []selectorRequirement{
+				{path: []string{"spec", "linkName"}, operator: "=", value: "eth0"},
+				{path: []string{"metadata", "id"}, operator: "!=", value: "lo"},
+			},
+		},
+		{
+			selector: "field:kind==Link,role",
+			want: []selectorRequirement{
+				{path: []string{"kind"}, operator: "=", value: "Link"},
+				{path: []string{"metadata", "labels", "role"}, operator: "exists"},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		got, err := parseResourceSelector(tc.selector)
+		if err != nil {
+			t.Errorf("parseResourceSelector(%q): %s", tc.selector, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("parseResourceSelector(%q): expected %+v, got %+v", tc.selector, tc.want, got)
+		}
+	}
+
+	for _, selector := range []string{"=value", "field:=value", "field:spec..name"} {
+		if _, err := parseResourceSelector(selector); err == nil {
+			t.Errorf("parseResourceSelector(%q): expected an error", selector)
+		}
+	}
+}
+
+func TestListLookupLabelSelector(t *testing.T) {
+	nodes := map[string]interface{}{
+		"kind": "List",
+		"items": map[string]interface{}{
+			"_0": map[string]interface{}{
+				"metadata": map[string]interface{}{"id": "cp1", "labels": map[string]interface{}{"node-role.kubernetes.io/control-plane": ""}},
+			},
+			"_1": map[string]interface{}{
+				"metadata": map[string]interface{}{"id": "w1", "labels": map[string]interface{}{}},
+			},
+		},
+	}
+	lookup := func(kind, namespace, id string) (map[string]interface{}, error) {
+		return nodes, nil
+	}
+
+	result, err := listLookup(lookup)("nodes", "", "node-role.kubernetes.io/control-plane")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result) != 1 || result[0].(map[string]interface{})["metadata"].(map[string]interface{})["id"] != "cp1" {
+		t.Errorf("expected only the control plane node, got %v", result)
+	}
+}
+
+func TestLookupCacheCopiesResults(t *testing.T) {
+	calls := 0
+	lookup := func(kind, namespace, id string) (map[string]interface{}, error) {
+		calls++
+		return map[string]interface{}{
+			"spec": map[string]interface{}{"addresses": []interface{}{"10.0.0.1/24"}},
+		}, nil
+	}
+
+	cache := newLookupCache(nil)
+	cached := cache.wrap(lookup)
+
+	first, err := cached("addresses", "network", "eth0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Templates can modify results with set, unset and merge
+	first["spec"].(map[string]interface{})["addresses"].([]interface{})[0] = "changed"
+	delete(first, "spec")
+
+	for range 2 {
+		result, err := cached("addresses", "network", "eth0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		spec, ok := result["spec"].(map[string]interface{})
+		if !ok || spec["addresses"].([]interface{})[0] != "10.0.0.1/24" {
+			t.Fatalf("cached result was modified: %v", result)
+		}
+		spec["addresses"] = nil
+	}
+
+	if calls != 1 {
+		t.Errorf("expected a single call to the node, got %d", calls)
+	}
+}
+
+func TestLookupCacheWarnings(t *testing.T) {
+	var warnings bytes.Buffer
+
+	cache := newLookupCache(nil)
+	cache.warnings = &warnings
+	cached := cache.wrap(func(kind, namespace, id string) (map[string]interface{}, error) {
+		cache.callError(errors.New("node 10.0.0.2: connection refused"))
+		return map[string]interface{}{}, nil
+	})
+
+	if _, err := cached("links", "network", ""); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(warnings.String(), "connection refused") {
+		t.Errorf("expected a warning about the failed call, got %q", warnings.String())
+	}
+
+	// Errors are reported along with other lookups when they are explained
+	warnings.Reset()
+	var explain bytes.Buffer
+	cache = newLookupCache(&explain)
+	cache.warnings = &warnings
+	cached = cache.wrap(func(kind, namespace, id string) (map[string]interface{}, error) {
+		cache.callError(errors.New("node 10.0.0.2: connection refused"))
+		return map[string]interface{}{}, nil
+	})
+
+	if _, err := cached("links", "network", ""); err != nil {
+		t.Fatal(err)
+	}
+	if warnings.Len() != 0 || !strings.Contains(explain.String(), "connection refused") {
+		t.Errorf("expected the error only in the explanation, got warnings %q and explanation %q", warnings.String(), explain.String())
+	}
+}
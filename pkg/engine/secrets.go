@@ -0,0 +1,37 @@
+package engine
+
+import (
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/aenix-io/talm/pkg/sops"
+
+	"github.com/siderolabs/talos/pkg/machinery/config/generate/secrets"
+)
+
+// LoadSecretsBundle loads the secrets bundle from the file, decrypting it in memory
+// if it is encrypted with sops.
+func LoadSecretsBundle(path string) (*secrets.Bundle, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if sops.IsEncrypted(data) {
+		data, err = sops.Decrypt(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt %s: %w", path, err)
+		}
+	}
+
+	bundle := &secrets.Bundle{
+		Clock: secrets.NewClock(),
+	}
+	if err := yaml.Unmarshal(data, bundle); err != nil {
+		return nil, err
+	}
+
+	return bundle, nil
+}
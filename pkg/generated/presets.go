@@ -5,6 +5,9 @@ var PresetFiles = map[string]string{
 name: %s
 type: application
 version: %s
+description: Talos Linux cluster for Cozystack platform
+annotations:
+  talm.aenix.io/required-values: "endpoint, advertisedSubnets, floatingIP"
 globalOptions:
   talosconfig: "talosconfig"
 templateOptions:
@@ -19,6 +22,7 @@ templateOptions:
   withSecrets: "secrets.yaml"
   kubernetesVersion: ""
   full: false
+  strict: false
 applyOptions:
   preserve: false
   timeout: "1m"
@@ -143,6 +147,9 @@ oidcIssuerUrl: ""
 name: %s
 type: application
 version: %s
+description: Generic Talos Linux cluster
+annotations:
+  talm.aenix.io/required-values: "endpoint, advertisedSubnets"
 globalOptions:
   talosconfig: "talosconfig"
 templateOptions:
@@ -157,6 +164,7 @@ templateOptions:
   withSecrets: "secrets.yaml"
   kubernetesVersion: ""
   full: false
+  strict: false
 applyOptions:
   preserve: false
   timeout: "1m"
@@ -215,6 +223,7 @@ cluster:
 {{- include "talos.config" . }}
 `,
 	"generic/values.yaml": `endpoint: "https://192.168.100.10:6443"
+floatingIP: ""
 podSubnets:
 - 10.244.0.0/16
 serviceSubnets:
@@ -233,7 +242,7 @@ description: A library Talm chart for Talos Linux
 {{- end }}
 
 {{- define "talm.discovered.machinetype" }}
-{{- (lookup "machinetype" "" "machine-type").spec }}
+{{- lookup "machinetype" "" "machine-type" | dig "spec" "" }}
 {{- end }}
 
 {{- define "talm.discovered.hostname" }}
@@ -247,7 +256,7 @@ description: A library Talm chart for Talos Linux
 
 {{- define "talm.discovered.disks_info" }}
 # -- Discovered disks:
-{{- range (lookup "disks" "" "").items }}
+{{- range (lookup "disks" "" "" | dig "items" dict) }}
 {{- if .spec.wwid }}
 # {{ .spec.dev_path }}:
 #    model: {{ .spec.model }}
@@ -267,14 +276,14 @@ description: A library Talm chart for Talos Linux
 {{- define "talm.discovered.default_addresses_by_gateway" }}
 {{- $linkName := "" }}
 {{- $family := "" }}
-{{- range (lookup "routes" "" "").items }}
+{{- range (lookup "routes" "" "" | dig "items" dict) }}
 {{- if and (eq .spec.dst "") (not (eq .spec.gateway "")) (eq .spec.table "main") }}
 {{- $linkName = .spec.outLinkName }}
 {{- $family = .spec.family }}
 {{- end }}
 {{- end }}
 {{- $addresses := list }}
-{{- range (lookup "addresses" "" "").items }}
+{{- range (lookup "addresses" "" "" | dig "items" dict) }}
 {{- if and (eq .spec.linkName $linkName) (eq .spec.family $family) (not (eq .spec.scope "host")) }}
 {{- if not (hasPrefix (printf "%s/" $.Values.floatingIP) .spec.address) }}
 {{- $addresses = append $addresses .spec.address }}
@@ -286,7 +295,7 @@ description: A library Talm chart for Talos Linux
 
 {{- define "talm.discovered.physical_links_info" }}
 # -- Discovered interfaces:
-{{- range (lookup "links" "" "").items }}
+{{- range (lookup "links" "" "" | dig "items" dict) }}
 {{- if and .spec.busPath (regexMatch "^(eno|eth|enp|enx|ens)" .metadata.id) }}
 # enx{{ .spec.hardwareAddr | replace ":" "" }}:
 #   id: {{ .metadata.id }}
@@ -300,7 +309,7 @@ description: A library Talm chart for Talos Linux
 {{- end }}
 
 {{- define "talm.discovered.default_link_name" }}
-{{- range (lookup "addresses" "" "").items }}
+{{- range (lookup "addresses" "" "" | dig "items" dict) }}
 {{- if has .spec.address (fromJsonArray (include "talm.discovered.default_addresses" .)) }}
 {{- .spec.linkName }}
 {{- end }}
@@ -308,7 +317,7 @@ description: A library Talm chart for Talos Linux
 {{- end }}
 
 {{- define "talm.discovered.default_link_name_by_gateway" }}
-{{- range (lookup "routes" "" "").items }}
+{{- range (lookup "routes" "" "" | dig "items" dict) }}
 {{- if and (eq .spec.dst "") (not (eq .spec.gateway "")) }}
 {{- .spec.outLinkName }}
 {{- end }}
@@ -316,7 +325,7 @@ description: A library Talm chart for Talos Linux
 {{- end }}
 
 {{- define "talm.discovered.default_link_address_by_gateway" }}
-{{- range (lookup "routes" "" "").items }}
+{{- range (lookup "routes" "" "" | dig "items" dict) }}
 {{- if and (eq .spec.dst "") (not (eq .spec.gateway "")) }}
 {{- (lookup "links" "" .spec.outLinkName).spec.hardwareAddr }}
 {{- end }}
@@ -324,7 +333,7 @@ description: A library Talm chart for Talos Linux
 {{- end }}
 
 {{- define "talm.discovered.default_link_bus_by_gateway" }}
-{{- range (lookup "routes" "" "").items }}
+{{- range (lookup "routes" "" "" | dig "items" dict) }}
 {{- if and (eq .spec.dst "") (not (eq .spec.gateway "")) }}
 {{- (lookup "links" "" .spec.outLinkName).spec.hardwareAddr }}
 {{- end }}
@@ -332,7 +341,7 @@ description: A library Talm chart for Talos Linux
 {{- end }}
 
 {{- define "talm.discovered.default_link_selector_by_gateway" }}
-{{- range (lookup "routes" "" "").items }}
+{{- range (lookup "routes" "" "" | dig "items" dict) }}
 {{- if and (eq .spec.dst "") (not (eq .spec.gateway "")) }}
 {{- with (lookup "links" "" .spec.outLinkName) }}
 busPath: {{ .spec.busPath }}
@@ -347,7 +356,7 @@ busPath: {{ .spec.busPath }}
 {{- end }}
 
 {{- define "talm.discovered.default_gateway" }}
-{{- range (lookup "routes" "" "").items }}
+{{- range (lookup "routes" "" "" | dig "items" dict) }}
 {{- if and (eq .spec.dst "") (not (eq .spec.gateway "")) (eq .spec.table "main") }}
 {{- .spec.gateway }}
 {{- break }}
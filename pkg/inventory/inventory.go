@@ -0,0 +1,192 @@
+package inventory
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/aenix-io/talm/pkg/modeline"
+	"gopkg.in/yaml.v3"
+)
+
+// FileName is the default name of the inventory file in the project root.
+const FileName = "inventory.yaml"
+
+// Roles supported by the inventory, every role has a template with the same name.
+const (
+	RoleControlPlane = "controlplane"
+	RoleWorker       = "worker"
+)
+
+// Inventory describes all nodes of the cluster.
+type Inventory struct {
+	// Endpoints used for nodes without own endpoints, control plane addresses by default
+	Endpoints []string `yaml:"endpoints,omitempty"`
+	Nodes     []Node   `yaml:"nodes"`
+}
+
+// Node describes a single node and the file generated for it.
+type Node struct {
+	Name         string                 `yaml:"name"`
+	Address      string                 `yaml:"address"`
+	Role         string                 `yaml:"role"`
+	File         string                 `yaml:"file,omitempty"`
+	Endpoints    []string               `yaml:"endpoints,omitempty"`
+	Templates    []string               `yaml:"templates,omitempty"`
+	Values       map[string]interface{} `yaml:"values,omitempty"`
+	ValueFiles   []string               `yaml:"valueFiles,omitempty"`
+	TalosVersion string                 `yaml:"talosVersion,omitempty"`
+	Context      string                 `yaml:"context,omitempty"`
+	Labels       map[string]string      `yaml:"labels,omitempty"`
+}
+
+// Load reads and validates the inventory file.
+func Load(path string) (*Inventory, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var inv Inventory
+	if err := yaml.Unmarshal(data, &inv); err != nil {
+		return nil, fmt.Errorf("failed to parse inventory %s: %w", path, err)
+	}
+
+	if err := inv.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid inventory %s: %w", path, err)
+	}
+
+	return &inv, nil
+}
+
+// Validate checks that every node has a unique name, an address and a known role.
+func (inv *Inventory) Validate() error {
+	names := map[string]struct{}{}
+	files := map[string]string{}
+	for i, node := range inv.Nodes {
+		if node.Name == "" {
+			return fmt.Errorf("node #%d has no name", i)
+		}
+		if strings.ContainsAny(node.Name, `/\`) {
+			return fmt.Errorf("node %s: name must not contain path separators", node.Name)
+		}
+		if _, ok := names[node.Name]; ok {
+			return fmt.Errorf("duplicate node name %s", node.Name)
+		}
+		names[node.Name] = struct{}{}
+
+		if node.Address == "" {
+			return fmt.Errorf("node %s has no address", node.Name)
+		}
+		if node.Role != RoleControlPlane && node.Role != RoleWorker && len(node.Templates) == 0 {
+			return fmt.Errorf("node %s: unknown role %q, expected %s or %s (or set templates explicitly)", node.Name, node.Role, RoleControlPlane, RoleWorker)
+		}
+
+		file := filepath.Clean(node.FilePath(""))
+		if other, ok := files[file]; ok {
+			return fmt.Errorf("nodes %s and %s use the same file %s", other, node.Name, file)
+		}
+		files[file] = node.Name
+	}
+
+	return nil
+}
+
+// Select returns nodes having all labels from the selector.
+func (inv *Inventory) Select(selector map[string]string) []Node {
+	var nodes []Node
+	for _, node := range inv.Nodes {
+		if node.Matches(selector) {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
+// ParseSelector parses a label selector in key=value,key2=value2 format.
+func ParseSelector(selector string) (map[string]string, error) {
+	result := map[string]string{}
+	for _, part := range strings.Split(selector, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(part, "=")
+		if !ok || strings.TrimSpace(key) == "" {
+			return nil, fmt.Errorf("invalid selector %q, expected key=value", part)
+		}
+		result[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return result, nil
+}
+
+// Matches reports whether the node has all labels from the selector.
+// The role key matches the node role unless it is set as a label.
+func (n Node) Matches(selector map[string]string) bool {
+	for key, value := range selector {
+		actual, ok := n.Labels[key]
+		if !ok && key == "role" {
+			actual, ok = n.Role, true
+		}
+		if !ok || actual != value {
+			return false
+		}
+	}
+	return true
+}
+
+// FilePath returns the path of the node file in the project root, nodes/<name>.yaml unless set explicitly.
+// Relative paths set explicitly are relative to the project root too.
+func (n Node) FilePath(root string) string {
+	if n.File != "" {
+		if filepath.IsAbs(n.File) {
+			return n.File
+		}
+		return filepath.Join(root, n.File)
+	}
+	return filepath.Join(root, "nodes", n.Name+".yaml")
+}
+
+// NodeEndpoints returns endpoints for the node: its own, the inventory ones or
+// addresses of all control plane nodes.
+func (inv *Inventory) NodeEndpoints(node Node) []string {
+	if len(node.Endpoints) > 0 {
+		return node.Endpoints
+	}
+	if len(inv.Endpoints) > 0 {
+		return inv.Endpoints
+	}
+
+	var endpoints []string
+	for _, n := range inv.Nodes {
+		if n.Role == RoleControlPlane {
+			endpoints = append(endpoints, n.Address)
+		}
+	}
+	sort.Strings(endpoints)
+	return endpoints
+}
+
+// Modeline builds the modeline for the node on top of base, the modeline of the existing
+// node file if any, so unknown keys of the existing file are kept.
+func (inv *Inventory) Modeline(node Node, base *modeline.Config) *modeline.Config {
+	config := modeline.Config{}
+	if base != nil {
+		config = *base
+	}
+
+	config.Nodes = []string{node.Address}
+	config.Endpoints = inv.NodeEndpoints(node)
+	config.Templates = node.Templates
+	if len(config.Templates) == 0 {
+		config.Templates = []string{"templates/" + node.Role + ".yaml"}
+	}
+	config.Values = node.Values
+	config.ValueFiles = node.ValueFiles
+	config.TalosVersion = node.TalosVersion
+	config.Context = node.Context
+
+	return &config
+}
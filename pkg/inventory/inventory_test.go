@@ -0,0 +1,99 @@
+package inventory
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/aenix-io/talm/pkg/modeline"
+)
+
+const testInventory = `nodes:
+  - name: cp1
+    address: 10.0.0.2
+    role: controlplane
+    labels:
+      zone: a
+  - name: cp2
+    address: 10.0.0.1
+    role: controlplane
+    labels:
+      zone: b
+  - name: worker1
+    address: 10.0.0.10
+    role: worker
+    file: custom/worker1.yaml
+    values:
+      floatingIP: 10.0.0.100
+    labels:
+      zone: a
+`
+
+func loadTestInventory(t *testing.T, content string) (*Inventory, error) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), FileName)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return Load(path)
+}
+
+func TestInventory(t *testing.T) {
+	inv, err := loadTestInventory(t, testInventory)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	selector, err := ParseSelector("zone=a, role=worker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodes := inv.Select(selector)
+	if len(nodes) != 1 || nodes[0].Name != "worker1" {
+		t.Fatalf("unexpected selected nodes: %v", nodes)
+	}
+	if got := nodes[0].FilePath("/project"); got != filepath.Join("/project", "custom", "worker1.yaml") {
+		t.Errorf("FilePath() got = %s", got)
+	}
+	if got := inv.Nodes[0].FilePath("/project"); got != filepath.Join("/project", "nodes", "cp1.yaml") {
+		t.Errorf("FilePath() got = %s", got)
+	}
+	if got := (Node{Name: "cp3", File: "/srv/cp3.yaml"}).FilePath("/project"); got != "/srv/cp3.yaml" {
+		t.Errorf("FilePath() got = %s", got)
+	}
+
+	base := &modeline.Config{
+		Nodes: []string{"10.0.0.99"},
+		Extra: map[string]json.RawMessage{"custom": json.RawMessage(`"kept"`)},
+	}
+	want := &modeline.Config{
+		Nodes:     []string{"10.0.0.10"},
+		Endpoints: []string{"10.0.0.1", "10.0.0.2"},
+		Templates: []string{"templates/worker.yaml"},
+		Values:    map[string]interface{}{"floatingIP": "10.0.0.100"},
+		Extra:     map[string]json.RawMessage{"custom": json.RawMessage(`"kept"`)},
+	}
+	if got := inv.Modeline(nodes[0], base); !reflect.DeepEqual(got, want) {
+		t.Errorf("Modeline() got = %v, want %v", got, want)
+	}
+}
+
+func TestInventoryValidate(t *testing.T) {
+	testCases := map[string]string{
+		"duplicate name": "nodes:\n  - {name: a, address: 1.1.1.1, role: worker}\n  - {name: a, address: 1.1.1.2, role: worker}\n",
+		"no address":     "nodes:\n  - {name: a, role: worker}\n",
+		"unknown role":   "nodes:\n  - {name: a, address: 1.1.1.1, role: storage}\n",
+		"same file":      "nodes:\n  - {name: a, address: 1.1.1.1, role: worker}\n  - {name: b, address: 1.1.1.2, role: worker, file: nodes/a.yaml}\n",
+	}
+
+	for name, content := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if _, err := loadTestInventory(t, content); err == nil {
+				t.Error("expected validation error")
+			}
+		})
+	}
+}
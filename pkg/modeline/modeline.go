@@ -2,52 +2,142 @@ package modeline
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"os"
+	"sort"
 	"strings"
 )
 
+const prefix = "# talm: "
+
 // Config structure for storing settings from modeline
 type Config struct {
 	Nodes     []string
 	Endpoints []string
 	Templates []string
+
+	// Per-node overrides
+	Values       map[string]interface{}
+	ValueFiles   []string
+	TalosVersion string
+	Context      string
+
+	// Extra keeps unknown keys with their raw JSON values, so they survive regeneration
+	Extra map[string]json.RawMessage
 }
 
 // ParseModeline parses a modeline string and populates the Config structure
 func ParseModeline(line string) (*Config, error) {
-	config := &Config{}
 	trimLine := strings.TrimSpace(line)
-	prefix := "# talm: "
-	if strings.HasPrefix(trimLine, prefix) {
-		content := strings.TrimPrefix(trimLine, prefix)
-		parts := strings.Split(content, ", ")
-		for _, part := range parts {
-			keyVal := strings.SplitN(strings.TrimSpace(part), "=", 2)
-			if len(keyVal) != 2 {
-				return nil, fmt.Errorf("invalid format of modeline part: %s", part)
+	if !strings.HasPrefix(trimLine, prefix) {
+		return nil, fmt.Errorf("modeline prefix not found")
+	}
+
+	pairs, err := tokenize(strings.TrimPrefix(trimLine, prefix))
+	if err != nil {
+		return nil, err
+	}
+
+	config := &Config{}
+	for _, pair := range pairs {
+		var target interface{}
+		switch pair.key {
+		case "nodes":
+			target = &config.Nodes
+		case "endpoints":
+			target = &config.Endpoints
+		case "templates":
+			target = &config.Templates
+		case "values":
+			target = &config.Values
+		case "valueFiles":
+			target = &config.ValueFiles
+		case "talosVersion":
+			target = &config.TalosVersion
+		case "context":
+			target = &config.Context
+		default:
+			if config.Extra == nil {
+				config.Extra = map[string]json.RawMessage{}
+			}
+			config.Extra[pair.key] = pair.value
+			continue
+		}
+		// A bare word is a single item of list keys, e.g. endpoints=node1
+		if list, ok := target.(*[]string); ok && pair.bare {
+			var word string
+			if err := json.Unmarshal(pair.value, &word); err == nil {
+				*list = append(*list, word)
+				continue
+			}
+		}
+		if err := json.Unmarshal(pair.value, target); err != nil {
+			return nil, fmt.Errorf("error parsing value for key %s, value %s, error: %v", pair.key, pair.value, err)
+		}
+	}
+
+	return config, nil
+}
+
+type pair struct {
+	key   string
+	value json.RawMessage
+	bare  bool // value was given as a bare word and encoded as a JSON string
+}
+
+// tokenize splits modeline content into key=value pairs separated by commas.
+// Values starting with '[', '{' or '"' are JSON documents, so commas inside strings, arrays and objects are kept.
+// Anything else is a bare word up to the next comma (e.g. talosVersion=1.9.0, context=test) and is taken as a string.
+func tokenize(content string) ([]pair, error) {
+	var pairs []pair
+	rest := strings.TrimSpace(content)
+	for rest != "" {
+		eq := strings.IndexByte(rest, '=')
+		if eq < 0 {
+			return nil, fmt.Errorf("invalid format of modeline part: %s", rest)
+		}
+		key := strings.TrimSpace(rest[:eq])
+		if key == "" || strings.ContainsAny(key, ", \t\"") {
+			return nil, fmt.Errorf("invalid key in modeline part: %s", rest)
+		}
+		rest = strings.TrimLeft(rest[eq+1:], " \t")
+
+		var value json.RawMessage
+		bare := false
+		if rest != "" && strings.ContainsRune(`[{"`, rune(rest[0])) {
+			dec := json.NewDecoder(strings.NewReader(rest))
+			if err := dec.Decode(&value); err != nil {
+				return nil, fmt.Errorf("error parsing JSON value for key %s: %v", key, err)
 			}
-			key := keyVal[0]
-			val := keyVal[1]
-			var arr []string
-			if err := json.Unmarshal([]byte(val), &arr); err != nil {
-				return nil, fmt.Errorf("error parsing JSON array for key %s, value %s, error: %v", key, val, err)
+			rest = rest[dec.InputOffset():]
+		} else {
+			end := strings.IndexByte(rest, ',')
+			if end < 0 {
+				end = len(rest)
 			}
-			// Assign values to Config fields based on known keys
-			switch key {
-			case "nodes":
-				config.Nodes = arr
-			case "endpoints":
-				config.Endpoints = arr
-			case "templates":
-				config.Templates = arr
-				// Ignore unknown keys
+			word := strings.TrimSpace(rest[:end])
+			if word == "" {
+				return nil, fmt.Errorf("empty value for key %s", key)
 			}
+			value, _ = json.Marshal(word) //nolint:errcheck
+			bare = true
+			rest = rest[end:]
+		}
+		pairs = append(pairs, pair{key: key, value: value, bare: bare})
+
+		rest = strings.TrimLeft(rest, " \t")
+		if rest == "" {
+			break
+		}
+		if rest[0] != ',' {
+			return nil, fmt.Errorf("expected comma after value of key %s, got: %s", key, rest)
 		}
-		return config, nil
+		rest = strings.TrimLeft(rest[1:], " \t")
 	}
-	return nil, fmt.Errorf("modeline prefix not found")
+
+	return pairs, nil
 }
 
 // ReadAndParseModeline reads the first line from a file and parses the modeline.
@@ -71,27 +161,63 @@ func ReadAndParseModeline(filePath string) (*Config, error) {
 	return nil, fmt.Errorf("config file is empty")
 }
 
-// GenerateModeline creates a modeline string using JSON formatting for values
-func GenerateModeline(nodes []string, endpoints []string, templates []string) (string, error) {
-	// Convert Nodes to JSON
-	nodesJSON, err := json.Marshal(nodes)
-	if err != nil {
-		return "", fmt.Errorf("failed to marshal nodes: %v", err)
+// GenerateModeline creates a modeline string using JSON formatting for values.
+// Nodes, endpoints and templates are always written, other keys only when set,
+// so a parsed modeline is generated back unchanged.
+func GenerateModeline(config *Config) (string, error) {
+	var parts []string
+	add := func(key string, value interface{}) error {
+		data, err := marshal(value)
+		if err != nil {
+			return fmt.Errorf("failed to marshal %s: %v", key, err)
+		}
+		parts = append(parts, key+"="+string(data))
+		return nil
 	}
 
-	// Convert Endpoints to JSON
-	endpointsJSON, err := json.Marshal(endpoints)
-	if err != nil {
-		return "", fmt.Errorf("failed to marshal endpoints: %v", err)
+	fields := []struct {
+		key   string
+		value interface{}
+		set   bool
+	}{
+		{"nodes", config.Nodes, true},
+		{"endpoints", config.Endpoints, true},
+		{"templates", config.Templates, true},
+		{"talosVersion", config.TalosVersion, config.TalosVersion != ""},
+		{"context", config.Context, config.Context != ""},
+		{"valueFiles", config.ValueFiles, len(config.ValueFiles) > 0},
+		{"values", config.Values, len(config.Values) > 0},
+	}
+	for _, field := range fields {
+		if !field.set {
+			continue
+		}
+		if err := add(field.key, field.value); err != nil {
+			return "", err
+		}
 	}
 
-	// Convert Templates to JSON
-	templatesJSON, err := json.Marshal(templates)
-	if err != nil {
-		return "", fmt.Errorf("failed to marshal templates: %v", err)
+	keys := make([]string, 0, len(config.Extra))
+	for key := range config.Extra {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err := add(key, config.Extra[key]); err != nil {
+			return "", err
+		}
 	}
 
-	// Form the final modeline string
-	modeline := fmt.Sprintf(`# talm: nodes=%s, endpoints=%s, templates=%s`, string(nodesJSON), string(endpointsJSON), string(templatesJSON))
-	return modeline, nil
+	return prefix + strings.Join(parts, ", "), nil
+}
+
+// marshal encodes value as compact JSON without escaping HTML characters.
+func marshal(value interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(value); err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(buf.Bytes(), "\n"), nil
 }
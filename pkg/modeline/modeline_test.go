@@ -1,6 +1,7 @@
 package modeline
 
 import (
+	"encoding/json"
 	"reflect"
 	"testing"
 )
@@ -28,9 +29,52 @@ func TestParseModeline(t *testing.T) {
 			want: &Config{
 				Nodes:     []string{"192.168.100.2"},
 				Endpoints: []string{"1.2.3.4", "127.0.0.1", "192.168.100.2"},
+				Extra:     map[string]json.RawMessage{"unknown": json.RawMessage(`["value"]`)},
 			},
 			wantErr: false,
 		},
+		{
+			name: "modeline with per-node overrides",
+			line: `# talm: nodes=["10.0.0.1"], endpoints=["10.0.0.1"], templates=["templates/worker.yaml"], talosVersion=v1.9.0, context="prod", valueFiles=["nodes/a.values.yaml"], values={"floatingIP":"10.0.0.100","labels":{"a, b":"c=d"}}`,
+			want: &Config{
+				Nodes:        []string{"10.0.0.1"},
+				Endpoints:    []string{"10.0.0.1"},
+				Templates:    []string{"templates/worker.yaml"},
+				TalosVersion: "v1.9.0",
+				Context:      "prod",
+				ValueFiles:   []string{"nodes/a.values.yaml"},
+				Values: map[string]interface{}{
+					"floatingIP": "10.0.0.100",
+					"labels":     map[string]interface{}{"a, b": "c=d"},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "JSON values containing separators",
+			line: `# talm: nodes=["a, b","c"],endpoints=[ "x" ] ,templates=["t=1.yaml"]`,
+			want: &Config{
+				Nodes:     []string{"a, b", "c"},
+				Endpoints: []string{"x"},
+				Templates: []string{"t=1.yaml"},
+			},
+			wantErr: false,
+		},
+		{
+			name:    "unterminated JSON value",
+			line:    `# talm: nodes=["192.168.100.2", endpoints=["1.2.3.4"]`,
+			wantErr: true,
+		},
+		{
+			name:    "missing separator",
+			line:    `# talm: nodes=["192.168.100.2"] endpoints=["1.2.3.4"]`,
+			wantErr: true,
+		},
+		{
+			name:    "wrong value type",
+			line:    `# talm: nodes={"a":"b"}`,
+			wantErr: true,
+		},
 	}
 
 	for _, tc := range testCases {
@@ -46,3 +90,70 @@ func TestParseModeline(t *testing.T) {
 		})
 	}
 }
+
+func TestParseModelineBareWords(t *testing.T) {
+	testCases := []struct {
+		name string
+		line string
+		want *Config
+	}{
+		{
+			name: "context starting with t",
+			line: `# talm: nodes=["10.0.0.1"], context=test`,
+			want: &Config{Nodes: []string{"10.0.0.1"}, Context: "test"},
+		},
+		{
+			name: "version starting with a digit",
+			line: `# talm: nodes=["10.0.0.1"], talosVersion=1.9.0`,
+			want: &Config{Nodes: []string{"10.0.0.1"}, TalosVersion: "1.9.0"},
+		},
+		{
+			name: "single node and endpoint",
+			line: `# talm: nodes=node1, endpoints=node1`,
+			want: &Config{Nodes: []string{"node1"}, Endpoints: []string{"node1"}},
+		},
+		{
+			name: "IP address and hostname starting with n and f",
+			line: `# talm: nodes=10.0.0.1, endpoints=fw.example.com, templates=nodes.yaml`,
+			want: &Config{Nodes: []string{"10.0.0.1"}, Endpoints: []string{"fw.example.com"}, Templates: []string{"nodes.yaml"}},
+		},
+		{
+			name: "words looking like JSON literals",
+			line: `# talm: context=null, talosVersion=-1, flag=true`,
+			want: &Config{Context: "null", TalosVersion: "-1", Extra: map[string]json.RawMessage{"flag": json.RawMessage(`"true"`)}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ParseModeline(tc.line)
+			if err != nil {
+				t.Fatalf("ParseModeline() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("ParseModeline() got = %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestGenerateModelineRoundTrip(t *testing.T) {
+	lines := []string{
+		`# talm: nodes=["192.168.100.2"], endpoints=["1.2.3.4","127.0.0.1"], templates=["templates/controlplane.yaml"]`,
+		`# talm: nodes=["10.0.0.1"], endpoints=["10.0.0.1"], templates=["templates/worker.yaml"], talosVersion="v1.9.0", context="prod", valueFiles=["nodes/a.values.yaml"], values={"floatingIP":"10.0.0.100","labels":{"a, b":"c=d<e>"}}, custom={"z":1}, other=["x"]`,
+	}
+
+	for _, line := range lines {
+		config, err := ParseModeline(line)
+		if err != nil {
+			t.Fatalf("ParseModeline(%q) error = %v", line, err)
+		}
+		got, err := GenerateModeline(config)
+		if err != nil {
+			t.Fatalf("GenerateModeline() error = %v", err)
+		}
+		if got != line {
+			t.Errorf("GenerateModeline() got = %s, want %s", got, line)
+		}
+	}
+}
@@ -0,0 +1,603 @@
+// Package sops encrypts and decrypts YAML files in the SOPS format using age keys.
+//
+// Only the subset of the format needed for talm is implemented: YAML documents,
+// AES256-GCM data encryption and age key groups. Files written by this package
+// can be decrypted and edited with the sops CLI and vice versa.
+package sops
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// MetadataKey is the top-level key holding SOPS metadata in encrypted files.
+	MetadataKey = "sops"
+	// UnencryptedSuffix marks keys whose values are stored in plain text.
+	UnencryptedSuffix = "_unencrypted"
+
+	version   = "3.9.0"
+	nonceSize = 32
+)
+
+// Environment variables used by sops to find age keys.
+const (
+	AgeKeyEnv        = "SOPS_AGE_KEY"
+	AgeKeyFileEnv    = "SOPS_AGE_KEY_FILE"
+	AgeRecipientsEnv = "SOPS_AGE_RECIPIENTS"
+)
+
+type metadata struct {
+	Age               []ageKey `yaml:"age"`
+	LastModified      string   `yaml:"lastmodified"`
+	MAC               string   `yaml:"mac"`
+	UnencryptedSuffix string   `yaml:"unencrypted_suffix"`
+	Version           string   `yaml:"version"`
+}
+
+type ageKey struct {
+	Recipient string `yaml:"recipient"`
+	Enc       string `yaml:"enc"`
+}
+
+var encryptedValueRegex = regexp.MustCompile(`^ENC\[AES256_GCM,data:(.*),iv:(.*),tag:(.*),type:(.*)\]$`)
+
+// IsEncrypted returns true if the YAML document contains SOPS metadata.
+func IsEncrypted(data []byte) bool {
+	var doc map[string]interface{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return false
+	}
+
+	_, ok := doc[MetadataKey]
+
+	return ok
+}
+
+// Recipients returns the age recipients the document is encrypted for.
+func Recipients(data []byte) ([]string, error) {
+	_, root, err := parse(data)
+	if err != nil {
+		return nil, err
+	}
+
+	meta, err := extractMetadata(root)
+	if err != nil {
+		return nil, err
+	}
+
+	recipients := make([]string, 0, len(meta.Age))
+	for _, key := range meta.Age {
+		recipients = append(recipients, key.Recipient)
+	}
+
+	return recipients, nil
+}
+
+// Encrypt encrypts all values of the YAML document for the given age recipients.
+func Encrypt(data []byte, recipients []string) ([]byte, error) {
+	if len(recipients) == 0 {
+		return nil, errors.New("at least one age recipient is required")
+	}
+
+	doc, root, err := parse(data)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := 0; i < len(root.Content); i += 2 {
+		if root.Content[i].Value == MetadataKey {
+			return nil, errors.New("document is already encrypted")
+		}
+	}
+
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+
+	hash := sha512.New()
+	err = walk(root, nil, func(node *yaml.Node, path []string) error {
+		plaintext, valueType, err := scalarBytes(node)
+		if err != nil {
+			return err
+		}
+		hash.Write(plaintext)
+
+		if !shouldEncrypt(path) || len(plaintext) == 0 {
+			return nil
+		}
+
+		encrypted, err := encryptValue(plaintext, valueType, dataKey, additionalData(path))
+		if err != nil {
+			return err
+		}
+		node.Value, node.Tag, node.Style = encrypted, "!!str", 0
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = walkComments(doc, nil, func(comment string, path []string) (string, error) {
+		if !shouldEncrypt(path) || comment == "" {
+			return comment, nil
+		}
+
+		return encryptValue([]byte(comment), "comment", dataKey, additionalData(path))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	meta := metadata{
+		LastModified:      time.Now().UTC().Format(time.RFC3339),
+		UnencryptedSuffix: UnencryptedSuffix,
+		Version:           version,
+	}
+
+	meta.MAC, err = encryptValue([]byte(fmt.Sprintf("%X", hash.Sum(nil))), "str", dataKey, meta.LastModified)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, recipient := range recipients {
+		enc, err := wrapDataKey(dataKey, recipient)
+		if err != nil {
+			return nil, err
+		}
+		meta.Age = append(meta.Age, ageKey{Recipient: recipient, Enc: enc})
+	}
+
+	var metaNode yaml.Node
+	if err := metaNode.Encode(meta); err != nil {
+		return nil, err
+	}
+	root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: MetadataKey}, &metaNode)
+
+	return encode(doc)
+}
+
+// Decrypt decrypts the document using age identities from the environment.
+func Decrypt(data []byte) ([]byte, error) {
+	identities, err := LoadIdentities()
+	if err != nil {
+		return nil, err
+	}
+
+	return DecryptWithIdentities(data, identities)
+}
+
+// DecryptWithIdentities decrypts the document and verifies its MAC.
+func DecryptWithIdentities(data []byte, identities []age.Identity) ([]byte, error) {
+	doc, root, err := parse(data)
+	if err != nil {
+		return nil, err
+	}
+
+	meta, err := extractMetadata(root)
+	if err != nil {
+		return nil, err
+	}
+
+	dataKey, err := unwrapDataKey(meta, identities)
+	if err != nil {
+		return nil, err
+	}
+
+	hash := sha512.New()
+	err = walk(root, nil, func(node *yaml.Node, path []string) error {
+		if !encryptedValueRegex.MatchString(node.Value) {
+			plaintext, _, err := scalarBytes(node)
+			if err != nil {
+				return err
+			}
+			hash.Write(plaintext)
+
+			return nil
+		}
+
+		plaintext, valueType, err := decryptValue(node.Value, dataKey, additionalData(path))
+		if err != nil {
+			return fmt.Errorf("failed to decrypt %s: %w", strings.Join(path, "."), err)
+		}
+		hash.Write(plaintext)
+
+		node.Style = 0
+		switch valueType {
+		case "int":
+			node.Tag, node.Value = "!!int", string(plaintext)
+		case "float":
+			node.Tag, node.Value = "!!float", string(plaintext)
+		case "bool":
+			node.Tag, node.Value = "!!bool", strings.ToLower(string(plaintext))
+		default:
+			node.Tag, node.Value = "!!str", string(plaintext)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Comments aren't covered by the MAC, sops keeps the ones it can't decrypt as is
+	err = walkComments(doc, nil, func(comment string, path []string) (string, error) {
+		plaintext, valueType, err := decryptValue(comment, dataKey, additionalData(path))
+		if err != nil || valueType != "comment" {
+			return comment, nil //nolint:nilerr
+		}
+
+		return string(plaintext), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	mac, _, err := decryptValue(meta.MAC, dataKey, meta.LastModified)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt MAC: %w", err)
+	}
+	if string(mac) != fmt.Sprintf("%X", hash.Sum(nil)) {
+		return nil, errors.New("MAC mismatch, the file has been modified without re-encryption")
+	}
+
+	return encode(doc)
+}
+
+// LoadIdentities reads age identities the same way as sops does: from SOPS_AGE_KEY,
+// from the file specified in SOPS_AGE_KEY_FILE or from sops/age/keys.txt in the
+// user config directory.
+func LoadIdentities() ([]age.Identity, error) {
+	if key := os.Getenv(AgeKeyEnv); key != "" {
+		identities, err := age.ParseIdentities(strings.NewReader(key))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse age identities from %s: %w", AgeKeyEnv, err)
+		}
+
+		return identities, nil
+	}
+
+	keyFile := os.Getenv(AgeKeyFileEnv)
+	if keyFile == "" {
+		configDir, err := os.UserConfigDir()
+		if err != nil {
+			return nil, fmt.Errorf("age key is not found, set %s or %s: %w", AgeKeyEnv, AgeKeyFileEnv, err)
+		}
+		keyFile = filepath.Join(configDir, "sops", "age", "keys.txt")
+	}
+
+	f, err := os.Open(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("age key is not found, set %s or %s: %w", AgeKeyEnv, AgeKeyFileEnv, err)
+	}
+	defer f.Close() //nolint:errcheck
+
+	identities, err := age.ParseIdentities(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse age identities from %s: %w", keyFile, err)
+	}
+
+	return identities, nil
+}
+
+// RecipientsFromEnv returns age recipients listed in SOPS_AGE_RECIPIENTS.
+func RecipientsFromEnv() []string {
+	var recipients []string
+	for _, recipient := range strings.Split(os.Getenv(AgeRecipientsEnv), ",") {
+		if recipient = strings.TrimSpace(recipient); recipient != "" {
+			recipients = append(recipients, recipient)
+		}
+	}
+
+	return recipients
+}
+
+func parse(data []byte) (*yaml.Node, *yaml.Node, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, nil, err
+	}
+
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, nil, errors.New("top-level YAML mapping is expected")
+	}
+
+	return &doc, doc.Content[0], nil
+}
+
+func encode(doc *yaml.Node) ([]byte, error) {
+	var buf bytes.Buffer
+
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(doc); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// extractMetadata removes SOPS metadata from the root mapping and returns it.
+func extractMetadata(root *yaml.Node) (*metadata, error) {
+	for i := 0; i < len(root.Content); i += 2 {
+		if root.Content[i].Value != MetadataKey {
+			continue
+		}
+
+		meta := &metadata{}
+		if err := root.Content[i+1].Decode(meta); err != nil {
+			return nil, fmt.Errorf("failed to decode sops metadata: %w", err)
+		}
+		root.Content = append(root.Content[:i], root.Content[i+2:]...)
+
+		return meta, nil
+	}
+
+	return nil, errors.New("sops metadata is not found, the file is not encrypted")
+}
+
+// walk calls fn for every scalar value in the tree in document order.
+//
+// Sequence items share the path of the sequence, as in sops.
+func walk(node *yaml.Node, path []string, fn func(node *yaml.Node, path []string) error) error {
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if err := walk(node.Content[i+1], append(path[:len(path):len(path)], node.Content[i].Value), fn); err != nil {
+				return err
+			}
+		}
+	case yaml.SequenceNode:
+		for _, item := range node.Content {
+			if err := walk(item, path, fn); err != nil {
+				return err
+			}
+		}
+	case yaml.ScalarNode:
+		if node.Tag == "!!null" {
+			return nil
+		}
+
+		return fn(node, path)
+	case yaml.AliasNode:
+		return fmt.Errorf("YAML aliases are not supported: %s", strings.Join(path, "."))
+	}
+
+	return nil
+}
+
+// walkComments replaces every comment line in the tree with the result of fn.
+//
+// As in sops, comments belong to the mapping or sequence containing them, so
+// they are passed the path of that collection.
+func walkComments(node *yaml.Node, path []string, fn func(comment string, path []string) (string, error)) error {
+	if err := replaceComments(node, path, fn); err != nil {
+		return err
+	}
+
+	switch node.Kind {
+	case yaml.DocumentNode, yaml.SequenceNode:
+		for _, item := range node.Content {
+			if err := walkComments(item, path, fn); err != nil {
+				return err
+			}
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if err := replaceComments(key, path, fn); err != nil {
+				return err
+			}
+
+			if value.Kind != yaml.MappingNode && value.Kind != yaml.SequenceNode {
+				if err := replaceComments(value, path, fn); err != nil {
+					return err
+				}
+
+				continue
+			}
+
+			if err := walkComments(value, append(path[:len(path):len(path)], key.Value), fn); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func replaceComments(node *yaml.Node, path []string, fn func(comment string, path []string) (string, error)) error {
+	for _, comment := range []*string{&node.HeadComment, &node.LineComment, &node.FootComment} {
+		if *comment == "" {
+			continue
+		}
+
+		lines := strings.Split(*comment, "\n")
+		for i, line := range lines {
+			if !strings.HasPrefix(line, "#") {
+				continue
+			}
+
+			replaced, err := fn(line[1:], path)
+			if err != nil {
+				return err
+			}
+			lines[i] = "#" + replaced
+		}
+		*comment = strings.Join(lines, "\n")
+	}
+
+	return nil
+}
+
+// scalarBytes returns the value representation used by sops for encryption and MAC calculation.
+func scalarBytes(node *yaml.Node) ([]byte, string, error) {
+	switch node.Tag {
+	case "!!int":
+		var v int64
+		if err := node.Decode(&v); err != nil {
+			return nil, "", err
+		}
+
+		return []byte(strconv.FormatInt(v, 10)), "int", nil
+	case "!!float":
+		var v float64
+		if err := node.Decode(&v); err != nil {
+			return nil, "", err
+		}
+
+		return []byte(strconv.FormatFloat(v, 'f', -1, 64)), "float", nil
+	case "!!bool":
+		var v bool
+		if err := node.Decode(&v); err != nil {
+			return nil, "", err
+		}
+		// sops keeps the Python implementation's title case for booleans
+		if v {
+			return []byte("True"), "bool", nil
+		}
+
+		return []byte("False"), "bool", nil
+	default:
+		return []byte(node.Value), "str", nil
+	}
+}
+
+func shouldEncrypt(path []string) bool {
+	for _, key := range path {
+		if strings.HasSuffix(key, UnencryptedSuffix) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func additionalData(path []string) string {
+	return strings.Join(path, ":") + ":"
+}
+
+func encryptValue(plaintext []byte, valueType string, key []byte, additionalData string) (string, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+
+	gcm, err := cipher.NewGCMWithNonceSize(block, nonceSize)
+	if err != nil {
+		return "", err
+	}
+
+	iv := make([]byte, nonceSize)
+	if _, err := rand.Read(iv); err != nil {
+		return "", err
+	}
+
+	out := gcm.Seal(nil, iv, plaintext, []byte(additionalData))
+	data, tag := out[:len(out)-gcm.Overhead()], out[len(out)-gcm.Overhead():]
+
+	return fmt.Sprintf("ENC[AES256_GCM,data:%s,iv:%s,tag:%s,type:%s]",
+		base64.StdEncoding.EncodeToString(data),
+		base64.StdEncoding.EncodeToString(iv),
+		base64.StdEncoding.EncodeToString(tag),
+		valueType,
+	), nil
+}
+
+func decryptValue(value string, key []byte, additionalData string) ([]byte, string, error) {
+	matches := encryptedValueRegex.FindStringSubmatch(value)
+	if matches == nil {
+		return nil, "", errors.New("value is not encrypted")
+	}
+
+	var parts [3][]byte
+	for i := range parts {
+		decoded, err := base64.StdEncoding.DecodeString(matches[i+1])
+		if err != nil {
+			return nil, "", err
+		}
+		parts[i] = decoded
+	}
+	data, iv, tag := parts[0], parts[1], parts[2]
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, "", err
+	}
+
+	gcm, err := cipher.NewGCMWithNonceSize(block, len(iv))
+	if err != nil {
+		return nil, "", err
+	}
+
+	plaintext, err := gcm.Open(nil, iv, append(data, tag...), []byte(additionalData))
+	if err != nil {
+		return nil, "", err
+	}
+
+	return plaintext, matches[4], nil
+}
+
+func wrapDataKey(dataKey []byte, recipient string) (string, error) {
+	r, err := age.ParseX25519Recipient(recipient)
+	if err != nil {
+		return "", fmt.Errorf("invalid age recipient %q: %w", recipient, err)
+	}
+
+	var buf bytes.Buffer
+
+	armorWriter := armor.NewWriter(&buf)
+	w, err := age.Encrypt(armorWriter, r)
+	if err != nil {
+		return "", err
+	}
+	if _, err := w.Write(dataKey); err != nil {
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	if err := armorWriter.Close(); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+func unwrapDataKey(meta *metadata, identities []age.Identity) ([]byte, error) {
+	recipients := make([]string, 0, len(meta.Age))
+	for _, key := range meta.Age {
+		recipients = append(recipients, key.Recipient)
+
+		r, err := age.Decrypt(armor.NewReader(strings.NewReader(key.Enc)), identities...)
+		if err != nil {
+			continue
+		}
+
+		return io.ReadAll(r)
+	}
+
+	return nil, fmt.Errorf("none of the available age identities can decrypt the file, it is encrypted for: %s", strings.Join(recipients, ", "))
+}
@@ -0,0 +1,242 @@
+package sops
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+	"gopkg.in/yaml.v3"
+)
+
+const plainDocument = `cluster:
+  id: abc
+  secret: c2VjcmV0
+secrets:
+  bootstraptoken: token.value
+  count: 3
+  enabled: true
+  ratio: 1.5
+  empty: ""
+  list:
+    - one
+    - two
+comment_unencrypted: visible
+`
+
+func TestEncryptDecrypt(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encrypted, err := Encrypt([]byte(plainDocument), []string{identity.Recipient().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !IsEncrypted(encrypted) {
+		t.Fatal("expected document to be encrypted")
+	}
+	if strings.Contains(string(encrypted), "token.value") {
+		t.Fatal("plain text value found in encrypted document")
+	}
+	if !strings.Contains(string(encrypted), "comment_unencrypted: visible") {
+		t.Fatal("unencrypted value was encrypted")
+	}
+
+	recipients, err := Recipients(encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(recipients, []string{identity.Recipient().String()}) {
+		t.Fatalf("unexpected recipients %v", recipients)
+	}
+
+	decrypted, err := DecryptWithIdentities(encrypted, []age.Identity{identity})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var expected, actual map[string]interface{}
+	if err := yaml.Unmarshal([]byte(plainDocument), &expected); err != nil {
+		t.Fatal(err)
+	}
+	if err := yaml.Unmarshal(decrypted, &actual); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("decrypted document differs:\n%s", decrypted)
+	}
+}
+
+func TestDecryptTampered(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encrypted, err := Encrypt([]byte(plainDocument), []string{identity.Recipient().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tampered := strings.Replace(string(encrypted), "comment_unencrypted: visible", "comment_unencrypted: changed", 1)
+	if _, err := DecryptWithIdentities([]byte(tampered), []age.Identity{identity}); err == nil {
+		t.Fatal("expected MAC mismatch error")
+	}
+
+	other, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := DecryptWithIdentities(encrypted, []age.Identity{other}); err == nil {
+		t.Fatal("expected error for unknown identity")
+	}
+}
+
+func loadTestIdentities(t *testing.T) []age.Identity {
+	t.Helper()
+
+	f, err := os.Open(filepath.Join("testdata", "age.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close() //nolint:errcheck
+
+	identities, err := age.ParseIdentities(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return identities
+}
+
+func assertSameDocument(t *testing.T, expected, actual []byte) {
+	t.Helper()
+
+	var expectedValues, actualValues map[string]interface{}
+	if err := yaml.Unmarshal(expected, &expectedValues); err != nil {
+		t.Fatal(err)
+	}
+	if err := yaml.Unmarshal(actual, &actualValues); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(expectedValues, actualValues) {
+		t.Fatalf("decrypted document differs:\n%s", actual)
+	}
+}
+
+var testComments = []string{"# extra values of other types", "# line comment", "# nested comment"}
+
+func assertComments(t *testing.T, data []byte) {
+	t.Helper()
+
+	for _, comment := range testComments {
+		if !strings.Contains(string(data), comment) {
+			t.Errorf("comment %q is missing:\n%s", comment, data)
+		}
+	}
+}
+
+// testdata/secrets.sops.yaml is testdata/secrets.yaml encrypted by sops 3.9.4 for the key in testdata/age.key:
+//
+//	sops encrypt --age age1cnag8xv3ndm0757tvta090cggngp8l0yrux5l5grc2vz3x4z5s7qq46sss testdata/secrets.yaml
+func TestDecryptSopsFile(t *testing.T) {
+	plain, err := os.ReadFile(filepath.Join("testdata", "secrets.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := os.ReadFile(filepath.Join("testdata", "secrets.sops.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recipients, err := Recipients(encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(recipients, []string{"age1cnag8xv3ndm0757tvta090cggngp8l0yrux5l5grc2vz3x4z5s7qq46sss"}) {
+		t.Fatalf("unexpected recipients %v", recipients)
+	}
+
+	decrypted, err := DecryptWithIdentities(encrypted, loadTestIdentities(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertSameDocument(t, plain, decrypted)
+
+	assertComments(t, decrypted)
+
+	t.Setenv(AgeKeyFileEnv, filepath.Join("testdata", "age.key"))
+	t.Setenv(AgeKeyEnv, "")
+	if _, err := Decrypt(encrypted); err != nil {
+		t.Errorf("failed to decrypt with the key from %s: %s", AgeKeyFileEnv, err)
+	}
+}
+
+// TestSopsCLI checks that the sops CLI decrypts files encrypted by this package and the other way round.
+func TestSopsCLI(t *testing.T) {
+	sopsPath, err := exec.LookPath("sops")
+	if err != nil {
+		t.Skip("sops is not installed")
+	}
+
+	plain, err := os.ReadFile(filepath.Join("testdata", "secrets.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	identities := loadTestIdentities(t)
+	recipient := identities[0].(*age.X25519Identity).Recipient().String()
+
+	encrypted, err := Encrypt(plain, []string{recipient})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	for name, data := range map[string][]byte{"plain.yaml": plain, "encrypted.yaml": encrypted} {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	keyFile, err := filepath.Abs(filepath.Join("testdata", "age.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// sops treats an empty SOPS_AGE_KEY as set, so it's removed for the test
+	t.Setenv(AgeKeyEnv, "")
+	os.Unsetenv(AgeKeyEnv) //nolint:errcheck
+
+	sops := func(args ...string) []byte {
+		cmd := exec.Command(sopsPath, args...)
+		cmd.Env = append(os.Environ(), AgeKeyFileEnv+"="+keyFile)
+		cmd.Stderr = os.Stderr
+		out, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("sops %s: %s", strings.Join(args, " "), err)
+		}
+		return out
+	}
+
+	for _, comment := range testComments {
+		if strings.Contains(string(encrypted), comment) {
+			t.Errorf("comment %q isn't encrypted", comment)
+		}
+	}
+
+	sopsDecrypted := sops("decrypt", filepath.Join(dir, "encrypted.yaml"))
+	assertSameDocument(t, plain, sopsDecrypted)
+	assertComments(t, sopsDecrypted)
+
+	decrypted, err := DecryptWithIdentities(sops("encrypt", "--age", recipient, filepath.Join(dir, "plain.yaml")), identities)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertSameDocument(t, plain, decrypted)
+	assertComments(t, decrypted)
+}
@@ -0,0 +1,185 @@
+package yamltools
+
+import (
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Change operations reported by Changes.
+const (
+	ChangeAdd     = "add"
+	ChangeRemove  = "remove"
+	ChangeReplace = "replace"
+)
+
+// Change describes a single difference between two YAML documents.
+type Change struct {
+	Path string      `json:"path"`
+	Op   string      `json:"op"`
+	Old  interface{} `json:"old,omitempty"`
+	New  interface{} `json:"new,omitempty"`
+}
+
+// Changes compares two YAML documents and returns the list of differences
+// addressed by their path, e.g. "machine.network.interfaces[interface=eth0].addresses[1]".
+//
+// Items of lists of mappings are matched by the same identity keys as in DiffYAMLs,
+// other list items are matched by their index.
+//
+// Documents other than the v1alpha1 machine config are matched by kind and name,
+// paths inside them are prefixed with the document ID, e.g. "ExtensionServiceConfig/nut.environment[0]".
+func Changes(original, modified []byte) ([]Change, error) {
+	origDocs, err := Documents(original)
+	if err != nil {
+		return nil, err
+	}
+	modDocs, err := Documents(modified)
+	if err != nil {
+		return nil, err
+	}
+
+	origIndex := make(map[string]*yaml.Node, len(origDocs))
+	for _, doc := range origDocs {
+		origIndex[DocumentID(doc)] = documentRoot(doc)
+	}
+
+	var changes []Change
+	processed := make(map[string]bool)
+	for _, doc := range modDocs {
+		id := DocumentID(doc)
+		processed[id] = true
+		collectChanges(origIndex[id], documentRoot(doc), documentPath(id), &changes)
+	}
+	for _, doc := range origDocs {
+		if id := DocumentID(doc); !processed[id] {
+			collectChanges(documentRoot(doc), nil, documentPath(id), &changes)
+		}
+	}
+
+	return changes, nil
+}
+
+// documentPath returns the path prefix for changes inside the document with the given ID.
+func documentPath(id string) string {
+	if id == LegacyDocumentID {
+		return ""
+	}
+	return id
+}
+
+// documentRoot returns the top-level node of a parsed document or nil for empty documents.
+func documentRoot(node *yaml.Node) *yaml.Node {
+	if node.Kind == yaml.DocumentNode {
+		if len(node.Content) == 0 {
+			return nil
+		}
+		return node.Content[0]
+	}
+	if node.Kind == 0 {
+		return nil
+	}
+	return node
+}
+
+// collectChanges recursively walks both nodes and records differences into changes.
+func collectChanges(orig, mod *yaml.Node, path string, changes *[]Change) {
+	switch {
+	case orig == nil && mod == nil:
+		return
+	case orig == nil:
+		*changes = append(*changes, Change{Path: path, Op: ChangeAdd, New: nodeValue(mod)})
+		return
+	case mod == nil:
+		*changes = append(*changes, Change{Path: path, Op: ChangeRemove, Old: nodeValue(orig)})
+		return
+	case orig.Kind != mod.Kind:
+		*changes = append(*changes, Change{Path: path, Op: ChangeReplace, Old: nodeValue(orig), New: nodeValue(mod)})
+		return
+	}
+
+	switch orig.Kind {
+	case yaml.MappingNode:
+		origMap := nodeMap(orig)
+		modMap := nodeMap(mod)
+		for i := 0; i+1 < len(mod.Content); i += 2 {
+			key := mod.Content[i].Value
+			collectChanges(origMap[key], mod.Content[i+1], joinPath(path, key), changes)
+		}
+		for i := 0; i+1 < len(orig.Content); i += 2 {
+			key := orig.Content[i].Value
+			if _, ok := modMap[key]; !ok {
+				collectChanges(orig.Content[i+1], nil, joinPath(path, key), changes)
+			}
+		}
+	case yaml.SequenceNode:
+		if idKey := identityKey(orig, mod); idKey != "" {
+			collectItemChanges(orig, mod, idKey, path, changes)
+			return
+		}
+		for i := 0; i < len(orig.Content) || i < len(mod.Content); i++ {
+			collectChanges(sequenceItem(orig, i), sequenceItem(mod, i), path+"["+strconv.Itoa(i)+"]", changes)
+		}
+	case yaml.AliasNode:
+		collectChanges(orig.Alias, mod.Alias, path, changes)
+	default:
+		if orig.Value != mod.Value {
+			*changes = append(*changes, Change{Path: path, Op: ChangeReplace, Old: nodeValue(orig), New: nodeValue(mod)})
+		}
+	}
+}
+
+// collectItemChanges records differences of two lists matching their items by idKey.
+func collectItemChanges(orig, mod *yaml.Node, idKey, path string, changes *[]Change) {
+	origIndex := make(map[string]*yaml.Node, len(orig.Content))
+	for _, item := range orig.Content {
+		origIndex[identity(item, idKey)] = item
+	}
+
+	matched := make(map[string]bool, len(mod.Content))
+	for _, item := range mod.Content {
+		id := identity(item, idKey)
+		matched[id] = true
+		collectChanges(origIndex[id], item, itemPath(path, idKey, item), changes)
+	}
+	for _, item := range orig.Content {
+		if !matched[identity(item, idKey)] {
+			collectChanges(item, nil, itemPath(path, idKey, item), changes)
+		}
+	}
+}
+
+// itemPath returns the path of the list item addressed by its identity, e.g. "interfaces[interface=eth0]".
+func itemPath(path, idKey string, item *yaml.Node) string {
+	value := nodeMap(item)[idKey]
+	id := value.Value
+	if value.Kind != yaml.ScalarNode {
+		id = canonical(value)
+	}
+	return path + "[" + idKey + "=" + id + "]"
+}
+
+// joinPath appends a mapping key to the path.
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// sequenceItem returns the i-th item of the sequence node or nil if it is out of range.
+func sequenceItem(node *yaml.Node, i int) *yaml.Node {
+	if i < len(node.Content) {
+		return node.Content[i]
+	}
+	return nil
+}
+
+// nodeValue decodes a YAML node into a generic Go value.
+func nodeValue(node *yaml.Node) interface{} {
+	var value interface{}
+	if err := node.Decode(&value); err != nil {
+		return node.Value
+	}
+	return value
+}
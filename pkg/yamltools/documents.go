@@ -0,0 +1,100 @@
+package yamltools
+
+import (
+	"bytes"
+	"errors"
+	"io"
+
+	"gopkg.in/yaml.v3"
+)
+
+// LegacyDocumentID identifies the v1alpha1 machine config document, which has no kind.
+const LegacyDocumentID = "v1alpha1"
+
+// Documents decodes every document of a multi-document YAML stream, empty documents are skipped.
+func Documents(data []byte) ([]*yaml.Node, error) {
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+
+	var docs []*yaml.Node
+	for {
+		doc := &yaml.Node{}
+		if err := decoder.Decode(doc); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, err
+		}
+		if documentRoot(doc) == nil {
+			continue
+		}
+		docs = append(docs, doc)
+	}
+
+	return docs, nil
+}
+
+// EncodeDocuments encodes documents into a multi-document YAML stream.
+func EncodeDocuments(docs []*yaml.Node) ([]byte, error) {
+	buffer := &bytes.Buffer{}
+	encoder := yaml.NewEncoder(buffer)
+	encoder.SetIndent(2)
+	for _, doc := range docs {
+		if err := encoder.Encode(doc); err != nil {
+			return nil, err
+		}
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// DocumentID returns the kind and the name of a config document, e.g. "ExtensionServiceConfig/nut",
+// or LegacyDocumentID for the v1alpha1 machine config.
+func DocumentID(doc *yaml.Node) string {
+	root := documentRoot(doc)
+	if root == nil || root.Kind != yaml.MappingNode {
+		return LegacyDocumentID
+	}
+
+	fields := nodeMap(root)
+	kind, ok := fields["kind"]
+	if !ok || kind.Value == "" {
+		return LegacyDocumentID
+	}
+
+	id := kind.Value
+	if name, ok := fields["name"]; ok && name.Kind == yaml.ScalarNode {
+		id += "/" + name.Value
+	}
+	return id
+}
+
+// documentHeader returns a mapping with the fields identifying the document: apiVersion, kind and name.
+func documentHeader(root *yaml.Node) *yaml.Node {
+	header := &yaml.Node{Kind: yaml.MappingNode}
+	fields := nodeMap(root)
+	for _, key := range []string{"apiVersion", "kind", "name"} {
+		if value, ok := fields[key]; ok {
+			addNodeToDiff(header, key, value)
+		}
+	}
+	return header
+}
+
+// CopyDocumentsComments copies comments from the source documents to the target documents
+// with the same kind and name.
+func CopyDocumentsComments(src, dst []*yaml.Node) {
+	for _, dstDoc := range dst {
+		id := DocumentID(dstDoc)
+		for _, srcDoc := range src {
+			if DocumentID(srcDoc) != id {
+				continue
+			}
+			dstPaths := make(map[string]*yaml.Node)
+			CopyComments(srcDoc, dstDoc, "", dstPaths)
+			ApplyComments(dstDoc, "", dstPaths)
+		}
+	}
+}
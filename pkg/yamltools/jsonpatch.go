@@ -0,0 +1,162 @@
+package yamltools
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// JSON Patch operations emitted by JSONPatch.
+const (
+	JSONPatchAdd     = "add"
+	JSONPatchRemove  = "remove"
+	JSONPatchReplace = "replace"
+)
+
+// JSONPatch compares two YAML documents and returns an ordered list of RFC 6902 JSON Patch
+// operations turning the original document into the modified one, encoded as YAML.
+//
+// Object members are set with "add" operations, which replace existing members and don't
+// fail if the member is absent in the document the patch is applied to.
+func JSONPatch(original, modified []byte) ([]byte, error) {
+	origDocs, err := Documents(original)
+	if err != nil {
+		return nil, err
+	}
+	modDocs, err := Documents(modified)
+	if err != nil {
+		return nil, err
+	}
+	if len(origDocs) > 1 || len(modDocs) > 1 {
+		return nil, errors.New("JSON Patch is not supported for multi-document configs")
+	}
+
+	ops := &yaml.Node{Kind: yaml.SequenceNode}
+	switch {
+	case len(origDocs) == 0 && len(modDocs) == 1:
+		clearComments(modDocs[0])
+		ops.Content = append(ops.Content, jsonPatchOp(JSONPatchReplace, "", documentRoot(modDocs[0])))
+	case len(origDocs) == 1 && len(modDocs) == 1:
+		clearComments(origDocs[0])
+		clearComments(modDocs[0])
+		jsonPatchNodes(documentRoot(origDocs[0]), documentRoot(modDocs[0]), "", ops)
+	}
+
+	if len(ops.Content) == 0 {
+		return []byte("[]\n"), nil
+	}
+
+	buffer := &bytes.Buffer{}
+	encoder := yaml.NewEncoder(buffer)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(ops); err != nil {
+		return nil, err
+	}
+	encoder.Close()
+
+	return buffer.Bytes(), nil
+}
+
+// jsonPatchNodes appends operations turning orig located at path into mod to ops.
+func jsonPatchNodes(orig, mod *yaml.Node, path string, ops *yaml.Node) {
+	if nodesEqual(orig, mod) {
+		return
+	}
+
+	switch {
+	case orig.Kind == yaml.MappingNode && mod.Kind == yaml.MappingNode:
+		jsonPatchMappings(orig, mod, path, ops)
+	case orig.Kind == yaml.SequenceNode && mod.Kind == yaml.SequenceNode:
+		jsonPatchSequences(orig, mod, path, ops)
+	default:
+		ops.Content = append(ops.Content, jsonPatchOp(JSONPatchReplace, path, mod))
+	}
+}
+
+func jsonPatchMappings(orig, mod *yaml.Node, path string, ops *yaml.Node) {
+	origMap := nodeMap(orig)
+	modMap := nodeMap(mod)
+
+	for i := 0; i+1 < len(orig.Content); i += 2 {
+		key := orig.Content[i].Value
+		if _, ok := modMap[key]; !ok {
+			ops.Content = append(ops.Content, jsonPatchOp(JSONPatchRemove, jsonPointer(path, key), nil))
+		}
+	}
+
+	for i := 0; i+1 < len(mod.Content); i += 2 {
+		key := mod.Content[i].Value
+		origVal, ok := origMap[key]
+		switch {
+		case !ok:
+			ops.Content = append(ops.Content, jsonPatchOp(JSONPatchAdd, jsonPointer(path, key), mod.Content[i+1]))
+		case origVal.Kind == mod.Content[i+1].Kind && origVal.Kind != yaml.ScalarNode:
+			jsonPatchNodes(origVal, mod.Content[i+1], jsonPointer(path, key), ops)
+		case !nodesEqual(origVal, mod.Content[i+1]):
+			ops.Content = append(ops.Content, jsonPatchOp(JSONPatchAdd, jsonPointer(path, key), mod.Content[i+1]))
+		}
+	}
+}
+
+// jsonPatchSequences emits operations for two lists based on their longest common subsequence,
+// items which are replaced in place are patched recursively.
+func jsonPatchSequences(orig, mod *yaml.Node, path string, ops *yaml.Node) {
+	a, b := orig.Content, mod.Content
+	n, m := len(a), len(b)
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if nodesEqual(a[i], b[j]) {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	// index is the position in the list being patched
+	i, j, index := 0, 0, 0
+	for i < n || j < m {
+		switch {
+		case i < n && j < m && nodesEqual(a[i], b[j]):
+			i, j, index = i+1, j+1, index+1
+		case i < n && j < m && lcs[i+1][j+1] == lcs[i][j]:
+			jsonPatchNodes(a[i], b[j], jsonPointer(path, strconv.Itoa(index)), ops)
+			i, j, index = i+1, j+1, index+1
+		case j < m && (i == n || lcs[i][j+1] >= lcs[i+1][j]):
+			itemPath := jsonPointer(path, strconv.Itoa(index))
+			if i == n {
+				itemPath = jsonPointer(path, "-")
+			}
+			ops.Content = append(ops.Content, jsonPatchOp(JSONPatchAdd, itemPath, b[j]))
+			j, index = j+1, index+1
+		default:
+			ops.Content = append(ops.Content, jsonPatchOp(JSONPatchRemove, jsonPointer(path, strconv.Itoa(index)), nil))
+			i++
+		}
+	}
+}
+
+// jsonPatchOp builds a single JSON Patch operation, value is omitted when nil.
+func jsonPatchOp(op, path string, value *yaml.Node) *yaml.Node {
+	node := &yaml.Node{Kind: yaml.MappingNode}
+	addNodeToDiff(node, "op", &yaml.Node{Kind: yaml.ScalarNode, Value: op})
+	addNodeToDiff(node, "path", &yaml.Node{Kind: yaml.ScalarNode, Value: path})
+	if value != nil {
+		addNodeToDiff(node, "value", value)
+	}
+	return node
+}
+
+// jsonPointer appends an escaped reference token to the JSON Pointer.
+func jsonPointer(path, token string) string {
+	return path + "/" + strings.NewReplacer("~", "~0", "/", "~1").Replace(token)
+}
@@ -0,0 +1,223 @@
+package yamltools
+
+import (
+	"gopkg.in/yaml.v3"
+)
+
+// Conflict is a value changed both in the current document and in the rendered one.
+type Conflict struct {
+	Path     string      `json:"path"`
+	Current  interface{} `json:"current,omitempty"`
+	Rendered interface{} `json:"rendered,omitempty"`
+}
+
+// Merge3 reapplies changes made to the base document in the current one on top of the rendered document.
+//
+// Documents are matched by kind and name, mapping keys by name, lists of mappings by their identity key
+// and other lists are merged as sets. Values changed differently on both sides are reported as conflicts,
+// the rendered value is kept for them.
+func Merge3(base, current, rendered []byte) ([]byte, []Conflict, error) {
+	baseDocs, err := documentIndex(base)
+	if err != nil {
+		return nil, nil, err
+	}
+	currentDocs, err := Documents(current)
+	if err != nil {
+		return nil, nil, err
+	}
+	renderedDocs, err := Documents(rendered)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	currentIndex := make(map[string]*yaml.Node, len(currentDocs))
+	for _, doc := range currentDocs {
+		currentIndex[DocumentID(doc)] = documentRoot(doc)
+	}
+
+	m := &merger{}
+	var docs []*yaml.Node
+	processed := make(map[string]bool)
+	for _, doc := range renderedDocs {
+		id := DocumentID(doc)
+		processed[id] = true
+		if merged := m.merge(documentPath(id), baseDocs[id], currentIndex[id], documentRoot(doc)); merged != nil {
+			docs = append(docs, merged)
+		}
+	}
+	for _, doc := range currentDocs {
+		id := DocumentID(doc)
+		if processed[id] {
+			continue
+		}
+		if merged := m.merge(documentPath(id), baseDocs[id], documentRoot(doc), nil); merged != nil {
+			docs = append(docs, merged)
+		}
+	}
+
+	if len(docs) == 0 {
+		return []byte{}, m.conflicts, nil
+	}
+
+	out, err := EncodeDocuments(docs)
+	if err != nil {
+		return nil, nil, err
+	}
+	return out, m.conflicts, nil
+}
+
+// documentIndex parses a YAML stream and returns its documents by ID.
+func documentIndex(data []byte) (map[string]*yaml.Node, error) {
+	docs, err := Documents(data)
+	if err != nil {
+		return nil, err
+	}
+
+	index := make(map[string]*yaml.Node, len(docs))
+	for _, doc := range docs {
+		index[DocumentID(doc)] = documentRoot(doc)
+	}
+	return index, nil
+}
+
+type merger struct {
+	conflicts []Conflict
+}
+
+// merge returns the rendered node with changes from base to current applied, nil means the node is removed.
+func (m *merger) merge(path string, base, current, rendered *yaml.Node) *yaml.Node {
+	switch {
+	case sameNode(base, current):
+		return rendered
+	case sameNode(base, rendered), sameNode(current, rendered):
+		return current
+	}
+
+	if current != nil && rendered != nil && current.Kind == rendered.Kind && (base == nil || base.Kind == current.Kind) {
+		switch current.Kind {
+		case yaml.MappingNode:
+			return m.mergeMappings(path, base, current, rendered)
+		case yaml.SequenceNode:
+			return m.mergeSequences(path, base, current, rendered)
+		}
+	}
+
+	m.conflicts = append(m.conflicts, Conflict{Path: path, Current: optionalValue(current), Rendered: optionalValue(rendered)})
+	return rendered
+}
+
+func (m *merger) mergeMappings(path string, base, current, rendered *yaml.Node) *yaml.Node {
+	baseMap := map[string]*yaml.Node{}
+	if base != nil {
+		baseMap = nodeMap(base)
+	}
+	currentMap := nodeMap(current)
+	renderedMap := nodeMap(rendered)
+
+	result := *rendered
+	result.Content = nil
+
+	// Keys go in the rendered order, keys added to the current document go last
+	keys := append([]*yaml.Node{}, keyNodes(rendered)...)
+	for _, key := range keyNodes(current) {
+		if _, ok := renderedMap[key.Value]; !ok {
+			keys = append(keys, key)
+		}
+	}
+
+	for _, key := range keys {
+		merged := m.merge(joinPath(path, key.Value), baseMap[key.Value], currentMap[key.Value], renderedMap[key.Value])
+		if merged != nil {
+			result.Content = append(result.Content, key, merged)
+		}
+	}
+	return &result
+}
+
+// mergeSequences merges lists of mappings item by item if they have a common identity key,
+// other lists are merged as sets: items added to the current list are appended to the rendered one
+// and items removed from it are removed from the rendered one.
+func (m *merger) mergeSequences(path string, base, current, rendered *yaml.Node) *yaml.Node {
+	if base == nil {
+		base = &yaml.Node{Kind: yaml.SequenceNode}
+	}
+
+	result := *rendered
+	result.Content = nil
+
+	if idKey := identityKey(base, current); idKey != "" && uniqueKey(rendered, idKey) {
+		baseItems := itemsByIdentity(base, idKey)
+		currentItems := itemsByIdentity(current, idKey)
+		renderedItems := itemsByIdentity(rendered, idKey)
+
+		items := append([]*yaml.Node{}, rendered.Content...)
+		for _, item := range current.Content {
+			if _, ok := renderedItems[identity(item, idKey)]; !ok {
+				items = append(items, item)
+			}
+		}
+
+		for _, item := range items {
+			id := identity(item, idKey)
+			itemPath := path + "[" + idKey + "=" + nodeMap(item)[idKey].Value + "]"
+			if merged := m.merge(itemPath, baseItems[id], currentItems[id], renderedItems[id]); merged != nil {
+				result.Content = append(result.Content, merged)
+			}
+		}
+		return &result
+	}
+
+	baseSet := canonicalSet(base)
+	currentSet := canonicalSet(current)
+	renderedSet := canonicalSet(rendered)
+	for _, item := range rendered.Content {
+		if c := canonical(item); !baseSet[c] || currentSet[c] {
+			result.Content = append(result.Content, item)
+		}
+	}
+	for _, item := range current.Content {
+		if c := canonical(item); !baseSet[c] && !renderedSet[c] {
+			result.Content = append(result.Content, item)
+		}
+	}
+	return &result
+}
+
+// sameNode reports whether both nodes are absent or have the same content.
+func sameNode(a, b *yaml.Node) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return nodesEqual(a, b)
+}
+
+func keyNodes(node *yaml.Node) []*yaml.Node {
+	keys := make([]*yaml.Node, 0, len(node.Content)/2)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		keys = append(keys, node.Content[i])
+	}
+	return keys
+}
+
+func itemsByIdentity(seq *yaml.Node, idKey string) map[string]*yaml.Node {
+	items := make(map[string]*yaml.Node, len(seq.Content))
+	for _, item := range seq.Content {
+		items[identity(item, idKey)] = item
+	}
+	return items
+}
+
+func canonicalSet(seq *yaml.Node) map[string]bool {
+	set := make(map[string]bool, len(seq.Content))
+	for _, item := range seq.Content {
+		set[canonical(item)] = true
+	}
+	return set
+}
+
+func optionalValue(node *yaml.Node) interface{} {
+	if node == nil {
+		return nil
+	}
+	return nodeValue(node)
+}
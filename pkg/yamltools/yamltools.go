@@ -3,7 +3,9 @@
 package yamltools
 
 import (
-	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
 	"strings"
 
 	"gopkg.in/yaml.v3"
@@ -18,7 +20,7 @@ func CopyComments(srcNode, dstNode *yaml.Node, path string, dstPaths map[string]
 	for i := 0; i < len(srcNode.Content); i++ {
 		newPath := path + "/" + srcNode.Content[i].Value
 		if srcNode.Kind == yaml.SequenceNode {
-			newPath = path + "/" + string(i)
+			newPath = path + "/" + strconv.Itoa(i)
 		}
 		CopyComments(srcNode.Content[i], dstNode, newPath, dstPaths)
 	}
@@ -35,7 +37,7 @@ func ApplyComments(dstNode *yaml.Node, path string, dstPaths map[string]*yaml.No
 	for i := 0; i < len(dstNode.Content); i++ {
 		newPath := path + "/" + dstNode.Content[i].Value
 		if dstNode.Kind == yaml.SequenceNode {
-			newPath = path + "/" + string(i)
+			newPath = path + "/" + strconv.Itoa(i)
 		}
 		ApplyComments(dstNode.Content[i], newPath, dstPaths)
 	}
@@ -52,33 +54,70 @@ func mergeComments(oldComment, newComment string) string {
 	return strings.TrimSpace(oldComment) + "\n\n" + strings.TrimSpace(newComment)
 }
 
-// DiffYAMLs compares two YAML documents and outputs the differences.
+// DiffYAMLs compares two YAML streams and outputs the differences as a strategic merge patch.
+//
+// Documents are matched by their kind and name. Documents missing in the modified stream
+// are deleted by the patch, new documents are added as is.
 func DiffYAMLs(original, modified []byte) ([]byte, error) {
-	var origNode, modNode yaml.Node
-	if err := yaml.Unmarshal(original, &origNode); err != nil {
+	origDocs, err := Documents(original)
+	if err != nil {
 		return nil, err
 	}
-	if err := yaml.Unmarshal(modified, &modNode); err != nil {
+	modDocs, err := Documents(modified)
+	if err != nil {
 		return nil, err
 	}
 
-	clearComments(&origNode)
-	clearComments(&modNode)
+	origIndex := make(map[string]*yaml.Node, len(origDocs))
+	for _, doc := range origDocs {
+		clearComments(doc)
+		origIndex[DocumentID(doc)] = documentRoot(doc)
+	}
 
-	diff := compareNodes(origNode.Content[0], modNode.Content[0])
-	if diff == nil {
-		return []byte{}, nil
+	var diffs []*yaml.Node
+	processed := make(map[string]bool)
+	for _, doc := range modDocs {
+		clearComments(doc)
+		id := DocumentID(doc)
+		root := documentRoot(doc)
+		processed[id] = true
+
+		orig, ok := origIndex[id]
+		if !ok {
+			diffs = append(diffs, root)
+			continue
+		}
+
+		d := &differ{}
+		diff := d.diffDocuments(documentPath(id), orig, root)
+		if len(d.removed) > 0 {
+			return nil, fmt.Errorf("items removed from %s can't be expressed by a strategic merge patch, as lists are concatenated: use --patch-format jsonpatch or --full", strings.Join(d.removed, ", "))
+		}
+		if diff == nil {
+			continue
+		}
+		if id != LegacyDocumentID && diff.Kind == yaml.MappingNode {
+			header := documentHeader(root)
+			diff.Content = append(header.Content, diff.Content...)
+		}
+		diffs = append(diffs, diff)
 	}
 
-	buffer := &bytes.Buffer{}
-	encoder := yaml.NewEncoder(buffer)
-	encoder.SetIndent(2)
-	if err := encoder.Encode(diff); err != nil {
-		return nil, err
+	for _, doc := range origDocs {
+		id := DocumentID(doc)
+		if processed[id] || id == LegacyDocumentID {
+			continue
+		}
+		deleteDoc := documentHeader(documentRoot(doc))
+		deleteDoc.Content = append(deleteDoc.Content, createDeleteNode().Content...)
+		diffs = append(diffs, deleteDoc)
+	}
+
+	if len(diffs) == 0 {
+		return []byte{}, nil
 	}
-	encoder.Close()
 
-	return buffer.Bytes(), nil
+	return EncodeDocuments(diffs)
 }
 
 // clearComments cleans up comments in YAML nodes.
@@ -91,23 +130,56 @@ func clearComments(node *yaml.Node) {
 	}
 }
 
-// compareNodes recursively finds differences between two YAML nodes.
-func compareNodes(orig, mod *yaml.Node) *yaml.Node {
+// Keys of lists which are merged by Talos item by item using the identity key,
+// other lists are merged by concatenation.
+var mergedListKeys = map[string]bool{
+	"interfaces":       true,
+	"vlans":            true,
+	"admissionControl": true,
+}
+
+// Keys of lists which are replaced by Talos as a whole.
+var replacedListKeys = map[string]bool{
+	"podSubnets":     true,
+	"serviceSubnets": true,
+	"ports":          true,
+	"ingress":        true,
+}
+
+// identityKeys are keys used to match mapping items of two lists, in order of preference.
+var identityKeys = []string{"interface", "deviceSelector", "vlanId", "name", "path", "network", "destination", "device"}
+
+// differ finds differences between two YAML nodes and collects changes the patch can't express.
+type differ struct {
+	removed []string // paths of lists with removed items
+}
+
+// diffDocuments recursively finds differences between two YAML nodes.
+func (d *differ) diffDocuments(path string, orig, mod *yaml.Node) *yaml.Node {
+	diff, _ := d.diffNodes(path, "", orig, mod, false)
+	return diff
+}
+
+// diffNodes returns a patch turning orig into mod, key is the mapping key holding the nodes at path.
+// The second result is false when the change can't be expressed by a patch at this level:
+// keys inside list items can't be deleted and lists are merged by concatenation, so the
+// caller has to replace the whole list item instead.
+func (d *differ) diffNodes(path, key string, orig, mod *yaml.Node, inSequence bool) (*yaml.Node, bool) {
 	if orig.Kind != mod.Kind {
-		return mod
+		return mod, !inSequence
 	}
 
 	switch orig.Kind {
 	case yaml.MappingNode:
-		return compareMappingNodes(orig, mod)
+		return d.compareMappingNodes(path, orig, mod, inSequence)
 	case yaml.SequenceNode:
-		return compareSequenceNodes(orig, mod)
+		return d.compareSequenceNodes(key, orig, mod, inSequence)
 	case yaml.ScalarNode:
 		if orig.Value != mod.Value {
-			return mod
+			return mod, true
 		}
 	}
-	return nil
+	return nil, true
 }
 
 func createDeleteNode() *yaml.Node {
@@ -120,9 +192,16 @@ func createDeleteNode() *yaml.Node {
 	}
 }
 
+// createItemDeleteNode returns a directive deleting the list item with the given identity.
+func createItemDeleteNode(idKey string, idValue *yaml.Node) *yaml.Node {
+	node := createDeleteNode()
+	node.Content = append([]*yaml.Node{{Kind: yaml.ScalarNode, Value: idKey}, idValue}, node.Content...)
+	return node
+}
+
 // compareMappingNodes compares two mapping nodes and returns differences,
 // prioritizing the order in the modified document but considering original document order where possible.
-func compareMappingNodes(orig, mod *yaml.Node) *yaml.Node {
+func (d *differ) compareMappingNodes(path string, orig, mod *yaml.Node, inSequence bool) (*yaml.Node, bool) {
 	diff := &yaml.Node{Kind: yaml.MappingNode}
 	origMap := nodeMap(orig)
 	modMap := nodeMap(mod)
@@ -136,7 +215,16 @@ func compareMappingNodes(orig, mod *yaml.Node) *yaml.Node {
 
 		if origExists {
 			processedKeys[key] = true
-			changedNode := compareNodes(origVal, modVal)
+			changedNode, ok := d.diffNodes(joinPath(path, key), key, origVal, modVal, inSequence)
+			if !ok {
+				if inSequence {
+					return nil, false
+				}
+				if removedItems(origVal, modVal) {
+					d.removed = append(d.removed, joinPath(path, key))
+				}
+				changedNode = appendedItems(origVal, modVal)
+			}
 			if changedNode != nil {
 				addNodeToDiff(diff, key, changedNode)
 			}
@@ -149,6 +237,9 @@ func compareMappingNodes(orig, mod *yaml.Node) *yaml.Node {
 	for i := 0; i < len(orig.Content); i += 2 {
 		key := orig.Content[i].Value
 		if !processedKeys[key] {
+			if inSequence {
+				return nil, false
+			}
 			origVal := origMap[key]
 			if origVal.Kind == yaml.MappingNode {
 				nestedDelete := &yaml.Node{Kind: yaml.MappingNode}
@@ -164,34 +255,211 @@ func compareMappingNodes(orig, mod *yaml.Node) *yaml.Node {
 	}
 
 	if len(diff.Content) == 0 {
-		return nil
+		return nil, true
 	}
-	return diff
+	return diff, true
 }
 
 // compareSequenceNodes compares two sequence nodes and returns differences.
-func compareSequenceNodes(orig, mod *yaml.Node) *yaml.Node {
-	diff := &yaml.Node{Kind: yaml.SequenceNode}
-	origSet := nodeSet(orig)
+func (d *differ) compareSequenceNodes(key string, orig, mod *yaml.Node, inSequence bool) (*yaml.Node, bool) {
+	if nodesEqual(orig, mod) {
+		return nil, true
+	}
+	if replacedListKeys[key] {
+		return mod, true
+	}
+	if idKey := identityKey(orig, mod); idKey != "" {
+		return d.compareSequenceItems(key, idKey, orig, mod, inSequence)
+	}
+
+	// Lists are concatenated, so only appended items can be expressed
+	if len(mod.Content) < len(orig.Content) {
+		return nil, false
+	}
+	for i, item := range orig.Content {
+		if !nodesEqual(item, mod.Content[i]) {
+			return nil, false
+		}
+	}
+	return &yaml.Node{Kind: yaml.SequenceNode, Content: mod.Content[len(orig.Content):]}, true
+}
+
+// compareSequenceItems compares lists of mappings matching their items by idKey.
+//
+// Items of the original list are kept in place while they go in the same order as in the modified one.
+// Changes of kept items are emitted as nested patches for lists merged item by item, other items are
+// deleted and added again, as Talos applies delete directives first and appends new items to the end.
+//
+// A nested patch can't express every change: lists inside an item, e.g. addresses of an interface, are
+// concatenated and keys inside list items can't be deleted. Such an item is deleted and added again,
+// and so are the items after it, to keep the order of the list.
+func (d *differ) compareSequenceItems(key, idKey string, orig, mod *yaml.Node, inSequence bool) (*yaml.Node, bool) {
+	origIndex := make(map[string]int, len(orig.Content))
+	for i, item := range orig.Content {
+		origIndex[identity(item, idKey)] = i
+	}
+
+	kept := make(map[int]bool)
+	var items []*yaml.Node
+	last := -1
+	appending := false
 	for _, modItem := range mod.Content {
-		if !origSet[modItem.Value] {
-			diff.Content = append(diff.Content, modItem)
+		i, found := origIndex[identity(modItem, idKey)]
+		if found && !appending && i > last {
+			if nodesEqual(orig.Content[i], modItem) {
+				kept[i] = true
+				last = i
+				continue
+			}
+			if mergedListKeys[key] {
+				if changed, ok := d.compareMappingNodes("", orig.Content[i], modItem, true); ok {
+					kept[i] = true
+					last = i
+					if changed != nil {
+						items = append(items, withIdentity(changed, modItem, idKey))
+					}
+					continue
+				}
+			}
 		}
+		appending = true
+		items = append(items, modItem)
 	}
 
-	if len(diff.Content) == 0 {
-		return nil
+	var deletes []*yaml.Node
+	for i, item := range orig.Content {
+		if kept[i] {
+			continue
+		}
+		idValue := nodeMap(item)[idKey]
+		// Talos can delete list items only by a string key and not inside other lists
+		if inSequence || idValue.Kind != yaml.ScalarNode || idValue.ShortTag() != "!!str" {
+			return nil, false
+		}
+		deletes = append(deletes, createItemDeleteNode(idKey, idValue))
 	}
-	return diff
+
+	if len(deletes)+len(items) == 0 {
+		return nil, true
+	}
+	return &yaml.Node{Kind: yaml.SequenceNode, Content: append(deletes, items...)}, true
 }
 
-// nodeSet creates a set of values from sequence nodes.
-func nodeSet(node *yaml.Node) map[string]bool {
-	result := make(map[string]bool)
-	for _, item := range node.Content {
-		result[item.Value] = true
+// withIdentity returns the changed keys of a list item prefixed with its identity key.
+func withIdentity(changed, item *yaml.Node, idKey string) *yaml.Node {
+	node := &yaml.Node{Kind: yaml.MappingNode}
+	addNodeToDiff(node, idKey, nodeMap(item)[idKey])
+	for i := 0; i < len(changed.Content); i += 2 {
+		if changed.Content[i].Value != idKey {
+			node.Content = append(node.Content, changed.Content[i], changed.Content[i+1])
+		}
 	}
-	return result
+	return node
+}
+
+// identityKey returns the first of identityKeys present in every item of both lists
+// and unique within each of them, or an empty string.
+func identityKey(orig, mod *yaml.Node) string {
+	for _, key := range identityKeys {
+		if uniqueKey(orig, key) && uniqueKey(mod, key) {
+			return key
+		}
+	}
+	return ""
+}
+
+func uniqueKey(seq *yaml.Node, key string) bool {
+	seen := make(map[string]bool, len(seq.Content))
+	for _, item := range seq.Content {
+		if item.Kind != yaml.MappingNode {
+			return false
+		}
+		if _, ok := nodeMap(item)[key]; !ok {
+			return false
+		}
+		id := identity(item, key)
+		if seen[id] {
+			return false
+		}
+		seen[id] = true
+	}
+	return true
+}
+
+// identity returns the canonical representation of the key value of a list item.
+func identity(item *yaml.Node, key string) string {
+	return canonical(nodeMap(item)[key])
+}
+
+// canonical returns a string representation of the node which doesn't depend on the order of mapping keys.
+func canonical(node *yaml.Node) string {
+	switch node.Kind {
+	case yaml.MappingNode:
+		pairs := make([]string, 0, len(node.Content)/2)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			pairs = append(pairs, strconv.Quote(node.Content[i].Value)+":"+canonical(node.Content[i+1]))
+		}
+		sort.Strings(pairs)
+		return "{" + strings.Join(pairs, ",") + "}"
+	case yaml.SequenceNode:
+		items := make([]string, 0, len(node.Content))
+		for _, item := range node.Content {
+			items = append(items, canonical(item))
+		}
+		return "[" + strings.Join(items, ",") + "]"
+	case yaml.AliasNode:
+		return canonical(node.Alias)
+	default:
+		return strconv.Quote(node.Value)
+	}
+}
+
+// nodesEqual reports whether two nodes have the same content regardless of the order of mapping keys.
+func nodesEqual(a, b *yaml.Node) bool {
+	return canonical(a) == canonical(b)
+}
+
+// removedItems reports whether items of the original list are missing in the modified one.
+func removedItems(orig, mod *yaml.Node) bool {
+	if orig.Kind != yaml.SequenceNode || mod.Kind != yaml.SequenceNode {
+		return false
+	}
+
+	modSet := make(map[string]bool, len(mod.Content))
+	for _, item := range mod.Content {
+		modSet[canonical(item)] = true
+	}
+	for _, item := range orig.Content {
+		if !modSet[canonical(item)] {
+			return true
+		}
+	}
+	return false
+}
+
+// appendedItems returns items of the modified list missing in the original one.
+// It's used when the list change can't be expressed exactly, e.g. when items were reordered.
+func appendedItems(orig, mod *yaml.Node) *yaml.Node {
+	if orig.Kind != yaml.SequenceNode || mod.Kind != yaml.SequenceNode {
+		return mod
+	}
+
+	origSet := make(map[string]bool, len(orig.Content))
+	for _, item := range orig.Content {
+		origSet[canonical(item)] = true
+	}
+
+	diff := &yaml.Node{Kind: yaml.SequenceNode}
+	for _, item := range mod.Content {
+		if !origSet[canonical(item)] {
+			diff.Content = append(diff.Content, item)
+		}
+	}
+
+	if len(diff.Content) == 0 {
+		return nil
+	}
+	return diff
 }
 
 // addNodeToDiff adds a node to the diff result.
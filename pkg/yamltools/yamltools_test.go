@@ -0,0 +1,528 @@
+package yamltools
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/siderolabs/talos/pkg/machinery/config/configpatcher"
+	"github.com/siderolabs/talos/pkg/machinery/config/encoder"
+	"github.com/siderolabs/talos/pkg/machinery/config/generate"
+	"github.com/siderolabs/talos/pkg/machinery/config/generate/secrets"
+	"github.com/siderolabs/talos/pkg/machinery/config/machine"
+	"github.com/siderolabs/talos/pkg/machinery/constants"
+	"gopkg.in/yaml.v3"
+	k8syaml "sigs.k8s.io/yaml"
+)
+
+const baseConfig = `machine:
+  type: worker
+  certSANs:
+    - 1.2.3.4
+  network:
+    hostname: node1
+    interfaces:
+      - interface: eth0
+        addresses:
+          - 1.2.3.4/26
+        routes:
+          - network: 0.0.0.0/0
+            gateway: 1.2.3.1
+      - interface: eth1
+        dhcp: true
+  files:
+    - path: /etc/a
+      content: a
+    - path: /etc/b
+      content: b
+cluster:
+  network:
+    podSubnets:
+      - 10.244.0.0/16
+`
+
+func TestDiffYAMLs(t *testing.T) {
+	testCases := []struct {
+		name     string
+		modified string
+		want     string
+	}{
+		{
+			name:     "no changes",
+			modified: baseConfig,
+			want:     "",
+		},
+		{
+			name:     "changed field of merged list item",
+			modified: strings.Replace(baseConfig, "        dhcp: true\n", "        dhcp: true\n        mtu: 9000\n", 1),
+			want: `machine:
+  network:
+    interfaces:
+      - interface: eth1
+        mtu: 9000
+`,
+		},
+		{
+			// Addresses of a merged interface are concatenated, so the interface is added again,
+			// and the following ones too to keep their order
+			name:     "replaced address inside list item",
+			modified: strings.Replace(baseConfig, "1.2.3.4/26", "1.2.3.5/26", 1),
+			want: `machine:
+  network:
+    interfaces:
+      - interface: eth0
+        $patch: delete
+      - interface: eth1
+        $patch: delete
+      - interface: eth0
+        addresses:
+          - 1.2.3.5/26
+        routes:
+          - network: 0.0.0.0/0
+            gateway: 1.2.3.1
+      - interface: eth1
+        dhcp: true
+`,
+		},
+		{
+			name:     "removed list item",
+			modified: strings.Replace(baseConfig, "    - path: /etc/a\n      content: a\n", "", 1),
+			want: `machine:
+  files:
+    - path: /etc/a
+      $patch: delete
+`,
+		},
+		{
+			name:     "changed concatenated list item",
+			modified: strings.Replace(baseConfig, "content: a", "content: c", 1),
+			want: `machine:
+  files:
+    - path: /etc/a
+      $patch: delete
+    - path: /etc/b
+      $patch: delete
+    - path: /etc/a
+      content: c
+    - path: /etc/b
+      content: b
+`,
+		},
+		{
+			name:     "appended scalar",
+			modified: strings.Replace(baseConfig, "    - 1.2.3.4\n", "    - 1.2.3.4\n    - 1.2.3.5\n", 1),
+			want: `machine:
+  certSANs:
+    - 1.2.3.5
+`,
+		},
+		{
+			name:     "replaced list",
+			modified: strings.Replace(baseConfig, "10.244.0.0/16", "10.245.0.0/16", 1),
+			want: `cluster:
+  network:
+    podSubnets:
+      - 10.245.0.0/16
+`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := DiffYAMLs([]byte(baseConfig), []byte(tc.modified))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tc.want {
+				t.Errorf("DiffYAMLs() got:\n%s\nwant:\n%s", got, tc.want)
+			}
+
+			assertRoundTrip(t, baseConfig, tc.modified)
+		})
+	}
+}
+
+func TestDiffYAMLsRoundTrip(t *testing.T) {
+	modified := []string{
+		strings.Replace(baseConfig, "    - interface: eth1\n      dhcp: true\n", "", 1),
+		strings.Replace(baseConfig, "      - interface: eth0\n", "      - interface: eth2\n        dhcp: true\n      - interface: eth0\n", 1),
+		strings.Replace(baseConfig, "    hostname: node1\n", "", 1),
+		strings.Replace(baseConfig, "gateway: 1.2.3.1", "gateway: 1.2.3.2", 1),
+		strings.Replace(baseConfig, "        routes:\n", "        mtu: 1500\n        routes:\n", 1),
+	}
+
+	for _, mod := range modified {
+		assertRoundTrip(t, baseConfig, mod)
+	}
+}
+
+func TestDiffYAMLsRemovedItems(t *testing.T) {
+	modified := []string{
+		strings.Replace(baseConfig, "  certSANs:\n    - 1.2.3.4\n", "  certSANs:\n    - 1.2.3.5\n    - 1.2.3.6\n", 1),
+		strings.Replace(baseConfig, "  certSANs:\n    - 1.2.3.4\n", "  certSANs:\n    - 1.2.3.5\n", 1),
+	}
+
+	for _, mod := range modified {
+		orig := strings.Replace(baseConfig, "    - 1.2.3.4\n", "    - 1.2.3.4\n    - 1.2.3.5\n", 1)
+		_, err := DiffYAMLs([]byte(orig), []byte(mod))
+		if err == nil {
+			t.Fatalf("expected an error for removed items, got none")
+		}
+		if !strings.Contains(err.Error(), "machine.certSANs") || !strings.Contains(err.Error(), "--patch-format jsonpatch") {
+			t.Errorf("unexpected error: %s", err)
+		}
+	}
+
+	// Reordered items can still be expressed as no items were removed
+	reordered := strings.Replace(baseConfig, "    - 1.2.3.4\n", "    - 1.2.3.5\n    - 1.2.3.4\n", 1)
+	orig := strings.Replace(baseConfig, "    - 1.2.3.4\n", "    - 1.2.3.4\n    - 1.2.3.5\n", 1)
+	if _, err := DiffYAMLs([]byte(orig), []byte(reordered)); err != nil {
+		t.Errorf("unexpected error for reordered items: %s", err)
+	}
+}
+
+func TestDiffYAMLsMultiDocument(t *testing.T) {
+	original := `machine:
+  type: worker
+---
+apiVersion: v1alpha1
+kind: ExtensionServiceConfig
+name: nut
+environment:
+  - A=1
+---
+apiVersion: v1alpha1
+kind: ExtensionServiceConfig
+name: old
+environment:
+  - B=1
+`
+	modified := `machine:
+  type: controlplane
+---
+apiVersion: v1alpha1
+kind: ExtensionServiceConfig
+name: nut
+environment:
+  - A=1
+  - C=1
+---
+apiVersion: v1alpha1
+kind: NetworkRuleConfig
+name: ingress
+portSelector:
+  ports:
+    - 50000
+`
+	want := `machine:
+  type: controlplane
+---
+apiVersion: v1alpha1
+kind: ExtensionServiceConfig
+name: nut
+environment:
+  - C=1
+---
+apiVersion: v1alpha1
+kind: NetworkRuleConfig
+name: ingress
+portSelector:
+  ports:
+    - 50000
+---
+apiVersion: v1alpha1
+kind: ExtensionServiceConfig
+name: old
+$patch: delete
+`
+
+	got, err := DiffYAMLs([]byte(original), []byte(modified))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Errorf("DiffYAMLs() got:\n%s\nwant:\n%s", got, want)
+	}
+
+	if _, err := JSONPatch([]byte(original), []byte(modified)); err == nil {
+		t.Error("JSONPatch() expected error for multi-document config")
+	}
+}
+
+func TestDiffYAMLsReplacedIngress(t *testing.T) {
+	rule := `---
+apiVersion: v1alpha1
+kind: NetworkRuleConfig
+name: kubelet
+portSelector:
+  ports:
+    - 10250
+  protocol: tcp
+ingress:
+  - subnet: 10.0.0.0/8
+  - subnet: 172.16.0.0/12
+`
+	original := baseConfig + rule
+	modified := baseConfig + strings.Replace(rule, "  - subnet: 10.0.0.0/8\n", "", 1)
+	want := `apiVersion: v1alpha1
+kind: NetworkRuleConfig
+name: kubelet
+ingress:
+  - subnet: 172.16.0.0/12
+`
+
+	got, err := DiffYAMLs([]byte(original), []byte(modified))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Errorf("DiffYAMLs() got:\n%s\nwant:\n%s", got, want)
+	}
+
+	assertRoundTrip(t, original, modified)
+}
+
+func TestChanges(t *testing.T) {
+	testCases := []struct {
+		name     string
+		modified string
+		want     []string
+	}{
+		{
+			name:     "no changes",
+			modified: baseConfig,
+		},
+		{
+			name:     "changed scalar",
+			modified: strings.Replace(baseConfig, "hostname: node1", "hostname: node2", 1),
+			want:     []string{"replace machine.network.hostname node1 -> node2"},
+		},
+		{
+			name:     "removed key",
+			modified: strings.Replace(baseConfig, "  type: worker\n", "", 1),
+			want:     []string{"remove machine.type worker -> <nil>"},
+		},
+		{
+			name:     "appended scalar",
+			modified: strings.Replace(baseConfig, "    - 1.2.3.4\n", "    - 1.2.3.4\n    - 1.2.3.5\n", 1),
+			want:     []string{"add machine.certSANs[1] <nil> -> 1.2.3.5"},
+		},
+		{
+			name:     "changed address inside list item",
+			modified: strings.Replace(baseConfig, "1.2.3.4/26", "1.2.3.5/26", 1),
+			want:     []string{"replace machine.network.interfaces[interface=eth0].addresses[0] 1.2.3.4/26 -> 1.2.3.5/26"},
+		},
+		{
+			name: "removed first list item",
+			modified: strings.Replace(baseConfig, `      - interface: eth0
+        addresses:
+          - 1.2.3.4/26
+        routes:
+          - network: 0.0.0.0/0
+            gateway: 1.2.3.1
+`, "", 1),
+			want: []string{"remove machine.network.interfaces[interface=eth0] " +
+				"map[addresses:[1.2.3.4/26] interface:eth0 routes:[map[gateway:1.2.3.1 network:0.0.0.0/0]]] -> <nil>"},
+		},
+		{
+			name:     "reordered list items",
+			modified: strings.Replace(baseConfig, "path: /etc/a\n      content: a\n    - path: /etc/b\n      content: b", "path: /etc/b\n      content: b\n    - path: /etc/a\n      content: a", 1),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			changes, err := Changes([]byte(baseConfig), []byte(tc.modified))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, change := range changes {
+				got = append(got, fmt.Sprintf("%s %s %v -> %v", change.Op, change.Path, change.Old, change.New))
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Changes() got:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(tc.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestCopyComments(t *testing.T) {
+	var src, dst yaml.Node
+	if err := yaml.Unmarshal([]byte("list:\n  - a # first\n  - b # second\n"), &src); err != nil {
+		t.Fatal(err)
+	}
+	if err := yaml.Unmarshal([]byte("list:\n  - a\n  - b\n"), &dst); err != nil {
+		t.Fatal(err)
+	}
+
+	paths := make(map[string]*yaml.Node)
+	CopyComments(&src, &dst, "", paths)
+	ApplyComments(&dst, "", paths)
+
+	out, err := yaml.Marshal(&dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "list:\n    - a # first\n    - b # second\n"; string(out) != want {
+		t.Errorf("got:\n%s\nwant:\n%s", out, want)
+	}
+}
+
+// workerConfig generates a worker config once, as generating secrets is slow.
+var workerConfig = sync.OnceValues(func() ([]byte, error) {
+	bundle, err := secrets.NewBundle(secrets.NewFixedClock(time.Now()), nil)
+	if err != nil {
+		return nil, err
+	}
+	input, err := generate.NewInput("test", "https://1.2.3.4:6443", constants.DefaultKubernetesVersion, generate.WithSecretsBundle(bundle))
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := input.Config(machine.TypeWorker)
+	if err != nil {
+		return nil, err
+	}
+	return cfg.EncodeBytes(encoder.WithComments(encoder.CommentsDisabled))
+})
+
+// generatedConfig returns a worker config generated by Talos with the patch applied,
+// so that patches are checked against the real config schema and merge rules.
+func generatedConfig(t *testing.T, patch string) []byte {
+	t.Helper()
+
+	data, err := workerConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return applyPatch(t, data, patch)
+}
+
+// applyPatch applies the strategic merge patch to the config the way Talos does.
+func applyPatch(t *testing.T, cfg []byte, patch string) []byte {
+	t.Helper()
+
+	if patch == "" {
+		return cfg
+	}
+	patches, err := configpatcher.LoadPatches([]string{patch})
+	if err != nil {
+		t.Fatalf("invalid patch:\n%s\n%v", patch, err)
+	}
+	out, err := configpatcher.Apply(configpatcher.WithBytes(cfg), patches)
+	if err != nil {
+		t.Fatalf("failed to apply patch:\n%s\n%v", patch, err)
+	}
+	result, err := out.Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := result.EncodeBytes(encoder.WithComments(encoder.CommentsDisabled))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return data
+}
+
+// assertRoundTrip checks that the patch between generated configs with the original and modified
+// changes applied turns the original config into the modified one when applied by Talos.
+func assertRoundTrip(t *testing.T, original, modified string) {
+	t.Helper()
+
+	orig := generatedConfig(t, original)
+	mod := generatedConfig(t, modified)
+	patch, err := DiffYAMLs(orig, mod)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result := applyPatch(t, orig, string(patch)); string(result) != string(mod) {
+		t.Errorf("patch doesn't round-trip, patch:\n%s\nresult:\n%s\nexpected:\n%s", patch, result, mod)
+	}
+}
+
+func TestJSONPatch(t *testing.T) {
+	modified := []string{
+		baseConfig,
+		strings.Replace(baseConfig, "1.2.3.4/26", "1.2.3.5/26", 1),
+		strings.Replace(baseConfig, "    - path: /etc/a\n      content: a\n", "", 1),
+		strings.Replace(baseConfig, "    hostname: node1\n", "    hostname: node~1/a\n    nameservers: [8.8.8.8]\n", 1),
+		strings.Replace(baseConfig, "      - interface: eth0\n", "      - interface: eth2\n        dhcp: true\n      - interface: eth0\n", 1),
+		strings.Replace(baseConfig, "    - 1.2.3.4\n", "    - 1.2.3.0\n    - 1.2.3.4\n    - 1.2.3.5\n", 1),
+		"machine:\n  type: controlplane\n",
+	}
+
+	for _, mod := range modified {
+		patch, err := JSONPatch([]byte(baseConfig), []byte(mod))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		patchJSON, err := k8syaml.YAMLToJSON(patch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := jsonpatch.DecodePatch(patchJSON)
+		if err != nil {
+			t.Fatalf("invalid patch:\n%s\n%v", patch, err)
+		}
+
+		origJSON, err := k8syaml.YAMLToJSON([]byte(baseConfig))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resultJSON, err := decoded.Apply(origJSON)
+		if err != nil {
+			t.Fatalf("failed to apply patch:\n%s\n%v", patch, err)
+		}
+
+		var result, want interface{}
+		if err := k8syaml.Unmarshal(resultJSON, &result); err != nil {
+			t.Fatal(err)
+		}
+		if err := k8syaml.Unmarshal([]byte(mod), &want); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(result, want) {
+			t.Errorf("patch doesn't round-trip, patch:\n%s\nresult:\n%s", patch, resultJSON)
+		}
+	}
+}
+
+func TestMerge3(t *testing.T) {
+	current := strings.Replace(baseConfig, "        dhcp: true\n", "        dhcp: true\n        mtu: 9000\n", 1)
+	current = strings.Replace(current, "    - 1.2.3.4\n", "    - 1.2.3.4\n    - 5.6.7.8\n", 1)
+	rendered := strings.Replace(baseConfig, "hostname: node1", "hostname: node2", 1)
+	rendered = strings.Replace(rendered, "    - path: /etc/b\n      content: b\n", "", 1)
+
+	got, conflicts, err := Merge3([]byte(baseConfig), []byte(current), []byte(rendered))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conflicts) != 0 {
+		t.Errorf("unexpected conflicts: %v", conflicts)
+	}
+
+	want := strings.Replace(current, "hostname: node1", "hostname: node2", 1)
+	want = strings.Replace(want, "    - path: /etc/b\n      content: b\n", "", 1)
+	if string(got) != want {
+		t.Errorf("Merge3() got:\n%s\nwant:\n%s", got, want)
+	}
+
+	current = strings.Replace(baseConfig, "hostname: node1", "hostname: custom", 1)
+	_, conflicts, err = Merge3([]byte(baseConfig), []byte(current), []byte(rendered))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantConflicts := []Conflict{{Path: "machine.network.hostname", Current: "custom", Rendered: "node2"}}
+	if !reflect.DeepEqual(conflicts, wantConflicts) {
+		t.Errorf("Merge3() conflicts got = %v, want %v", conflicts, wantConflicts)
+	}
+}